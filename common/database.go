@@ -58,9 +58,26 @@ func dbAutoMigrate() {
 		&model.Headscale{},
 		&model.Api{},
 		&model.OperationLog{},
+		&model.ScimToken{},
+		&model.Session{},
+		&model.PersonalAccessToken{},
+		&model.UserInvite{},
+		&model.HeadscaleUserMapping{},
+		&model.OAuthToken{},
+		&model.OAuthClient{},
+		&model.OIDCSigningKey{},
+		&model.OAuthConsent{},
+		&model.ACLVersion{},
+		&model.ACLRoleTemplate{},
+		&model.HeadscaleConfigVersion{},
 		//&model.Message{},
 	); err != nil {
 		log.Log.Error(err)
 		panic(err)
 	}
+	// The users without an email were stored with an empty one, they are NULL now to not conflict with each other
+	if err := DB.Unscoped().Model(&model.User{}).Where("email = ?", "").Update("email", gorm.Expr("NULL")).Error; err != nil {
+		log.Log.Error(err)
+		panic(err)
+	}
 }
@@ -66,6 +66,7 @@ func InitHeadscale() {
 				Authorization: config.Conf.Headscale.OIDC.Authorize,
 				ClientID:      config.Conf.Headscale.OIDC.ClientID,
 				ClientSecret:  config.Conf.Headscale.OIDC.ClientSecret,
+				RedirectURIs:  config.Conf.Headscale.OIDC.RedirectURIs,
 			},
 		}
 		headscaleConfigValue.Store(headscaleConfig)
@@ -18,7 +18,7 @@ func InitData() {
 	}
 	// Adjustment of the availability of some debugging interfaces and menus according to the operating mode
 	hidden := 2
-	if config.GetMode() >= config.MULTI {
+	if !config.Conf.Headscale.ManagesACL() {
 		hidden = 1
 	}
 	if err := DB.Model(&model.Menu{}).Where("path in (?)", []string{"acl"}).Update("hidden", hidden).Error; err != nil {
@@ -832,6 +832,594 @@ func preparingData() {
 			Desc:     "Get jwk",
 			Creator:  "System",
 		},
+		{
+			Method:   "GET",
+			Path:     "/base/sso",
+			Category: "base",
+			Desc:     "Get SSO login info",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/base/sso/login",
+			Category: "base",
+			Desc:     "Redirect to SSO identity provider",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/base/sso/callback",
+			Category: "base",
+			Desc:     "SSO login callback",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/base/sso/login",
+			Category: "base",
+			Desc:     "User login with SSO ticket",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/system/scim/token",
+			Category: "system",
+			Desc:     "Get SCIM token list",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/system/scim/token",
+			Category: "system",
+			Desc:     "Create SCIM token",
+			Creator:  "System",
+		},
+		{
+			Method:   "DELETE",
+			Path:     "/system/scim/token",
+			Category: "system",
+			Desc:     "Delete SCIM token",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/scim/v2/ServiceProviderConfig",
+			Category: "scim",
+			Desc:     "SCIM service provider config",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/scim/v2/Users",
+			Category: "scim",
+			Desc:     "SCIM list users",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/scim/v2/Users",
+			Category: "scim",
+			Desc:     "SCIM create user",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/scim/v2/Users/:id",
+			Category: "scim",
+			Desc:     "SCIM get user",
+			Creator:  "System",
+		},
+		{
+			Method:   "PUT",
+			Path:     "/scim/v2/Users/:id",
+			Category: "scim",
+			Desc:     "SCIM replace user",
+			Creator:  "System",
+		},
+		{
+			Method:   "PATCH",
+			Path:     "/scim/v2/Users/:id",
+			Category: "scim",
+			Desc:     "SCIM update user",
+			Creator:  "System",
+		},
+		{
+			Method:   "DELETE",
+			Path:     "/scim/v2/Users/:id",
+			Category: "scim",
+			Desc:     "SCIM delete user",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/scim/v2/Groups",
+			Category: "scim",
+			Desc:     "SCIM list groups",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/scim/v2/Groups",
+			Category: "scim",
+			Desc:     "SCIM create group",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/scim/v2/Groups/:id",
+			Category: "scim",
+			Desc:     "SCIM get group",
+			Creator:  "System",
+		},
+		{
+			Method:   "PUT",
+			Path:     "/scim/v2/Groups/:id",
+			Category: "scim",
+			Desc:     "SCIM replace group",
+			Creator:  "System",
+		},
+		{
+			Method:   "PATCH",
+			Path:     "/scim/v2/Groups/:id",
+			Category: "scim",
+			Desc:     "SCIM update group",
+			Creator:  "System",
+		},
+		{
+			Method:   "DELETE",
+			Path:     "/scim/v2/Groups/:id",
+			Category: "scim",
+			Desc:     "SCIM delete group",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/session/list",
+			Category: "session",
+			Desc:     "Get current user sessions",
+			Creator:  "System",
+		},
+		{
+			Method:   "DELETE",
+			Path:     "/session/delete/batch",
+			Category: "session",
+			Desc:     "Revoke current user sessions",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/session/user/:userId",
+			Category: "session",
+			Desc:     "Get user sessions",
+			Creator:  "System",
+		},
+		{
+			Method:   "DELETE",
+			Path:     "/session/user/:userId",
+			Category: "session",
+			Desc:     "Force logout user",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/token/scopes",
+			Category: "token",
+			Desc:     "Get personal access token scopes",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/token/list",
+			Category: "token",
+			Desc:     "Get personal access tokens",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/token/create",
+			Category: "token",
+			Desc:     "Create personal access token",
+			Creator:  "System",
+		},
+		{
+			Method:   "DELETE",
+			Path:     "/token/delete/batch",
+			Category: "token",
+			Desc:     "Revoke personal access tokens",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/user/import/preview",
+			Category: "user",
+			Desc:     "Preview imported users",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/user/import",
+			Category: "user",
+			Desc:     "Import users",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/user/export",
+			Category: "user",
+			Desc:     "Export users",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/base/invite",
+			Category: "base",
+			Desc:     "Get user invite",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/base/invite",
+			Category: "base",
+			Desc:     "Set password with user invite",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/console/mapping",
+			Category: "console",
+			Desc:     "Get headscale users of current user",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/user/mapping/:userId",
+			Category: "user",
+			Desc:     "Get headscale users mapped to user",
+			Creator:  "System",
+		},
+		{
+			Method:   "PUT",
+			Path:     "/user/mapping/:userId",
+			Category: "user",
+			Desc:     "Set headscale users mapped to user",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/oidc/register",
+			Category: "oidc",
+			Desc:     "Dynamic client registration",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/oidc/client/list",
+			Category: "oidc",
+			Desc:     "Get OIDC client list",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/oidc/client/create",
+			Category: "oidc",
+			Desc:     "Create OIDC client",
+			Creator:  "System",
+		},
+		{
+			Method:   "PATCH",
+			Path:     "/oidc/client/update/:id",
+			Category: "oidc",
+			Desc:     "Update OIDC client",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/oidc/client/secret/:id",
+			Category: "oidc",
+			Desc:     "Rotate OIDC client secret",
+			Creator:  "System",
+		},
+		{
+			Method:   "DELETE",
+			Path:     "/oidc/client/delete/batch",
+			Category: "oidc",
+			Desc:     "Batch delete OIDC clients",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/oidc/introspect",
+			Category: "oidc",
+			Desc:     "Introspect token",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/oidc/revoke",
+			Category: "oidc",
+			Desc:     "Revoke token",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/oidc/authorize",
+			Category: "oidc",
+			Desc:     "Authorization endpoint",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/oidc/consent",
+			Category: "oidc",
+			Desc:     "Get consent page",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/oidc/consent",
+			Category: "oidc",
+			Desc:     "Answer consent",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/oidc/check",
+			Category: "oidc",
+			Desc:     "Check OIDC provider configuration",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/console/acl/policy",
+			Category: "console",
+			Desc:     "Get ACL policy",
+			Creator:  "System",
+		},
+		{
+			Method:   "PUT",
+			Path:     "/console/acl/groups/:name",
+			Category: "console",
+			Desc:     "Set ACL group",
+			Creator:  "System",
+		},
+		{
+			Method:   "DELETE",
+			Path:     "/console/acl/groups/:name",
+			Category: "console",
+			Desc:     "Delete ACL group",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/console/acl/groups/:name/members",
+			Category: "console",
+			Desc:     "Add ACL group member",
+			Creator:  "System",
+		},
+		{
+			Method:   "DELETE",
+			Path:     "/console/acl/groups/:name/members/:member",
+			Category: "console",
+			Desc:     "Remove ACL group member",
+			Creator:  "System",
+		},
+		{
+			Method:   "PUT",
+			Path:     "/console/acl/hosts/:name",
+			Category: "console",
+			Desc:     "Set ACL host",
+			Creator:  "System",
+		},
+		{
+			Method:   "DELETE",
+			Path:     "/console/acl/hosts/:name",
+			Category: "console",
+			Desc:     "Delete ACL host",
+			Creator:  "System",
+		},
+		{
+			Method:   "PUT",
+			Path:     "/console/acl/tagOwners/:tag",
+			Category: "console",
+			Desc:     "Set ACL tag owners",
+			Creator:  "System",
+		},
+		{
+			Method:   "DELETE",
+			Path:     "/console/acl/tagOwners/:tag",
+			Category: "console",
+			Desc:     "Delete ACL tag owners",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/console/acl/acls",
+			Category: "console",
+			Desc:     "Add ACL rule",
+			Creator:  "System",
+		},
+		{
+			Method:   "PUT",
+			Path:     "/console/acl/acls/:index",
+			Category: "console",
+			Desc:     "Update ACL rule",
+			Creator:  "System",
+		},
+		{
+			Method:   "DELETE",
+			Path:     "/console/acl/acls/:index",
+			Category: "console",
+			Desc:     "Delete ACL rule",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/console/acl/ssh",
+			Category: "console",
+			Desc:     "Add ACL SSH rule",
+			Creator:  "System",
+		},
+		{
+			Method:   "PUT",
+			Path:     "/console/acl/ssh/:index",
+			Category: "console",
+			Desc:     "Update ACL SSH rule",
+			Creator:  "System",
+		},
+		{
+			Method:   "DELETE",
+			Path:     "/console/acl/ssh/:index",
+			Category: "console",
+			Desc:     "Delete ACL SSH rule",
+			Creator:  "System",
+		},
+		{
+			Method:   "PUT",
+			Path:     "/console/acl/autoApprovers",
+			Category: "console",
+			Desc:     "Set ACL auto approvers",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/console/acl/validate",
+			Category: "console",
+			Desc:     "Validate Access Control",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/console/acl/versions",
+			Category: "console",
+			Desc:     "List ACL versions",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/console/acl/versions/:id",
+			Category: "console",
+			Desc:     "Get ACL version",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/console/acl/versions/:id/rollback",
+			Category: "console",
+			Desc:     "Rollback ACL version",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/console/acl/diff",
+			Category: "console",
+			Desc:     "Diff ACL versions",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/console/acl/simulate",
+			Category: "console",
+			Desc:     "Simulate ACL access",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/console/acl/matrix",
+			Category: "console",
+			Desc:     "ACL reachability of a node",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/console/acl/roles",
+			Category: "console",
+			Desc:     "Get ACL role templates",
+			Creator:  "System",
+		},
+		{
+			Method:   "PUT",
+			Path:     "/console/acl/roles/:id",
+			Category: "console",
+			Desc:     "Set ACL role template",
+			Creator:  "System",
+		},
+		{
+			Method:   "DELETE",
+			Path:     "/console/acl/roles/:id",
+			Category: "console",
+			Desc:     "Delete ACL role template",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/console/acl/roles/sync",
+			Category: "console",
+			Desc:     "Sync ACL role groups",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/system/headscale/config",
+			Category: "headscale",
+			Desc:     "Get headscale config sections",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/system/headscale/config/:section",
+			Category: "headscale",
+			Desc:     "Get headscale config section",
+			Creator:  "System",
+		},
+		{
+			Method:   "PATCH",
+			Path:     "/system/headscale/config/:section",
+			Category: "headscale",
+			Desc:     "Update headscale config section",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/system/headscale/jobs",
+			Category: "headscale",
+			Desc:     "List headscale config applies",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/system/headscale/jobs/:id",
+			Category: "headscale",
+			Desc:     "Get headscale config apply",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/system/headscale/versions",
+			Category: "headscale",
+			Desc:     "List headscale config versions",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/system/headscale/versions/:id",
+			Category: "headscale",
+			Desc:     "Get headscale config version",
+			Creator:  "System",
+		},
+		{
+			Method:   "POST",
+			Path:     "/system/headscale/versions/:id/restore",
+			Category: "headscale",
+			Desc:     "Restore headscale config version",
+			Creator:  "System",
+		},
+		{
+			Method:   "GET",
+			Path:     "/system/headscale/diff",
+			Category: "headscale",
+			Desc:     "Diff headscale config versions",
+			Creator:  "System",
+		},
 	}
 
 	// different role has different paths permission
@@ -839,6 +1427,16 @@ func preparingData() {
 		"/base/login",
 		"/base/logout",
 		"/base/refreshToken",
+		"/base/sso",
+		"/base/sso/login",
+		"/base/sso/callback",
+		"/base/invite",
+		"/session/list",
+		"/session/delete/batch",
+		"/token/scopes",
+		"/token/list",
+		"/token/create",
+		"/token/delete/batch",
 		"/user/info",
 		"/menu/access/tree/:userId",
 	}
@@ -849,8 +1447,13 @@ func preparingData() {
 		"/user/changePwd",
 		"/user/update/:userId",
 		"/user/delete/batch",
+		"/user/import/preview",
+		"/user/import",
+		"/user/export",
+		"/user/mapping/:userId",
 		"/log/operation/list",
 		"/log/operation/delete/batch",
+		"/session/user/:userId",
 		"/message",
 		"/notice",
 		"/console/preauthkey",
@@ -858,7 +1461,29 @@ func preparingData() {
 		"/console/routes",
 		"/console/route",
 		"/console/machine",
+		"/console/mapping",
 		"/oidc/authorize",
+		"/console/acl/policy",
+		"/console/acl/groups/:name",
+		"/console/acl/groups/:name/members",
+		"/console/acl/groups/:name/members/:member",
+		"/console/acl/hosts/:name",
+		"/console/acl/tagOwners/:tag",
+		"/console/acl/acls",
+		"/console/acl/acls/:index",
+		"/console/acl/ssh",
+		"/console/acl/ssh/:index",
+		"/console/acl/autoApprovers",
+		"/console/acl/validate",
+		"/console/acl/versions",
+		"/console/acl/versions/:id",
+		"/console/acl/versions/:id/rollback",
+		"/console/acl/diff",
+		"/console/acl/simulate",
+		"/console/acl/matrix",
+		"/console/acl/roles",
+		"/console/acl/roles/:id",
+		"/console/acl/roles/sync",
 	}
 	userPaths := []string{
 		"/console/preauthkey",
@@ -866,7 +1491,9 @@ func preparingData() {
 		"/console/routes",
 		"/console/route",
 		"/console/machine",
+		"/console/mapping",
 		"/oidc/authorize",
+		"/console/acl/policy",
 	}
 
 	newApi := make([]model.Api, 0)
@@ -6,7 +6,11 @@ import (
 	"github.com/go-playground/validator/v10"
 	ch_translations "github.com/go-playground/validator/v10/translations/zh"
 	"headscale-panel/log"
+	"net"
+	"net/url"
 	"regexp"
+	"strconv"
+	"strings"
 )
 
 // Global Validate data validation real column
@@ -24,6 +28,9 @@ func InitValidate() {
 	Validate = validator.New()
 	_ = ch_translations.RegisterDefaultTranslations(Validate, Trans)
 	_ = Validate.RegisterValidation("checkMobile", checkMobile)
+	registerValidation("listenAddr", checkListenAddr, "{0}必须是一个有效的监听地址")
+	registerValidation("headscaleDuration", checkHeadscaleDuration, "{0}必须是一个有效的时长")
+	registerValidation("redirectURI", checkRedirectURI, "{0}必须是一个有效的回调地址")
 	log.Log.Infof("Initialisation of validator.v10 data verifier complete")
 }
 
@@ -38,3 +45,56 @@ func checkEmail(fl validator.FieldLevel) bool {
 	rgx := regexp.MustCompile(reg)
 	return rgx.MatchString(fl.Field().String())
 }
+
+// registerValidation registers a validation with its translation
+func registerValidation(tag string, fn validator.Func, text string) {
+	_ = Validate.RegisterValidation(tag, fn)
+	_ = Validate.RegisterTranslation(tag, Trans, func(ut ut.Translator) error {
+		return ut.Add(tag, text, false)
+	}, func(ut ut.Translator, fe validator.FieldError) string {
+		t, _ := ut.T(tag, fe.Field())
+		return t
+	})
+}
+
+// checkListenAddr checks a host:port to listen on, the host may be empty or an IPv6 address in brackets
+func checkListenAddr(fl validator.FieldLevel) bool {
+	_, port, err := net.SplitHostPort(fl.Field().String())
+	if err != nil {
+		return false
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n >= 0 && n <= 65535
+}
+
+// checkHeadscaleDuration checks a duration like headscale parses it, such as 30m, 24h or 180d, 0 is no duration
+var headscaleDurationRegexp = regexp.MustCompile(`^(0|(\d+(ms|s|m|h|d|w|y))+)$`)
+
+func checkHeadscaleDuration(fl validator.FieldLevel) bool {
+	return headscaleDurationRegexp.MatchString(fl.Field().String())
+}
+
+func checkRedirectURI(fl validator.FieldLevel) bool {
+	return ValidRedirectURI(fl.Field().String())
+}
+
+// ValidRedirectURI checks a redirect URI of an OIDC client, it must be an absolute https URI without a fragment.
+// http is only allowed for localhost and the loopback addresses, which native apps listen on (RFC 8252 section 7.3).
+func ValidRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || strings.Contains(uri, "#") || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		if host == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	}
+	return false
+}
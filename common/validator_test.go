@@ -0,0 +1,30 @@
+package common
+
+import "testing"
+
+func TestValidRedirectURI(t *testing.T) {
+	tests := []struct {
+		uri   string
+		valid bool
+	}{
+		{"https://app.example.org/callback", true},
+		{"https://app.example.org:8443/oidc/callback?x=1", true},
+		{"http://localhost:8080/callback", true},
+		{"http://127.0.0.1:51004/callback", true},
+		{"http://[::1]/callback", true},
+		{"http://app.example.org/callback", false},
+		{"https://app.example.org/callback#token", false},
+		{"https://app.example.org/callback#", false},
+		{"javascript:alert(document.cookie)", false},
+		{"javascript://app.example.org/%0aalert(1)", false},
+		{"data:text/html,<script>alert(1)</script>", false},
+		{"https:///callback", false},
+		{"/callback", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := ValidRedirectURI(tt.uri); got != tt.valid {
+			t.Errorf("ValidRedirectURI(%q) = %v, want %v", tt.uri, got, tt.valid)
+		}
+	}
+}
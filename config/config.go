@@ -11,6 +11,7 @@ import (
 	"headscale-panel/util"
 	"headscale-panel/version"
 	"os"
+	"time"
 )
 
 const (
@@ -40,6 +41,9 @@ type config struct {
 	Jwt       *JwtConfig       `mapstructure:"jwt" json:"jwt"`
 	RateLimit *RateLimitConfig `mapstructure:"rate-limit" json:"rateLimit"`
 	Headscale *Headscale       `mapstructure:"headscale" json:"headscale"`
+	Ldap      *LdapConfig      `mapstructure:"ldap" json:"ldap"`
+	Sso       *SsoConfig       `mapstructure:"sso" json:"sso"`
+	Scim      *ScimConfig      `mapstructure:"scim" json:"scim"`
 }
 
 // Set to read configuration information
@@ -251,6 +255,60 @@ type JwtConfig struct {
 	MaxRefresh int    `mapstructure:"max-refresh" json:"maxRefresh"`
 }
 
+type LdapConfig struct {
+	Enable             bool           `mapstructure:"enable" json:"enable"`
+	URL                string         `mapstructure:"url" json:"url"`
+	StartTLS           bool           `mapstructure:"start-tls" json:"startTLS"`
+	InsecureSkipVerify bool           `mapstructure:"insecure-skip-verify" json:"insecureSkipVerify"`
+	BindDN             string         `mapstructure:"bind-dn" json:"bindDN"`
+	BindPassword       string         `mapstructure:"bind-password" json:"-"`
+	BaseDN             string         `mapstructure:"base-dn" json:"baseDN"`
+	UserFilter         string         `mapstructure:"user-filter" json:"userFilter"`
+	UsernameAttribute  string         `mapstructure:"username-attribute" json:"usernameAttribute"`
+	EmailAttribute     string         `mapstructure:"email-attribute" json:"emailAttribute"`
+	NicknameAttribute  string         `mapstructure:"nickname-attribute" json:"nicknameAttribute"`
+	GroupBaseDN        string         `mapstructure:"group-base-dn" json:"groupBaseDN"`
+	GroupFilter        string         `mapstructure:"group-filter" json:"groupFilter"`
+	GroupNameAttribute string         `mapstructure:"group-name-attribute" json:"groupNameAttribute"`
+	GroupMappings      []GroupMapping `mapstructure:"group-mappings" json:"groupMappings"`
+	DefaultRole        string         `mapstructure:"default-role" json:"defaultRole"`
+	SyncInterval       int            `mapstructure:"sync-interval" json:"syncInterval"`
+}
+
+// GroupMapping maps a group of an external directory to a panel role keyword,
+// in the OIDC provider it maps a role keyword to a group of the issued groups claim
+type GroupMapping struct {
+	Group string `mapstructure:"group" json:"group"`
+	Role  string `mapstructure:"role" json:"role"`
+}
+
+// SsoConfig is the external OpenID Connect identity provider used to sign in the panel
+type SsoConfig struct {
+	Enable         bool           `mapstructure:"enable" json:"enable"`
+	Name           string         `mapstructure:"name" json:"name"` // Display name of the login button
+	Issuer         string         `mapstructure:"issuer" json:"issuer"`
+	ClientID       string         `mapstructure:"client-id" json:"clientID"`
+	ClientSecret   string         `mapstructure:"client-secret" json:"-"`
+	RedirectURL    string         `mapstructure:"redirect-url" json:"redirectURL"` // Callback of the panel backend
+	FrontendURL    string         `mapstructure:"frontend-url" json:"frontendURL"` // Login page which redeems the ticket
+	Scopes         []string       `mapstructure:"scopes" json:"scopes"`
+	UsernameClaim  string         `mapstructure:"username-claim" json:"usernameClaim"`
+	EmailClaim     string         `mapstructure:"email-claim" json:"emailClaim"`
+	NicknameClaim  string         `mapstructure:"nickname-claim" json:"nicknameClaim"`
+	GroupsClaim    string         `mapstructure:"groups-claim" json:"groupsClaim"`
+	GroupMappings  []GroupMapping `mapstructure:"group-mappings" json:"groupMappings"`
+	DefaultRole    string         `mapstructure:"default-role" json:"defaultRole"`
+	AllowedDomains []string       `mapstructure:"allowed-domains" json:"allowedDomains"`
+}
+
+// ScimConfig is the SCIM 2.0 provisioning endpoint, the bearer tokens are managed in the panel
+type ScimConfig struct {
+	Enable      bool   `mapstructure:"enable" json:"enable"`
+	DefaultRole string `mapstructure:"default-role" json:"defaultRole"` // Role of the users which are not a member of any group
+	// Sign-in providers (ldap, oidc) which sign in the SCIM users with the same name, the external id or email must match
+	LinkProviders []string `mapstructure:"link-providers" json:"linkProviders"`
+}
+
 type RateLimitConfig struct {
 	FillInterval int64 `mapstructure:"fill-interval" json:"fillInterval"`
 	Capacity     int64 `mapstructure:"capacity" json:"capacity"`
@@ -262,6 +320,7 @@ type Headscale struct {
 	App        string      `mapstructure:"app" json:"app"`
 	Config     string      `mapstructure:"config" json:"config"`
 	ACL        string      `mapstructure:"acl" json:"acl"`
+	ACLStorage *ACLStorage `mapstructure:"acl_storage" json:"acl_storage"` // Where the ACL policy is managed in multi mode
 	Controller *Controller `mapstructure:"controller" json:"controller"`
 	Cert       string      `mapstructure:"cert" json:"cert"`
 	Key        string      `mapstructure:"key" json:"key"`
@@ -270,10 +329,91 @@ type Headscale struct {
 }
 
 type OIDC struct {
-	Issuer       string `mapstructure:"issuer" json:"issuer"`
-	Authorize    string `mapstructure:"authorize" json:"authorize"`
-	ClientID     string `mapstructure:"client_id" json:"client_id"`
-	ClientSecret string `mapstructure:"client_secret" json:"client_secret"`
+	Issuer            string   `mapstructure:"issuer" json:"issuer"`
+	Authorize         string   `mapstructure:"authorize" json:"authorize"`
+	ClientID          string   `mapstructure:"client_id" json:"client_id"`
+	ClientSecret      string   `mapstructure:"client_secret" json:"client_secret"`
+	RedirectURIs      []string `mapstructure:"redirect_uris" json:"redirect_uris"`           // Redirect URIs of the headscale client, headscale's server_url/oidc/callback by default
+	Registration      bool     `mapstructure:"registration" json:"registration"`             // Enable the dynamic client registration (RFC 7591)
+	RegistrationToken string   `mapstructure:"registration_token" json:"registration_token"` // Initial access token of the registration, open registration if empty
+
+	IDTokenLifetime      int            `mapstructure:"id_token_lifetime" json:"id_token_lifetime"`           // Seconds, 300 by default
+	AccessTokenLifetime  int            `mapstructure:"access_token_lifetime" json:"access_token_lifetime"`   // Seconds, 3600 by default
+	RefreshTokenLifetime int            `mapstructure:"refresh_token_lifetime" json:"refresh_token_lifetime"` // Seconds, 30 days by default
+	EmailVerified        bool           `mapstructure:"email_verified" json:"email_verified"`                 // The email_verified claim of the users with an email
+	GroupMappings        []GroupMapping `mapstructure:"group_mappings" json:"group_mappings"`                 // Map a role keyword to a group of the groups claim
+	SigningAlgorithm     string         `mapstructure:"signing_algorithm" json:"signing_algorithm"`           // RS256 (default) or ES256
+	KeyRotationDays      int            `mapstructure:"key_rotation_days" json:"key_rotation_days"`           // Replace the signing key after the days, 30 by default
+}
+
+// SigningAlg returns the algorithm of the new signing keys
+func (o *OIDC) SigningAlg() string {
+	if o == nil || o.SigningAlgorithm == "" {
+		return "RS256"
+	}
+	return o.SigningAlgorithm
+}
+
+// KeyRotationPeriod returns how long a signing key is used
+func (o *OIDC) KeyRotationPeriod() time.Duration {
+	if o == nil || o.KeyRotationDays <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return time.Duration(o.KeyRotationDays) * 24 * time.Hour
+}
+
+// IDTokenTTL returns the lifetime of the ID token
+func (o *OIDC) IDTokenTTL() time.Duration {
+	if o == nil {
+		return 5 * time.Minute
+	}
+	return lifetime(o.IDTokenLifetime, 5*time.Minute)
+}
+
+// AccessTokenTTL returns the lifetime of the OAuth access token
+func (o *OIDC) AccessTokenTTL() time.Duration {
+	if o == nil {
+		return time.Hour
+	}
+	return lifetime(o.AccessTokenLifetime, time.Hour)
+}
+
+// RefreshTokenTTL returns the lifetime of the OAuth refresh token
+func (o *OIDC) RefreshTokenTTL() time.Duration {
+	if o == nil {
+		return 30 * 24 * time.Hour
+	}
+	return lifetime(o.RefreshTokenLifetime, 30*24*time.Hour)
+}
+
+func lifetime(seconds int, def time.Duration) time.Duration {
+	if seconds <= 0 {
+		return def
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// ACLStorage is where headscale loads the ACL policy from when it runs separately
+type ACLStorage struct {
+	Type          string         `mapstructure:"type" json:"type"`                     // file, ssh or database
+	Path          string         `mapstructure:"path" json:"path"`                     // file and ssh: path of the policy file, .yaml or .yml is YAML, HuJSON otherwise
+	ReloadCommand string         `mapstructure:"reload_command" json:"reload_command"` // file: run by the panel, ssh: run on the remote host, after a save
+	SSH           *ACLStorageSSH `mapstructure:"ssh" json:"ssh"`
+}
+
+// ACLStorageSSH is the remote host of the ssh storage
+type ACLStorageSSH struct {
+	Host       string `mapstructure:"host" json:"host"`
+	Port       int    `mapstructure:"port" json:"port"` // 22 by default
+	User       string `mapstructure:"user" json:"user"`
+	Password   string `mapstructure:"password" json:"password"`
+	PrivateKey string `mapstructure:"private_key" json:"private_key"` // Path of the private key
+	KnownHosts string `mapstructure:"known_hosts" json:"known_hosts"` // Path of the known_hosts file which verifies the host key
+}
+
+// ManagesACL tells whether the panel can edit the ACL policy
+func (h *Headscale) ManagesACL() bool {
+	return GetMode() < MULTI || (h.ACLStorage != nil && h.ACLStorage.Type != "")
 }
 
 type Controller struct {
@@ -285,4 +425,5 @@ type Command struct {
 	Start   string `mapstructure:"start" json:"start"`
 	Stop    string `mapstructure:"stop" json:"stop"`
 	Restart string `mapstructure:"restart" json:"restart"`
+	Reload  string `mapstructure:"reload" json:"reload"` // Reloads the ACL policy, headscale is restarted if empty
 }
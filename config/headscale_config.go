@@ -9,12 +9,26 @@ import (
 	"headscale-panel/util"
 	"os"
 	"path"
+	"sync"
 	"sync/atomic"
 )
 
 // 用于保存Headcale Config 确保在操作时不产生竞争问题
 var value = atomic.Value{}
 
+// 配置文件变更后调用的函数
+var (
+	changeHandlers    []func()
+	changeHandlerLock sync.RWMutex
+)
+
+// OnHeadscaleConfigChange 注册在Headscale配置文件变更后调用的函数
+func OnHeadscaleConfigChange(fn func()) {
+	changeHandlerLock.Lock()
+	defer changeHandlerLock.Unlock()
+	changeHandlers = append(changeHandlers, fn)
+}
+
 // 用于或当前的Headscale Config
 func GetHeadscaleConfig() *model.HeadscaleConfig {
 	return value.Load().(*model.HeadscaleConfig)
@@ -90,6 +104,7 @@ func InitHeadscaleConfig() {
 	HeadscaleConf.CustomCert = len(Conf.Headscale.CA) > 0
 	HeadscaleConf.ServerName = Conf.Headscale.ServerName
 	HeadscaleConf.OIDC.Authorization = Conf.Headscale.OIDC.Authorize
+	HeadscaleConf.OIDC.RedirectURIs = Conf.Headscale.OIDC.RedirectURIs
 	value.Store(HeadscaleConf)
 
 	// 监听配置文件
@@ -117,7 +132,14 @@ func InitHeadscaleConfig() {
 			conf.CustomCert = len(Conf.Headscale.CA) > 0
 			conf.ServerName = Conf.Headscale.ServerName
 			conf.OIDC.Authorization = Conf.Headscale.OIDC.Authorize
+			conf.OIDC.RedirectURIs = Conf.Headscale.OIDC.RedirectURIs
 			value.Swap(conf)
 		}
+
+		changeHandlerLock.RLock()
+		defer changeHandlerLock.RUnlock()
+		for _, fn := range changeHandlers {
+			fn()
+		}
 	})
 }
@@ -1,31 +1,68 @@
 package controller
 
 import (
-	"context"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"headscale-panel/common"
+	"headscale-panel/log"
+	"headscale-panel/model"
 	"headscale-panel/repository"
 	"headscale-panel/response"
-	task "headscale-panel/tasks"
 	"headscale-panel/vo"
+	"strconv"
 )
 
 type AccessControlController interface {
 	GetAccessControl(c *gin.Context)
 	SetAccessControl(c *gin.Context)
+	ValidateAccessControl(c *gin.Context)
+	GetPolicy(c *gin.Context)
+	SetGroup(c *gin.Context)
+	DeleteGroup(c *gin.Context)
+	AddGroupMember(c *gin.Context)
+	RemoveGroupMember(c *gin.Context)
+	SetHost(c *gin.Context)
+	DeleteHost(c *gin.Context)
+	SetTagOwners(c *gin.Context)
+	DeleteTagOwners(c *gin.Context)
+	AddACLRule(c *gin.Context)
+	UpdateACLRule(c *gin.Context)
+	DeleteACLRule(c *gin.Context)
+	AddSSHRule(c *gin.Context)
+	UpdateSSHRule(c *gin.Context)
+	DeleteSSHRule(c *gin.Context)
+	SetAutoApprovers(c *gin.Context)
+	ListVersions(c *gin.Context)
+	GetVersion(c *gin.Context)
+	DiffVersions(c *gin.Context)
+	RollbackVersion(c *gin.Context)
+	Simulate(c *gin.Context)
+	Reachability(c *gin.Context)
+	ListRoleTemplates(c *gin.Context)
+	SetRoleTemplate(c *gin.Context)
+	DeleteRoleTemplate(c *gin.Context)
+	SyncRoleGroups(c *gin.Context)
 }
 
 type accessControl struct {
-	repo repository.AccessControlRepository
-	h    task.HeadscaleService
+	repo     repository.AccessControlRepository
+	policy   repository.ACLPolicyRepository
+	roles    repository.ACLRoleRepository
+	userRepo repository.IUserRepository
 }
 
 func NewAccessControlController() AccessControlController {
-	return &accessControl{repo: repository.NewAccessControlRepository()}
+	return &accessControl{
+		repo:     repository.NewAccessControlRepository(),
+		policy:   repository.NewACLPolicyRepository(),
+		roles:    repository.NewACLRoleRepository(),
+		userRepo: repository.NewUserRepository(),
+	}
 }
 
-// Get the contents of the ACL file, available for standalone deployments
+// Get the contents of the ACL policy
 func (a *accessControl) GetAccessControl(c *gin.Context) {
 	data, err := a.repo.GetAccessControl()
 	if err != nil {
@@ -35,7 +72,7 @@ func (a *accessControl) GetAccessControl(c *gin.Context) {
 	response.Success(c, data, "success")
 }
 
-// Set the content of the ACL file, available for stand-alone deployment
+// Set the content of the ACL policy
 func (a *accessControl) SetAccessControl(c *gin.Context) {
 	req := &vo.SetAccessControlRequest{}
 	// Bind parameters
@@ -51,13 +88,354 @@ func (a *accessControl) SetAccessControl(c *gin.Context) {
 		return
 	}
 
-	if err := a.repo.SetAccessControl(req.Content); err != nil {
+	a.savePolicy(c, func(creator string) (uint, error) { return a.repo.SetAccessControl(req.Content, creator, req.Comment) })
+}
+
+// Validate the content of an ACL file without saving it
+func (a *accessControl) ValidateAccessControl(c *gin.Context) {
+	req := &vo.SetAccessControlRequest{}
+	if !bindPolicyRequest(c, req, nil) {
+		return
+	}
+	if err := a.repo.ValidateAccessControl(req.Content); err != nil {
+		failPolicy(c, err)
+		return
+	}
+	response.Success(c, nil, "valid")
+}
+
+// Get the parsed ACL policy
+func (a *accessControl) GetPolicy(c *gin.Context) {
+	policy, err := a.policy.GetPolicy()
+	if err != nil {
+		response.Fail(c, nil, err.Error())
+		return
+	}
+	response.Success(c, policy, "success")
+}
+
+// Set the members of a group, the group is created if it is missing
+func (a *accessControl) SetGroup(c *gin.Context) {
+	req := &vo.SetACLGroupRequest{}
+	if !bindPolicyRequest(c, req, func() { req.Name = c.Param("name") }) {
+		return
+	}
+	a.savePolicy(c, func(creator string) (uint, error) { return a.policy.SetGroup(creator, req.Name, req.Members) })
+}
+
+// Delete a group
+func (a *accessControl) DeleteGroup(c *gin.Context) {
+	a.savePolicy(c, func(creator string) (uint, error) { return a.policy.DeleteGroup(creator, c.Param("name")) })
+}
+
+// Add a member to a group
+func (a *accessControl) AddGroupMember(c *gin.Context) {
+	req := &vo.ACLGroupMemberRequest{}
+	if !bindPolicyRequest(c, req, func() { req.Name = c.Param("name") }) {
+		return
+	}
+	a.savePolicy(c, func(creator string) (uint, error) { return a.policy.AddGroupMember(creator, req.Name, req.Member) })
+}
+
+// Remove a member from a group
+func (a *accessControl) RemoveGroupMember(c *gin.Context) {
+	a.savePolicy(c, func(creator string) (uint, error) {
+		return a.policy.RemoveGroupMember(creator, c.Param("name"), c.Param("member"))
+	})
+}
+
+// Set the address of a host alias
+func (a *accessControl) SetHost(c *gin.Context) {
+	req := &vo.SetACLHostRequest{}
+	if !bindPolicyRequest(c, req, func() { req.Name = c.Param("name") }) {
+		return
+	}
+	a.savePolicy(c, func(creator string) (uint, error) { return a.policy.SetHost(creator, req.Name, req.Address) })
+}
+
+// Delete a host alias
+func (a *accessControl) DeleteHost(c *gin.Context) {
+	a.savePolicy(c, func(creator string) (uint, error) { return a.policy.DeleteHost(creator, c.Param("name")) })
+}
+
+// Set the owners of a tag
+func (a *accessControl) SetTagOwners(c *gin.Context) {
+	req := &vo.SetACLTagOwnersRequest{}
+	if !bindPolicyRequest(c, req, func() { req.Tag = c.Param("tag") }) {
+		return
+	}
+	a.savePolicy(c, func(creator string) (uint, error) { return a.policy.SetTagOwners(creator, req.Tag, req.Owners) })
+}
+
+// Delete the owners of a tag
+func (a *accessControl) DeleteTagOwners(c *gin.Context) {
+	a.savePolicy(c, func(creator string) (uint, error) { return a.policy.DeleteTagOwners(creator, c.Param("tag")) })
+}
+
+// Append a rule to the acls section
+func (a *accessControl) AddACLRule(c *gin.Context) {
+	req := &vo.ACLRuleRequest{}
+	if !bindPolicyRequest(c, req, nil) {
+		return
+	}
+	a.savePolicy(c, func(creator string) (uint, error) { return a.policy.AddACLRule(creator, aclRule(req)) })
+}
+
+// Replace a rule of the acls section by its index
+func (a *accessControl) UpdateACLRule(c *gin.Context) {
+	req := &vo.ACLRuleRequest{}
+	if !bindPolicyRequest(c, req, nil) {
+		return
+	}
+	a.savePolicy(c, func(creator string) (uint, error) { return a.policy.UpdateACLRule(creator, ruleIndex(c), aclRule(req)) })
+}
+
+// Delete a rule of the acls section by its index
+func (a *accessControl) DeleteACLRule(c *gin.Context) {
+	a.savePolicy(c, func(creator string) (uint, error) { return a.policy.DeleteACLRule(creator, ruleIndex(c)) })
+}
+
+// Append a rule to the ssh section
+func (a *accessControl) AddSSHRule(c *gin.Context) {
+	req := &vo.SSHRuleRequest{}
+	if !bindPolicyRequest(c, req, nil) {
+		return
+	}
+	a.savePolicy(c, func(creator string) (uint, error) { return a.policy.AddSSHRule(creator, sshRule(req)) })
+}
+
+// Replace a rule of the ssh section by its index
+func (a *accessControl) UpdateSSHRule(c *gin.Context) {
+	req := &vo.SSHRuleRequest{}
+	if !bindPolicyRequest(c, req, nil) {
+		return
+	}
+	a.savePolicy(c, func(creator string) (uint, error) { return a.policy.UpdateSSHRule(creator, ruleIndex(c), sshRule(req)) })
+}
+
+// Delete a rule of the ssh section by its index
+func (a *accessControl) DeleteSSHRule(c *gin.Context) {
+	a.savePolicy(c, func(creator string) (uint, error) { return a.policy.DeleteSSHRule(creator, ruleIndex(c)) })
+}
+
+// Replace the autoApprovers section
+func (a *accessControl) SetAutoApprovers(c *gin.Context) {
+	req := &vo.SetACLAutoApproversRequest{}
+	if !bindPolicyRequest(c, req, nil) {
+		return
+	}
+	a.savePolicy(c, func(creator string) (uint, error) {
+		return a.policy.SetAutoApprovers(creator, &model.AutoApprovers{Routes: req.Routes, ExitNode: req.ExitNode})
+	})
+}
+
+// List the saved versions of the ACL policy
+func (a *accessControl) ListVersions(c *gin.Context) {
+	var req vo.ACLVersionListRequest
+	if err := c.ShouldBind(&req); err != nil {
+		response.Fail(c, nil, "param error")
+		return
+	}
+	versions, total, err := a.repo.ListVersions(&req)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get acl versions")
+		log.Log.Errorf("get acl versions error: %v", err)
+		return
+	}
+	response.Success(c, gin.H{"versions": versions, "total": total}, "success")
+}
+
+// Get a saved version with its content
+func (a *accessControl) GetVersion(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	version, err := a.repo.GetVersion(uint(id))
+	if err != nil {
+		response.Fail(c, nil, "acl version not found")
+		return
+	}
+	response.Success(c, version, "success")
+}
+
+// Unified diff between two versions, 0 is the current policy
+func (a *accessControl) DiffVersions(c *gin.Context) {
+	var req vo.ACLVersionDiffRequest
+	if err := c.ShouldBind(&req); err != nil {
+		response.Fail(c, nil, "param error")
+		return
+	}
+	diff, err := a.repo.DiffVersions(req.From, req.To)
+	if err != nil {
+		response.Fail(c, nil, err.Error())
+		return
+	}
+	response.Success(c, gin.H{"diff": diff}, "success")
+}
+
+// Save a previous version again, it is validated and applied like any change
+func (a *accessControl) RollbackVersion(c *gin.Context) {
+	req := &vo.RollbackACLRequest{}
+	if !bindPolicyRequest(c, req, nil) {
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("id"))
+	version, err := a.repo.GetVersion(uint(id))
+	if err != nil {
+		response.Fail(c, nil, "acl version not found")
+		return
+	}
+	comment := fmt.Sprintf("Rollback to version %d", version.ID)
+	if req.Comment != "" {
+		comment += ": " + req.Comment
+	}
+	a.savePolicy(c, func(creator string) (uint, error) { return a.repo.SetAccessControl(version.Content, creator, comment) })
+}
+
+// Whether the source can reach the destination, and the rule which allows it
+func (a *accessControl) Simulate(c *gin.Context) {
+	req := &vo.ACLSimulateRequest{}
+	if err := c.ShouldBind(req); err != nil {
+		response.Fail(c, nil, "param error")
+		return
+	}
+	if err := common.Validate.Struct(req); err != nil {
+		errStr := err.(validator.ValidationErrors)[0].Translate(common.Trans)
+		response.Fail(c, nil, errStr)
+		return
+	}
+	result, err := a.policy.Simulate(req)
+	if err != nil {
+		response.Fail(c, nil, err.Error())
+		return
+	}
+	response.Success(c, result, "success")
+}
+
+// What a node can reach on the other nodes
+func (a *accessControl) Reachability(c *gin.Context) {
+	req := &vo.ACLReachabilityRequest{}
+	if err := c.ShouldBind(req); err != nil {
+		response.Fail(c, nil, "param error")
+		return
+	}
+	if err := common.Validate.Struct(req); err != nil {
+		errStr := err.(validator.ValidationErrors)[0].Translate(common.Trans)
+		response.Fail(c, nil, errStr)
+		return
+	}
+	reach, err := a.policy.Reachability(req.Node)
+	if err != nil {
 		response.Fail(c, nil, err.Error())
 		return
 	}
+	response.Success(c, reach, "success")
+}
+
+// List the roles whose group is generated, with their rules
+func (a *accessControl) ListRoleTemplates(c *gin.Context) {
+	list, err := a.roles.ListTemplates()
+	if err != nil {
+		log.Log.Errorf("get acl role templates error: %v", err)
+		response.Fail(c, nil, "get acl role templates error")
+		return
+	}
+	response.Success(c, list, "success")
+}
 
-	// Restart headscale
-	a.h.Stop(context.Background())
-	a.h.Start()
+// Generate the group of a role with the rules, the policy is synced at once
+func (a *accessControl) SetRoleTemplate(c *gin.Context) {
+	req := &vo.SetACLRoleTemplateRequest{}
+	if !bindPolicyRequest(c, req, func() {
+		id, _ := strconv.Atoi(c.Param("id"))
+		req.RoleID = uint(id)
+	}) {
+		return
+	}
+	rules := make([]model.ACLRule, 0, len(req.Rules))
+	for i := range req.Rules {
+		rules = append(rules, *aclRule(&req.Rules[i]))
+	}
+	a.syncRoles(c, func(creator string) error { return a.roles.SetTemplate(creator, req.RoleID, rules) })
+}
+
+// Stop generating the group of a role, its group and rules are removed from the policy
+func (a *accessControl) DeleteRoleTemplate(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	a.syncRoles(c, func(creator string) error { return a.roles.DeleteTemplate(creator, uint(id)) })
+}
+
+// Sync the generated groups with the members of the roles
+func (a *accessControl) SyncRoleGroups(c *gin.Context) {
+	a.syncRoles(c, a.roles.Sync)
+}
+
+// syncRoles runs a change of the role groups as the current user, the sync applies the policy itself
+func (a *accessControl) syncRoles(c *gin.Context, sync func(creator string) error) {
+	ctxUser, err := a.userRepo.GetCurrentUser(c)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get current user information")
+		return
+	}
+	if err = sync(ctxUser.Name); err != nil {
+		failPolicy(c, err)
+		return
+	}
+	response.Success(c, nil, "sync success")
+}
+
+// savePolicy saves a policy change as the current user, the save makes headscale load it
+func (a *accessControl) savePolicy(c *gin.Context, save func(creator string) (uint, error)) {
+	ctxUser, err := a.userRepo.GetCurrentUser(c)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get current user information")
+		return
+	}
+	if _, err = save(ctxUser.Name); err != nil {
+		failPolicy(c, err)
+		return
+	}
 	response.Success(c, nil, "save success")
 }
+
+// failPolicy answers an invalid policy with the list of problems
+func failPolicy(c *gin.Context, err error) {
+	var invalid *repository.ACLValidationError
+	if errors.As(err, &invalid) {
+		response.Fail(c, invalid, err.Error())
+		return
+	}
+	response.Fail(c, nil, err.Error())
+}
+
+// bindPolicyRequest binds and validates the body, params sets the fields from the path before the validation
+func bindPolicyRequest(c *gin.Context, req interface{}, params func()) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		response.Fail(c, nil, "param error")
+		return false
+	}
+	if params != nil {
+		params()
+	}
+	if err := common.Validate.Struct(req); err != nil {
+		errStr := err.(validator.ValidationErrors)[0].Translate(common.Trans)
+		response.Fail(c, nil, errStr)
+		return false
+	}
+	return true
+}
+
+// ruleIndex is the index of a rule in the path, an invalid index is not found
+func ruleIndex(c *gin.Context) int {
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		return -1
+	}
+	return index
+}
+
+func aclRule(req *vo.ACLRuleRequest) *model.ACLRule {
+	return &model.ACLRule{Action: req.Action, Protocol: req.Protocol, Sources: req.Sources, Destinations: req.Destinations}
+}
+
+func sshRule(req *vo.SSHRuleRequest) *model.SSHRule {
+	return &model.SSHRule{Action: req.Action, Sources: req.Sources, Destinations: req.Destinations, Users: req.Users}
+}
@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"headscale-panel/common"
+	"headscale-panel/log"
+	"headscale-panel/repository"
+	"headscale-panel/response"
+	"headscale-panel/vo"
+)
+
+type IAccessTokenController interface {
+	GetScopes(c *gin.Context)         // Get the APIs which can be the scopes of a token
+	GetTokens(c *gin.Context)         // Get the personal access tokens of the current user
+	CreateToken(c *gin.Context)       // Create a personal access token
+	BatchRevokeTokens(c *gin.Context) // Revoke personal access tokens
+}
+
+type AccessTokenController struct {
+	repo     repository.IAccessTokenRepository
+	userRepo repository.IUserRepository
+}
+
+func NewAccessTokenController() IAccessTokenController {
+	return AccessTokenController{repo: repository.NewAccessTokenRepository(), userRepo: repository.NewUserRepository()}
+}
+
+// Get the APIs which can be the scopes of a token, they are the permissions of the current user
+func (a AccessTokenController) GetScopes(c *gin.Context) {
+	user, err := a.userRepo.GetCurrentUser(c)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get current user information")
+		return
+	}
+	response.Success(c, gin.H{"scopes": a.repo.GetUserScopes(&user)}, "Successfully got token scopes")
+}
+
+// Get the personal access tokens of the current user
+func (a AccessTokenController) GetTokens(c *gin.Context) {
+	user, err := a.userRepo.GetCurrentUser(c)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get current user information")
+		return
+	}
+	tokens, err := a.repo.GetUserTokens(user.ID)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get token list")
+		log.Log.Errorf("get access token list error: %v", err)
+		return
+	}
+	response.Success(c, gin.H{"tokens": tokens}, "Successfully got token list")
+}
+
+// Create a personal access token, the token is only returned once
+func (a AccessTokenController) CreateToken(c *gin.Context) {
+	var req vo.CreateAccessTokenRequest
+	if err := c.ShouldBind(&req); err != nil {
+		response.Fail(c, nil, "param error")
+		return
+	}
+	if err := common.Validate.Struct(&req); err != nil {
+		errStr := err.(validator.ValidationErrors)[0].Translate(common.Trans)
+		response.Fail(c, nil, errStr)
+		return
+	}
+	// A token can not create other tokens
+	if _, ok := c.Get("tokenScopes"); ok {
+		response.Fail(c, nil, "Personal access tokens can only be created after login")
+		return
+	}
+	user, err := a.userRepo.GetCurrentUser(c)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get current user information")
+		return
+	}
+
+	token, t, err := a.repo.CreateToken(&user, &req)
+	if err != nil {
+		response.Fail(c, nil, "Failed to create token: "+err.Error())
+		log.Log.Errorf("create access token error: %v", err)
+		return
+	}
+	response.Success(c, gin.H{"token": token, "info": t}, "Successfully created token")
+}
+
+// Revoke personal access tokens of the current user
+func (a AccessTokenController) BatchRevokeTokens(c *gin.Context) {
+	var req vo.DeleteAccessTokenRequest
+	if err := c.ShouldBind(&req); err != nil {
+		response.Fail(c, nil, "param error")
+		return
+	}
+	if err := common.Validate.Struct(&req); err != nil {
+		errStr := err.(validator.ValidationErrors)[0].Translate(common.Trans)
+		response.Fail(c, nil, errStr)
+		return
+	}
+	user, err := a.userRepo.GetCurrentUser(c)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get current user information")
+		return
+	}
+	if err = a.repo.RevokeTokens(user.ID, req.TokenIds); err != nil {
+		response.Fail(c, nil, "Failed to revoke tokens")
+		log.Log.Errorf("revoke access tokens error: %v", err)
+		return
+	}
+	response.Success(c, nil, "Successfully revoked tokens")
+}
@@ -1,7 +1,7 @@
 package controller
 
 import (
-	"context"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"headscale-panel/common"
@@ -11,21 +11,34 @@ import (
 	"headscale-panel/response"
 	"headscale-panel/tasks"
 	"headscale-panel/vo"
+	"strconv"
 )
 
 type headscaleConfigController struct {
-	repo repository.HeadscaleConfigRepository
-	h    task.HeadscaleService
+	repo     repository.HeadscaleConfigRepository
+	userRepo repository.IUserRepository
 }
 
 type IHeadscaleConfigController interface {
 	GetHeadscaleConfig(c *gin.Context)
 	SetHeadscaleConfig(c *gin.Context)
+	GetHeadscaleConfigSections(c *gin.Context)
+	GetHeadscaleConfigSection(c *gin.Context)
+	PatchHeadscaleConfigSection(c *gin.Context)
+	ListHeadscaleConfigJobs(c *gin.Context)
+	GetHeadscaleConfigJob(c *gin.Context)
+	ListConfigVersions(c *gin.Context)
+	GetConfigVersion(c *gin.Context)
+	DiffConfigVersions(c *gin.Context)
+	RestoreConfigVersion(c *gin.Context)
 	//Upload(c *gin.Context)
 }
 
 func NewHeadscaleConfigController() IHeadscaleConfigController {
-	return &headscaleConfigController{repo: repository.NewHeadscaleConfigRepository()}
+	return &headscaleConfigController{
+		repo:     repository.NewHeadscaleConfigRepository(),
+		userRepo: repository.NewUserRepository(),
+	}
 }
 
 // GetHeadscaleConfig Get the content of the Headscale configuration file for standalone deployments,
@@ -47,8 +60,9 @@ func (s *headscaleConfigController) GetHeadscaleConfig(c *gin.Context) {
 	response.Success(c, settings, "success")
 }
 
-// SetHeadscaleConfig Save the Headscale configuration file during stand-alone deployment,
-// and save the host address and ApiKey of the grpc connection to the database during separate deployment
+// SetHeadscaleConfig Save the Headscale configuration file during stand-alone deployment, it is checked with
+// headscale configtest and applied in a job which restores the previous file if headscale is not healthy.
+// Save the host address and ApiKey of the grpc connection to the database during separate deployment
 func (s *headscaleConfigController) SetHeadscaleConfig(c *gin.Context) {
 	var req vo.SystemSettingHeadscale
 	// Bind parameters
@@ -67,26 +81,28 @@ func (s *headscaleConfigController) SetHeadscaleConfig(c *gin.Context) {
 	// Select the setting saving method according to the operation mode,
 	// the stand-alone deployment mode saves the headscale configuration file,
 	// and the remote call mode saves the grpc connection configuration to the database
-	var err error
 	if config.GetMode() < config.MULTI {
-		err = s.repo.SetHeadscaleConfigFromFile(config.Conf.Headscale.Config, &req)
-	} else {
-		err = s.repo.SetHeadscaleConfigFromDB(&req)
+		ctxUser, err := s.userRepo.GetCurrentUser(c)
+		if err != nil {
+			response.Fail(c, nil, "Failed to get current user information")
+			return
+		}
+		job, err := s.repo.SetHeadscaleConfigFromFile(config.Conf.Headscale.Config, &req, ctxUser.Name)
+		if err != nil {
+			response.Fail(c, nil, err.Error())
+			return
+		}
+		response.Success(c, job, "success")
+		return
 	}
 
-	if err != nil {
+	if err := s.repo.SetHeadscaleConfigFromDB(&req); err != nil {
 		response.Fail(c, nil, err.Error())
 		return
 	}
 
-	// Restart related services
+	// Reconnect to the new headscale
 	go func() {
-		// Determine whether to restart the headscale according to the operating mode,
-		// and it will restart when deploying on a single machine
-		if config.GetMode() < config.MULTI {
-			s.h.Stop(context.Background())
-			s.h.Start()
-		}
 		if err := task.HeadscaleControl.ReConnect(); err != nil {
 			log.Log.Errorf("grpc err: %v", err)
 		}
@@ -95,6 +111,166 @@ func (s *headscaleConfigController) SetHeadscaleConfig(c *gin.Context) {
 	response.Success(c, nil, "success")
 }
 
+// GetHeadscaleConfigSections Get all the sections of the Headscale configuration file, for standalone deployments.
+// The layout of the sections follows the installed version of Headscale.
+func (s *headscaleConfigController) GetHeadscaleConfigSections(c *gin.Context) {
+	if config.GetMode() >= config.MULTI {
+		response.Fail(c, nil, "multi mode not support")
+		return
+	}
+	layout, sections, err := s.repo.GetHeadscaleConfigSections(config.Conf.Headscale.Config)
+	if err != nil {
+		response.Fail(c, nil, err.Error())
+		return
+	}
+	response.Success(c, gin.H{"layout": layout, "sections": sections}, "success")
+}
+
+// GetHeadscaleConfigSection Get a section of the Headscale configuration file, for standalone deployments
+func (s *headscaleConfigController) GetHeadscaleConfigSection(c *gin.Context) {
+	if config.GetMode() >= config.MULTI {
+		response.Fail(c, nil, "multi mode not support")
+		return
+	}
+	section, err := s.repo.GetHeadscaleConfigSection(config.Conf.Headscale.Config, c.Param("section"))
+	if err != nil {
+		response.Fail(c, nil, err.Error())
+		return
+	}
+	response.Success(c, section, "success")
+}
+
+// PatchHeadscaleConfigSection Set the fields of the body in a section of the Headscale configuration file,
+// the invalid fields are answered in data.fields. If the file changed it is applied like SetHeadscaleConfig,
+// the job is answered in data.job.
+func (s *headscaleConfigController) PatchHeadscaleConfigSection(c *gin.Context) {
+	if config.GetMode() >= config.MULTI {
+		response.Fail(c, nil, "multi mode not support")
+		return
+	}
+	patch, err := c.GetRawData()
+	if err != nil {
+		response.Fail(c, nil, "param error")
+		return
+	}
+	ctxUser, err := s.userRepo.GetCurrentUser(c)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get current user information")
+		return
+	}
+	section, job, err := s.repo.PatchHeadscaleConfigSection(config.Conf.Headscale.Config, c.Param("section"), patch, ctxUser.Name)
+	if err != nil {
+		var invalid *repository.HeadscaleConfigError
+		if errors.As(err, &invalid) {
+			response.Fail(c, invalid, err.Error())
+			return
+		}
+		response.Fail(c, nil, err.Error())
+		return
+	}
+	response.Success(c, gin.H{"section": section, "job": job}, "success")
+}
+
+// ListHeadscaleConfigJobs Get the applies of the Headscale configuration file of the last day, the latest first
+func (s *headscaleConfigController) ListHeadscaleConfigJobs(c *gin.Context) {
+	response.Success(c, s.repo.ListHeadscaleConfigJobs(), "success")
+}
+
+// GetHeadscaleConfigJob Get an apply of the Headscale configuration file, its status is running until headscale is
+// healthy with the new file, or the previous file is restored
+func (s *headscaleConfigController) GetHeadscaleConfigJob(c *gin.Context) {
+	job, err := s.repo.GetHeadscaleConfigJob(c.Param("id"))
+	if err != nil {
+		response.Fail(c, nil, err.Error())
+		return
+	}
+	response.Success(c, job, "success")
+}
+
+// ListConfigVersions List the versions of the Headscale configuration file, for standalone deployments
+func (s *headscaleConfigController) ListConfigVersions(c *gin.Context) {
+	if config.GetMode() >= config.MULTI {
+		response.Fail(c, nil, "multi mode not support")
+		return
+	}
+	var req vo.HeadscaleConfigVersionListRequest
+	if err := c.ShouldBind(&req); err != nil {
+		response.Fail(c, nil, "param error")
+		return
+	}
+	versions, total, err := s.repo.ListConfigVersions(&req)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get headscale config versions")
+		log.Log.Errorf("get headscale config versions error: %v", err)
+		return
+	}
+	response.Success(c, gin.H{"versions": versions, "total": total}, "success")
+}
+
+// GetConfigVersion Get a version of the Headscale configuration file with its content
+func (s *headscaleConfigController) GetConfigVersion(c *gin.Context) {
+	if config.GetMode() >= config.MULTI {
+		response.Fail(c, nil, "multi mode not support")
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("id"))
+	version, err := s.repo.GetConfigVersion(uint(id))
+	if err != nil {
+		response.Fail(c, nil, "headscale config version not found")
+		return
+	}
+	response.Success(c, version, "success")
+}
+
+// DiffConfigVersions Unified diff between two versions of the Headscale configuration file, 0 is the current file
+func (s *headscaleConfigController) DiffConfigVersions(c *gin.Context) {
+	if config.GetMode() >= config.MULTI {
+		response.Fail(c, nil, "multi mode not support")
+		return
+	}
+	var req vo.HeadscaleConfigVersionDiffRequest
+	if err := c.ShouldBind(&req); err != nil {
+		response.Fail(c, nil, "param error")
+		return
+	}
+	diff, err := s.repo.DiffConfigVersions(config.Conf.Headscale.Config, req.From, req.To)
+	if err != nil {
+		response.Fail(c, nil, err.Error())
+		return
+	}
+	response.Success(c, gin.H{"diff": diff}, "success")
+}
+
+// RestoreConfigVersion Apply a previous version of the Headscale configuration file again, like SetHeadscaleConfig
+func (s *headscaleConfigController) RestoreConfigVersion(c *gin.Context) {
+	if config.GetMode() >= config.MULTI {
+		response.Fail(c, nil, "multi mode not support")
+		return
+	}
+	var req vo.RestoreHeadscaleConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Fail(c, nil, "param error")
+		return
+	}
+	if err := common.Validate.Struct(req); err != nil {
+		errStr := err.(validator.ValidationErrors)[0].Translate(common.Trans)
+		response.Fail(c, nil, errStr)
+		return
+	}
+	ctxUser, err := s.userRepo.GetCurrentUser(c)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get current user information")
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("id"))
+	job, err := s.repo.RestoreConfigVersion(config.Conf.Headscale.Config, uint(id), ctxUser.Name, req.Comment)
+	if err != nil {
+		response.Fail(c, nil, err.Error())
+		return
+	}
+	response.Success(c, job, "success")
+}
+
 // Function reserved
 //func (s *headscaleConfigController) Upload(c *gin.Context) {
 //	target := c.Param("target")
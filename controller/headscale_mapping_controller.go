@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"headscale-panel/common"
+	"headscale-panel/log"
+	"headscale-panel/repository"
+	"headscale-panel/response"
+	"headscale-panel/vo"
+)
+
+type IHeadscaleMappingController interface {
+	GetCurrentMappings(c *gin.Context) // Get the headscale users the current user can access
+	GetUserMappings(c *gin.Context)    // Get the headscale users mapped to a user
+	SetUserMappings(c *gin.Context)    // Replace the headscale users mapped to a user
+}
+
+type HeadscaleMappingController struct {
+	repo     repository.IHeadscaleMappingRepository
+	userRepo repository.IUserRepository
+}
+
+func NewHeadscaleMappingController() IHeadscaleMappingController {
+	return HeadscaleMappingController{repo: repository.NewHeadscaleMappingRepository(), userRepo: repository.NewUserRepository()}
+}
+
+// Get the headscale users the current user can access, all is true if the user can manage the nodes of all users
+func (h HeadscaleMappingController) GetCurrentMappings(c *gin.Context) {
+	user, err := h.userRepo.GetCurrentUser(c)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get current user information")
+		return
+	}
+	mappings, err := h.repo.GetUserMappings(&user)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get headscale users")
+		log.Log.Errorf("get headscale user mappings error: %v", err)
+		return
+	}
+	response.Success(c, gin.H{"all": c.GetBool("machineFlag"), "mappings": mappings}, "success")
+}
+
+// Get the headscale users mapped to a user, the effective mappings include the fallback of the same name
+func (h HeadscaleMappingController) GetUserMappings(c *gin.Context) {
+	userId, ok := checkUserLevel(c, h.userRepo, "Users cannot manage users with higher or equal levels than themselves")
+	if !ok {
+		return
+	}
+	user, err := h.userRepo.GetUserById(userId)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get user information")
+		return
+	}
+	explicit, err := h.repo.GetExplicitMappings(userId)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get headscale users")
+		log.Log.Errorf("get headscale user mappings error: %v", err)
+		return
+	}
+	effective, err := h.repo.GetUserMappings(&user)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get headscale users")
+		log.Log.Errorf("get headscale user mappings error: %v", err)
+		return
+	}
+	response.Success(c, gin.H{"mappings": explicit, "effective": effective}, "success")
+}
+
+// Replace the headscale users mapped to a user, only the users who can manage the nodes of all users change their own
+func (h HeadscaleMappingController) SetUserMappings(c *gin.Context) {
+	var req vo.SetHeadscaleUserMappingRequest
+	// Bind parameters
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Fail(c, nil, "param error")
+		return
+	}
+	// Validate parameters
+	if err := common.Validate.Struct(&req); err != nil {
+		errStr := err.(validator.ValidationErrors)[0].Translate(common.Trans)
+		response.Fail(c, nil, errStr)
+		return
+	}
+	userId, ok := checkUserLevel(c, h.userRepo, "Users cannot manage users with higher or equal levels than themselves")
+	if !ok {
+		return
+	}
+	ctxUser, err := h.userRepo.GetCurrentUser(c)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get current user information")
+		return
+	}
+	// The mappings limit the user, it can not grant itself more headscale users
+	if ctxUser.ID == userId && !c.GetBool("machineFlag") {
+		response.Fail(c, nil, "Users cannot change their own headscale users")
+		return
+	}
+	// Only the headscale users the current user manages can be granted
+	user, err := h.userRepo.GetUserById(userId)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get user information")
+		return
+	}
+	access, err := h.repo.GetUserAccess(&ctxUser, c.GetBool("machineFlag"))
+	if err != nil {
+		response.Fail(c, nil, "Failed to get headscale users")
+		log.Log.Errorf("get headscale user access error: %v", err)
+		return
+	}
+	if err = access.CheckGrant(user.Name, req.Mappings); err != nil {
+		response.Fail(c, nil, err.Error())
+		return
+	}
+	if err = h.repo.SetUserMappings(userId, req.Mappings, ctxUser.Name); err != nil {
+		response.Fail(c, nil, "Failed to set headscale users: "+err.Error())
+		log.Log.Errorf("set headscale user mappings error: %v", err)
+		return
+	}
+	response.Success(c, nil, "Successfully set headscale users")
+}
+
+// getHeadscaleAccess returns the headscale users the current user can access, the error response is sent if it fails
+func getHeadscaleAccess(c *gin.Context, userRepo repository.IUserRepository) (*repository.HeadscaleAccess, bool) {
+	user, err := userRepo.GetCurrentUser(c)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get current user")
+		log.Log.Errorf("get current user error: %v", err)
+		return nil, false
+	}
+	access, err := repository.NewHeadscaleMappingRepository().GetUserAccess(&user, c.GetBool("machineFlag"))
+	if err != nil {
+		response.Fail(c, nil, "Failed to get headscale users")
+		log.Log.Errorf("get headscale user access error: %v", err)
+		return nil, false
+	}
+	return access, true
+}
@@ -3,9 +3,9 @@ package controller
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	pb "github.com/juanfont/headscale/gen/go/headscale/v1"
 	"headscale-panel/common"
 	"headscale-panel/log"
-	"headscale-panel/model"
 	"headscale-panel/repository"
 	"headscale-panel/response"
 	"headscale-panel/vo"
@@ -28,26 +28,54 @@ func NewNodesController() INodesController {
 	return &NodesController{userRepo: repository.NewUserRepository(), nodesRepo: repository.NewNodesRepo()}
 }
 
-// GetNodes get nodes by user name
+// GetNodes get nodes of the headscale users mapped to the current user, or of the user in the query
 func (m *NodesController) GetNodes(c *gin.Context) {
-	user, err := m.userRepo.GetCurrentUser(c)
-	if err != nil {
-		response.Fail(c, nil, "Failed to get Node")
-		log.Log.Errorf("get current user error: %v", err)
+	access, ok := getHeadscaleAccess(c, m.userRepo)
+	if !ok {
 		return
 	}
 
-	if mflag, ok := c.Get("machineFlag"); ok && mflag.(bool) {
-		user.Name = ""
+	users := access.Users()
+	if name := c.Query("user"); name != "" {
+		if !access.CanView(name) {
+			response.Fail(c, nil, repository.ErrHeadscaleUserDenied.Error())
+			return
+		}
+		users = []string{name}
+	} else if access.All {
+		users = []string{""}
+	}
+
+	Nodes := make([]*pb.Node, 0)
+	for _, name := range users {
+		list, err := m.nodesRepo.ListNodesWithUser(name)
+		if err != nil && err.Error() != "rpc error: code = Unknown desc = User not found" {
+			response.Fail(c, nil, "Failed to get Nodes")
+			log.Log.Errorf("get Node error: %v", err)
+			return
+		}
+		Nodes = append(Nodes, list...)
 	}
+	response.Success(c, Nodes, "success")
+}
 
-	Nodes, err := m.nodesRepo.ListNodesWithUser(user.Name)
-	if err != nil && err.Error() != "rpc error: code = Unknown desc = User not found" {
-		response.Fail(c, nil, "Failed to get Nodes")
+// checkNodeManage returns whether the current user can manage the headscale user of the node,
+// the error response is sent if it can not
+func (m *NodesController) checkNodeManage(c *gin.Context, access *repository.HeadscaleAccess, nodeId uint64) bool {
+	if access.All {
+		return true
+	}
+	node, err := m.nodesRepo.GetNodeWithId(nodeId)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get Node")
 		log.Log.Errorf("get Node error: %v", err)
-		return
+		return false
 	}
-	response.Success(c, Nodes, "success")
+	if !access.CanManage(node.GetUser().GetName()) {
+		response.Fail(c, nil, repository.ErrHeadscaleUserDenied.Error())
+		return false
+	}
+	return true
 }
 
 // StateNodes Register, expire, and rename devices.
@@ -66,6 +94,14 @@ func (m *NodesController) StateNodes(c *gin.Context) {
 		return
 	}
 
+	access, ok := getHeadscaleAccess(c, m.userRepo)
+	if !ok {
+		return
+	}
+	if req.State != "register" && !m.checkNodeManage(c, access, req.NodeId) {
+		return
+	}
+
 	var err error
 	var data interface{}
 	switch req.State {
@@ -76,13 +112,13 @@ func (m *NodesController) StateNodes(c *gin.Context) {
 		// expire node
 		data, err = m.nodesRepo.ExpireNodeWithId(req.NodeId)
 	case "register":
-		// register node
-		var user model.User
-		user, err = m.userRepo.GetCurrentUser(c)
-		if err != nil {
-			break
+		// register node to the chosen headscale user, or the default one of the current user
+		var user string
+		if user, err = access.Resolve(req.User); err != nil {
+			response.Fail(c, nil, err.Error())
+			return
 		}
-		data, err = m.nodesRepo.RegisterNodeWithKey(user.Name, req.Nodekey)
+		data, err = m.nodesRepo.RegisterNodeWithKey(user, req.Nodekey)
 	default:
 		response.Fail(c, nil, "params error")
 		return
@@ -112,6 +148,16 @@ func (m *NodesController) MoveNode(c *gin.Context) {
 		return
 	}
 
+	// Both the current and the target headscale user must be manageable
+	access, ok := getHeadscaleAccess(c, m.userRepo)
+	if !ok || !m.checkNodeManage(c, access, req.NodeId) {
+		return
+	}
+	if !access.CanManage(req.User) {
+		response.Fail(c, nil, repository.ErrHeadscaleUserDenied.Error())
+		return
+	}
+
 	Node, err := m.nodesRepo.MoveNode(req)
 	if err != nil {
 		response.Fail(c, nil, "Failed to move Node")
@@ -138,6 +184,11 @@ func (m *NodesController) DeleteNode(c *gin.Context) {
 		return
 	}
 
+	access, ok := getHeadscaleAccess(c, m.userRepo)
+	if !ok || !m.checkNodeManage(c, access, req.NodeId) {
+		return
+	}
+
 	if err := m.nodesRepo.DeleteNode(req); err != nil {
 		response.Fail(c, nil, "Failed to delete node")
 		log.Log.Errorf("delete node error: %v", err)
@@ -163,6 +214,11 @@ func (m *NodesController) SetTags(c *gin.Context) {
 		return
 	}
 
+	access, ok := getHeadscaleAccess(c, m.userRepo)
+	if !ok || !m.checkNodeManage(c, access, req.NodeId) {
+		return
+	}
+
 	data, err := m.nodesRepo.SetTagsWithStringSlice(req.NodeId, req.Tags)
 	if err != nil {
 		response.Fail(c, nil, "Failed to set tag")
@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"headscale-panel/common"
+	"headscale-panel/log"
+	"headscale-panel/repository"
+	"headscale-panel/response"
+	"headscale-panel/vo"
+	"strconv"
+)
+
+type IOAuthClientController interface {
+	GetClients(c *gin.Context)         // Get the OIDC clients
+	CreateClient(c *gin.Context)       // Create an OIDC client
+	UpdateClientById(c *gin.Context)   // Update an OIDC client
+	RotateSecretById(c *gin.Context)   // Replace the secret of an OIDC client
+	BatchDeleteClients(c *gin.Context) // Delete OIDC clients
+}
+
+type OAuthClientController struct {
+	repo     repository.IOAuthClientRepository
+	userRepo repository.IUserRepository
+}
+
+func NewOAuthClientController() IOAuthClientController {
+	return OAuthClientController{repo: repository.NewOAuthClientRepository(), userRepo: repository.NewUserRepository()}
+}
+
+// Get the OIDC clients, the built-in headscale client is the first
+func (o OAuthClientController) GetClients(c *gin.Context) {
+	clients, err := o.repo.ListClients()
+	if err != nil {
+		response.Fail(c, nil, "Failed to get client list")
+		log.Log.Errorf("get oauth client list error: %v", err)
+		return
+	}
+	response.Success(c, gin.H{"clients": clients}, "Successfully got client list")
+}
+
+// Create an OIDC client, the secret is only returned once
+func (o OAuthClientController) CreateClient(c *gin.Context) {
+	var req vo.CreateOAuthClientRequest
+	if err := c.ShouldBind(&req); err != nil {
+		response.Fail(c, nil, "param error")
+		return
+	}
+	if err := common.Validate.Struct(&req); err != nil {
+		errStr := err.(validator.ValidationErrors)[0].Translate(common.Trans)
+		response.Fail(c, nil, errStr)
+		return
+	}
+	ctxUser, err := o.userRepo.GetCurrentUser(c)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get current user information")
+		return
+	}
+
+	client, secret, err := o.repo.CreateClient(&req, ctxUser.Name)
+	if err != nil {
+		response.Fail(c, nil, "Failed to create client")
+		log.Log.Errorf("create oauth client error: %v", err)
+		return
+	}
+	response.Success(c, gin.H{"client": client, "secret": secret}, "Successfully created client")
+}
+
+// Update an OIDC client, disabling it revokes its tokens
+func (o OAuthClientController) UpdateClientById(c *gin.Context) {
+	var req vo.UpdateOAuthClientRequest
+	if err := c.ShouldBind(&req); err != nil {
+		response.Fail(c, nil, "param error")
+		return
+	}
+	if err := common.Validate.Struct(&req); err != nil {
+		errStr := err.(validator.ValidationErrors)[0].Translate(common.Trans)
+		response.Fail(c, nil, errStr)
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id <= 0 {
+		response.Fail(c, nil, "Incorrect client ID")
+		return
+	}
+
+	client, err := o.repo.UpdateClient(uint(id), &req)
+	if err != nil {
+		response.Fail(c, nil, "Failed to update client: "+err.Error())
+		log.Log.Errorf("update oauth client error: %v", err)
+		return
+	}
+	response.Success(c, gin.H{"client": client}, "Successfully updated client")
+}
+
+// Replace the secret of an OIDC client, the new secret is only returned once
+func (o OAuthClientController) RotateSecretById(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id <= 0 {
+		response.Fail(c, nil, "Incorrect client ID")
+		return
+	}
+	secret, err := o.repo.RotateSecret(uint(id))
+	if err != nil {
+		response.Fail(c, nil, "Failed to rotate client secret: "+err.Error())
+		log.Log.Errorf("rotate oauth client secret error: %v", err)
+		return
+	}
+	response.Success(c, gin.H{"secret": secret}, "Successfully rotated client secret")
+}
+
+// Delete OIDC clients and revoke their tokens
+func (o OAuthClientController) BatchDeleteClients(c *gin.Context) {
+	var req vo.DeleteOAuthClientRequest
+	if err := c.ShouldBind(&req); err != nil {
+		response.Fail(c, nil, "param error")
+		return
+	}
+	if err := common.Validate.Struct(&req); err != nil {
+		errStr := err.(validator.ValidationErrors)[0].Translate(common.Trans)
+		response.Fail(c, nil, errStr)
+		return
+	}
+	if err := o.repo.DeleteClients(req.Ids); err != nil {
+		response.Fail(c, nil, "Failed to delete clients")
+		log.Log.Errorf("delete oauth clients error: %v", err)
+		return
+	}
+	response.Success(c, nil, "Successfully deleted clients")
+}
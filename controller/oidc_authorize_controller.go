@@ -0,0 +1,318 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/thoas/go-funk"
+	"headscale-panel/common"
+	"headscale-panel/log"
+	"headscale-panel/model"
+	"headscale-panel/repository"
+	"headscale-panel/vo"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ssoCookie keeps the login session of the browser for the authorize endpoint
+const ssoCookie = "hp_oidc_session"
+
+// scopeDescriptions are shown on the consent page
+var scopeDescriptions = map[string]string{
+	"openid":  "Sign you in with your panel account",
+	"profile": "Your username and nickname",
+	"email":   "Your email address",
+	"groups":  "Your roles in the panel",
+}
+
+var authorizePage = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>headscale-panel</title>
+<style>
+body { font-family: sans-serif; background: #f5f5f5; display: flex; justify-content: center; padding-top: 10vh; }
+main { background: #fff; border-radius: 8px; padding: 24px 32px; max-width: 420px; box-shadow: 0 1px 4px rgba(0,0,0,.15); }
+button { padding: 8px 20px; margin-right: 8px; border-radius: 4px; border: 1px solid #409eff; cursor: pointer; }
+.allow { background: #409eff; color: #fff; }
+.deny { background: #fff; color: #409eff; }
+</style>
+</head>
+<body>
+<main>
+{{if .Error}}
+<h3>Authorization failed</h3>
+<p>{{.Error}}</p>
+{{else}}
+<h3>{{.Client}} wants to access your account</h3>
+<p>Signed in as <b>{{.User}}</b>. {{.Client}} will get:</p>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="id" value="{{.ID}}">
+<button class="allow" type="submit" name="action" value="allow">Allow</button>
+<button class="deny" type="submit" name="action" value="deny">Deny</button>
+</form>
+{{end}}
+</main>
+</body>
+</html>
+`))
+
+// BrowserAuthorize is the authorization endpoint of the OpenID Connect provider (OpenID Connect Core section 3.1.2).
+// A browser with an SSO session gets the code at once, otherwise it is sent to the login page of the panel,
+// which finishes the authorization with the POST endpoint.
+func (o *OIDCController) BrowserAuthorize(c *gin.Context) {
+	req := &vo.Authorize{}
+	if err := c.ShouldBindQuery(req); err != nil {
+		renderAuthorizePage(c, http.StatusBadRequest, gin.H{"Error": "Invalid authorization request."})
+		return
+	}
+	// The errors of an unknown client or redirect URI are shown to the user, they must not redirect (section 3.1.2.6)
+	client, err := o.clientRepo.GetClient(req.ClientID)
+	if err != nil {
+		renderAuthorizePage(c, http.StatusBadRequest, gin.H{"Error": "Unknown client."})
+		return
+	}
+	if !client.AllowsRedirectURI(req.RedirectURI) {
+		renderAuthorizePage(c, http.StatusBadRequest, gin.H{"Error": "The redirect URI is not registered for the client."})
+		return
+	}
+
+	if req.ResponseType != "code" {
+		authorizeErrorRedirect(c, req, "unsupported_response_type")
+		return
+	}
+	if err = common.Validate.Struct(req); err != nil {
+		authorizeErrorRedirect(c, req, "invalid_request")
+		return
+	}
+	if !client.AllowsGrantType(model.GrantAuthorizationCode) {
+		authorizeErrorRedirect(c, req, "unauthorized_client")
+		return
+	}
+	if _, err = repository.AuthorizeScope(client, req.Scope); err != nil {
+		authorizeErrorRedirect(c, req, "invalid_scope")
+		return
+	}
+	prompts := strings.Fields(req.Prompt)
+	if funk.ContainsString(prompts, "none") && len(prompts) > 1 {
+		authorizeErrorRedirect(c, req, "invalid_request")
+		return
+	}
+
+	var user *model.User
+	var sessionID string
+	if cookie, err := c.Cookie(ssoCookie); err == nil {
+		if user, sessionID, err = o.sessionRepo.CheckSSOToken(cookie); err != nil {
+			log.Log.Debugf("sso session rejected: %v", err)
+			user = nil
+			o.clearSSOCookie(c)
+		}
+	}
+	// The user must log in again
+	if funk.ContainsString(prompts, "login") || funk.ContainsString(prompts, "select_account") {
+		user = nil
+		o.clearSSOCookie(c)
+	}
+
+	if user == nil {
+		if funk.ContainsString(prompts, "none") {
+			authorizeErrorRedirect(c, req, "login_required")
+			return
+		}
+		// The login page of the panel posts the same parameters to the POST endpoint after the login
+		login := common.GetHeadscaleConfig().OIDC.Authorization
+		sep := "?"
+		if strings.Contains(login, "?") {
+			sep = "&"
+		}
+		c.Redirect(http.StatusFound, login+sep+c.Request.URL.RawQuery)
+		return
+	}
+
+	if o.needsConsent(client, user, req) {
+		if funk.ContainsString(prompts, "none") {
+			authorizeErrorRedirect(c, req, "consent_required")
+			return
+		}
+		consent, err := o.consentURL(&repository.PendingAuthorization{Request: *req, User: user, SessionID: sessionID})
+		if err != nil {
+			renderAuthorizePage(c, http.StatusInternalServerError, gin.H{"Error": "Unknown error."})
+			log.Log.Errorf("url join path error: %v", err)
+			return
+		}
+		c.Redirect(http.StatusFound, consent)
+		return
+	}
+
+	redirect, err := o.authorizationRedirect(client, user, req)
+	if err != nil {
+		renderAuthorizePage(c, http.StatusBadRequest, gin.H{"Error": "Invalid redirect URI."})
+		return
+	}
+	c.Redirect(http.StatusFound, redirect)
+}
+
+// ConsentPage asks the user to approve the scopes requested by a client which is not first-party
+func (o *OIDCController) ConsentPage(c *gin.Context) {
+	id := c.Query("id")
+	pending, ok := o.repo.GetPendingAuthorization(id)
+	if !ok || !o.sameSession(c, pending) {
+		renderAuthorizePage(c, http.StatusBadRequest, gin.H{"Error": "The authorization request has expired, please try again."})
+		return
+	}
+	client, err := o.clientRepo.GetClient(pending.Request.ClientID)
+	if err != nil {
+		renderAuthorizePage(c, http.StatusBadRequest, gin.H{"Error": "Unknown client."})
+		return
+	}
+
+	var scopes []string
+	for _, scope := range strings.Fields(client.FilterScope(pending.Request.Scope)) {
+		if description, ok := scopeDescriptions[scope]; ok {
+			scopes = append(scopes, description)
+		}
+	}
+	renderAuthorizePage(c, http.StatusOK, gin.H{
+		"Client": client.Name,
+		"User":   pending.User.Name,
+		"Scopes": scopes,
+		"ID":     id,
+		"Action": c.Request.URL.Path,
+	})
+}
+
+// Consent receives the answer of the consent page, the approved scopes are saved for the next authorizations
+func (o *OIDCController) Consent(c *gin.Context) {
+	req := &vo.ConsentRequest{}
+	if err := c.ShouldBind(req); err != nil {
+		renderAuthorizePage(c, http.StatusBadRequest, gin.H{"Error": "Invalid consent request."})
+		return
+	}
+	if err := common.Validate.Struct(req); err != nil {
+		renderAuthorizePage(c, http.StatusBadRequest, gin.H{"Error": "Invalid consent request."})
+		return
+	}
+	pending, ok := o.repo.TakePendingAuthorization(req.ID)
+	if !ok || !o.sameSession(c, pending) {
+		renderAuthorizePage(c, http.StatusBadRequest, gin.H{"Error": "The authorization request has expired, please try again."})
+		return
+	}
+	client, err := o.clientRepo.GetClient(pending.Request.ClientID)
+	if err != nil || !client.AllowsRedirectURI(pending.Request.RedirectURI) {
+		renderAuthorizePage(c, http.StatusBadRequest, gin.H{"Error": "Unknown client."})
+		return
+	}
+
+	if req.Action != "allow" {
+		authorizeErrorRedirect(c, &pending.Request, "access_denied")
+		return
+	}
+	scope := client.FilterScope(pending.Request.Scope)
+	if err = o.clientRepo.SaveConsent(pending.User.ID, client.ClientID, scope); err != nil {
+		renderAuthorizePage(c, http.StatusInternalServerError, gin.H{"Error": "Unknown error."})
+		log.Log.Errorf("save oauth consent error: %v", err)
+		return
+	}
+	redirect, err := o.authorizationRedirect(client, pending.User, &pending.Request)
+	if err != nil {
+		renderAuthorizePage(c, http.StatusBadRequest, gin.H{"Error": "Invalid redirect URI."})
+		return
+	}
+	c.Redirect(http.StatusFound, redirect)
+}
+
+// authorizationRedirect issues a code and returns the redirect URI with it
+func (o *OIDCController) authorizationRedirect(client *model.OAuthClient, user *model.User, req *vo.Authorize) (string, error) {
+	// Code used to exchange the access token, it is bound to the client, the redirect URI and the PKCE challenge
+	code := o.repo.GenerateCode(&repository.AuthorizationCode{
+		User:                user,
+		ClientID:            client.ClientID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               client.FilterScope(req.Scope),
+		Nonce:               req.Nonce,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+	})
+	return redirectWithParams(req.RedirectURI, map[string]string{"code": code, "state": req.State})
+}
+
+// needsConsent returns whether the user must approve the requested scopes first
+func (o *OIDCController) needsConsent(client *model.OAuthClient, user *model.User, req *vo.Authorize) bool {
+	if !client.NeedsConsent() {
+		return false
+	}
+	return funk.ContainsString(strings.Fields(req.Prompt), "consent") ||
+		!o.clientRepo.HasConsent(user.ID, client.ClientID, client.FilterScope(req.Scope))
+}
+
+// consentURL saves the request and returns the consent page of it
+func (o *OIDCController) consentURL(pending *repository.PendingAuthorization) (string, error) {
+	page, err := o.repo.Endpoint("/consent")
+	if err != nil {
+		return "", err
+	}
+	return page + "?id=" + url.QueryEscape(o.repo.SavePendingAuthorization(pending)), nil
+}
+
+// sameSession returns whether the SSO cookie is the login session of the pending request
+func (o *OIDCController) sameSession(c *gin.Context, pending *repository.PendingAuthorization) bool {
+	cookie, err := c.Cookie(ssoCookie)
+	if err != nil {
+		return false
+	}
+	_, sessionID, err := o.sessionRepo.CheckSSOToken(cookie)
+	return err == nil && sessionID == pending.SessionID
+}
+
+func (o *OIDCController) setSSOCookie(c *gin.Context, sessionID string) {
+	secure := strings.HasPrefix(common.GetHeadscaleConfig().OIDC.Issuer, "https://")
+	c.SetSameSite(http.SameSiteLaxMode)
+	// A browser session cookie, the login session is checked at every use
+	c.SetCookie(ssoCookie, o.sessionRepo.SSOToken(sessionID), 0, o.basePath, "", secure, true)
+}
+
+func (o *OIDCController) clearSSOCookie(c *gin.Context) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoCookie, "", -1, o.basePath, "", false, true)
+}
+
+// authorizeErrorRedirect returns the error to the client (RFC 6749 section 4.1.2.1)
+func authorizeErrorRedirect(c *gin.Context, req *vo.Authorize, code string) {
+	redirect, err := redirectWithParams(req.RedirectURI, map[string]string{"error": code, "state": req.State})
+	if err != nil {
+		renderAuthorizePage(c, http.StatusBadRequest, gin.H{"Error": "Invalid redirect URI."})
+		return
+	}
+	c.Redirect(http.StatusFound, redirect)
+}
+
+// redirectWithParams adds the non-empty parameters to the query of the URI
+func redirectWithParams(uri string, params map[string]string) (string, error) {
+	redirect, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	query := redirect.Query()
+	for key, value := range params {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	redirect.RawQuery = query.Encode()
+	return redirect.String(), nil
+}
+
+func renderAuthorizePage(c *gin.Context, status int, data gin.H) {
+	// The page must not be framed by another site (clickjacking of the consent)
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("Cache-Control", "no-store")
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := authorizePage.Execute(c.Writer, data); err != nil {
+		log.Log.Errorf("render authorize page error: %v", err)
+	}
+}
@@ -1,75 +1,83 @@
 package controller
 
 import (
-	"fmt"
+	"crypto/subtle"
+	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
 	"headscale-panel/common"
+	"headscale-panel/config"
 	"headscale-panel/log"
+	"headscale-panel/model"
 	"headscale-panel/repository"
 	"headscale-panel/response"
 	"headscale-panel/vo"
 	"net/http"
-	"net/url"
+	"strconv"
+	"strings"
 )
 
 type IOIDCController interface {
+	Discovery(c *gin.Context)
+	SelfCheck(c *gin.Context)
 	Authorize(c *gin.Context)
+	BrowserAuthorize(c *gin.Context)
+	ConsentPage(c *gin.Context)
+	Consent(c *gin.Context)
 	Token(c *gin.Context)
 	JWKs(c *gin.Context)
 	GetUserInfo(c *gin.Context)
+	Register(c *gin.Context)
+	Introspect(c *gin.Context)
+	Revoke(c *gin.Context)
 }
 
 type OIDCController struct {
-	repo     repository.OIDC
-	userRepo repository.IUserRepository
+	repo        repository.OIDC
+	userRepo    repository.IUserRepository
+	tokenRepo   repository.IOAuthTokenRepository
+	clientRepo  repository.IOAuthClientRepository
+	sessionRepo repository.ISessionRepository
+	basePath    string
 }
 
-func NewOIDCController() IOIDCController {
-	repo := repository.NewOIDC()
+// NewOIDCController creates the controller of the OIDC routes registered at the base path
+func NewOIDCController(basePath string) IOIDCController {
+	repo := repository.NewOIDC(basePath)
 	if repo == nil {
 		return nil
 	}
-	return &OIDCController{repo: repo, userRepo: repository.NewUserRepository()}
+	return &OIDCController{
+		repo:        repo,
+		userRepo:    repository.NewUserRepository(),
+		tokenRepo:   repository.NewOAuthTokenRepository(),
+		clientRepo:  repository.NewOAuthClientRepository(),
+		sessionRepo: repository.NewSessionRepository(),
+		basePath:    basePath,
+	}
 }
 
-// GetOpenIDConfiguration headscale Access this interface at startup to get the address of the oidc function interface
-// Returns a json with issuer, authorization endpoint, token endpoint, jwks uri, userinfo endpoint, list of signature encryption algorithms
-func GetOpenIDConfiguration(c *gin.Context) {
-	conf := common.GetHeadscaleConfig()
-	token, err := url.JoinPath(conf.OIDC.Issuer, "/api/oidc/token")
-	if err != nil {
-		response.Fail(c, nil, "Unknown error")
-		log.Log.Errorf("url join path error: %v", err)
-		return
-	}
-	jwks, err := url.JoinPath(conf.OIDC.Issuer, "/api/oidc/jwk")
-	if err != nil {
-		response.Fail(c, nil, "Unknown error")
-		log.Log.Errorf("url join path error: %v", err)
-		return
-	}
-	userInfo, err := url.JoinPath(conf.OIDC.Issuer, "/api/oidc/user_info")
+// Discovery returns the provider metadata, headscale gets the endpoints from it at startup
+func (o *OIDCController) Discovery(c *gin.Context) {
+	discovery, err := o.repo.GetDiscovery()
 	if err != nil {
 		response.Fail(c, nil, "Unknown error")
-		log.Log.Errorf("url join path error: %v", err)
+		log.Log.Errorf("get oidc discovery error: %v", err)
 		return
 	}
+	c.JSON(http.StatusOK, discovery)
+}
 
-	//authorization, err := url.JoinPath(conf.OIDC.Authorization, "/#/connect")
-	//if err != nil {
-	//	response.Fail(c, nil, "Unknown error")
-	//	log.Log.Errorf("url join path error: %v", err)
-	//	return
-	//}
-
-	c.JSON(http.StatusOK, gin.H{
-		"issuer":                                conf.OIDC.Issuer,
-		"authorization_endpoint":                conf.OIDC.Authorization,
-		"token_endpoint":                        token,
-		"jwks_uri":                              jwks,
-		"userinfo_endpoint":                     userInfo,
-		"id_token_signing_alg_values_supported": []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "PS256", "PS384", "PS512"},
-	})
+// SelfCheck validates the issuer configuration and the endpoints headscale will use
+func (o *OIDCController) SelfCheck(c *gin.Context) {
+	checks := o.repo.SelfCheck()
+	healthy := true
+	for _, check := range checks {
+		if check.Status == repository.OIDCCheckError {
+			healthy = false
+		}
+	}
+	response.Success(c, gin.H{"healthy": healthy, "checks": checks}, "success")
 }
 
 // Authorize is used to get authorization code
@@ -80,16 +88,63 @@ func (o *OIDCController) Authorize(c *gin.Context) {
 		response.Fail(c, nil, "param error")
 		return
 	}
+	if err := common.Validate.Struct(req); err != nil {
+		errStr := err.(validator.ValidationErrors)[0].Translate(common.Trans)
+		response.Fail(c, nil, errStr)
+		return
+	}
+
+	// Only a registered client can get a code, and only for one of its redirect URIs
+	client, err := o.clientRepo.GetClient(req.ClientID)
+	if err != nil {
+		response.Fail(c, nil, "invalid client")
+		return
+	}
+	if !client.AllowsRedirectURI(req.RedirectURI) {
+		response.Fail(c, nil, "redirect uri is not registered for the client")
+		return
+	}
+	if !client.AllowsGrantType(model.GrantAuthorizationCode) {
+		response.Fail(c, nil, "the client can not use the authorization code")
+		return
+	}
+	if _, err = repository.AuthorizeScope(client, req.Scope); err != nil {
+		response.Fail(c, nil, "invalid_scope")
+		return
+	}
 
 	user, err := o.userRepo.GetCurrentUser(c)
 	if err != nil {
 		response.Fail(c, nil, "get user info error")
 		return
 	}
-	// Code used to exchange the access token.
-	// It needs to randomly generate and storage the code
-	code := o.repo.GenerateCode(&user, req.RedirectURI)
-	response.Response(c, http.StatusOK, http.StatusFound, fmt.Sprintf("%s?code=%s&state=%s", req.RedirectURI, code, req.State), "")
+
+	// Keep the login session in the SSO cookie, the next authorizations of the browser skip the login page
+	jti := c.GetString("jti")
+	if jti != "" {
+		o.setSSOCookie(c, jti)
+	}
+	if o.needsConsent(client, &user, req) {
+		if jti == "" {
+			response.Fail(c, nil, "the consent requires a login session")
+			return
+		}
+		consent, err := o.consentURL(&repository.PendingAuthorization{Request: *req, User: &user, SessionID: jti})
+		if err != nil {
+			response.Fail(c, nil, "Unknown error")
+			log.Log.Errorf("url join path error: %v", err)
+			return
+		}
+		response.Response(c, http.StatusOK, http.StatusFound, consent, "")
+		return
+	}
+
+	redirect, err := o.authorizationRedirect(client, &user, req)
+	if err != nil {
+		response.Fail(c, nil, "invalid redirect uri")
+		return
+	}
+	response.Response(c, http.StatusOK, http.StatusFound, redirect, "")
 }
 
 // Token token endpoint
@@ -110,85 +165,226 @@ func (o *OIDCController) Token(c *gin.Context) {
 		return
 	}
 
+	client, ok := o.authenticateClient(c, req.ClientID, req.ClientSecret)
+	if !ok {
+		return
+	}
+	if !client.AllowsGrantType(req.GrantType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unauthorized_client"})
+		return
+	}
+
 	switch req.GrantType {
-	case "authorization_code":
-		// The client id and client secret are only the same as config file of headscale.
-		// Not use the RFC6749 compliant client id and secret.
-		// It could be realised in the future.
-		oidc := common.GetHeadscaleConfig().OIDC
-		if req.ClientID != oidc.ClientID {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+	case model.GrantAuthorizationCode:
+		// the code is single-use, bound to the client and the redirect URI, and checked against the PKCE challenge
+		authCode, err := o.repo.VerifyCode(req.Code, client.ClientID, req.RedirectURI, req.CodeVerifier)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant"})
+			log.Log.Warnf("invalid authorization code of client %s", client.ClientID)
+			return
+		}
+		tokens, err := o.tokenRepo.IssueTokens(authCode.User, client.ClientID, authCode.Scope)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+			log.Log.Errorf("issue oauth tokens error: %v", err)
 			return
 		}
-		// Determining whether to get a token or register a client.
-		if req.ClientSecret == "" {
-			// TODO register client id. It could be realised in the future.
-			c.JSON(http.StatusOK, nil)
-		} else {
-			if req.ClientSecret != oidc.ClientSecret {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
-				return
-			}
-			accessToken, refreshToken, err := o.repo.GetAccessTokenAndRefreshToken(req)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
-				return
-			}
-			// search the code from the storage, which has the code response tokens
-			user, ok := o.repo.VerifyCode(req.Code, req.RedirectURI)
-			if !ok {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
-				log.Log.Errorf("code error: code is %s", req.Code)
-				return
-			}
-			idToken, err := o.repo.GetIDToken(user, accessToken)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
-				log.Log.Errorf("Signing token error:%s", err)
-				return
-			}
-			c.JSON(http.StatusOK, gin.H{
-				"id_token":      idToken,
-				"access_token":  accessToken,
-				"refresh_token": refreshToken,
-				"token_type":    "Bearer",
-				"expires_in":    3600,
-			})
+		o.tokenResponse(c, tokens, authCode.Nonce)
+	case model.GrantRefreshToken:
+		tokens, err := o.tokenRepo.RefreshTokens(req.RefreshToken, client.ClientID, req.Scope)
+		if errors.Is(err, repository.ErrInvalidGrant) || errors.Is(err, repository.ErrInvalidScope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
-	case "refresh_token":
-		// TODO Depends on whether headscale is required.
-		accessToken, refreshToken, err := o.repo.GetAccessTokenAndRefreshToken(nil)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+			log.Log.Errorf("refresh oauth tokens error: %v", err)
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{
-			"access_token":  accessToken,
-			"refresh_token": refreshToken,
-			"token_type":    "Bearer",
-			"expires_in":    3600,
-		})
+		o.tokenResponse(c, tokens, "")
 	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported_grant_type"})
+	}
+}
+
+// authenticateClient verifies the client credentials, the error response is sent if they are invalid
+func (o *OIDCController) authenticateClient(c *gin.Context, clientID, clientSecret string) (*model.OAuthClient, bool) {
+	// The client credentials can be sent in the basic authorization header (RFC 6749 section 2.3.1)
+	if id, secret, ok := c.Request.BasicAuth(); ok {
+		clientID, clientSecret = id, secret
+	}
+	client, err := o.clientRepo.AuthenticateClient(clientID, clientSecret)
+	if err != nil {
+		c.Header("WWW-Authenticate", `Basic realm="oidc"`)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+		return nil, false
+	}
+	return client, true
+}
+
+// tokenResponse returns the tokens and the ID token of the user (RFC 6749 section 5.1)
+func (o *OIDCController) tokenResponse(c *gin.Context, tokens *repository.OAuthTokens, nonce string) {
+	idToken, err := o.repo.GetIDToken(tokens, nonce)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		log.Log.Errorf("Signing token error:%s", err)
+		return
 	}
+	c.JSON(http.StatusOK, gin.H{
+		"id_token":      idToken,
+		"access_token":  tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    tokens.ExpiresIn,
+		"scope":         tokens.Scope,
+	})
 }
 
 // JWKs return the cert to verify key signed the jwt
 func (o *OIDCController) JWKs(c *gin.Context) {
+	keys, err := o.repo.GetJsonWebKeys()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		log.Log.Errorf("get json web keys error: %v", err)
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"keys": o.repo.GetJsonWebKeys(),
+		"keys": keys,
 	})
 }
 
-// GetUserInfo using AccessToken
-// It Will Return UserInfo
-// Depends on whether headscale is required.
+// GetUserInfo returns the claims of the user of the access token (OpenID Connect Core section 5.3)
 func (o *OIDCController) GetUserInfo(c *gin.Context) {
-	user, err := o.userRepo.GetCurrentUser(c)
+	accessToken, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || accessToken == "" {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_request"`)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_request"})
+		return
+	}
+	user, t, err := o.tokenRepo.VerifyAccessToken(accessToken)
+	if err != nil {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+		return
+	}
+	c.JSON(http.StatusOK, o.repo.UserClaims(user, t.Scope))
+}
+
+// Register is the dynamic client registration endpoint (RFC 7591 section 3)
+func (o *OIDCController) Register(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	conf := config.Conf.Headscale.OIDC
+	if !conf.Registration {
+		c.JSON(http.StatusNotFound, gin.H{"error": "registration is disabled"})
+		return
+	}
+	// The initial access token is required if it is configured (RFC 7591 section 3)
+	if conf.RegistrationToken != "" {
+		token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(conf.RegistrationToken)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+	}
+
+	req := &vo.RegisterClientRequest{}
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_client_metadata"})
+		return
+	}
+
+	client, secret, err := o.clientRepo.RegisterClient(req)
+	var regErr *repository.RegistrationError
+	if errors.As(err, &regErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": regErr.Code, "error_description": regErr.Description})
+		return
+	}
 	if err != nil {
-		response.Fail(c, nil, "Failed to get user")
-		log.Log.Errorf("get current user error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		log.Log.Errorf("register oauth client error: %v", err)
+		return
+	}
+	log.Log.Infof("oauth client %s (%s) registered", client.ClientID, client.Name)
+	c.JSON(http.StatusCreated, gin.H{
+		"client_id":                  client.ClientID,
+		"client_secret":              secret,
+		"client_id_issued_at":        client.CreatedAt.Unix(),
+		"client_secret_expires_at":   0,
+		"client_name":                client.Name,
+		"redirect_uris":              client.RedirectURIs,
+		"grant_types":                client.GrantTypes,
+		"response_types":             []string{"code"},
+		"scope":                      strings.Join(client.Scopes, " "),
+		"token_endpoint_auth_method": "client_secret_basic",
+	})
+}
+
+// Introspect returns whether a token of the client is active and its information (RFC 7662)
+func (o *OIDCController) Introspect(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	req := &vo.TokenOperation{}
+	if err := c.ShouldBind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+	client, ok := o.authenticateClient(c, req.ClientID, req.ClientSecret)
+	if !ok {
+		return
+	}
+	if err := common.Validate.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	user, t, err := o.tokenRepo.IntrospectToken(req.Token, client.ClientID)
+	if err != nil {
+		// Why the token is not active is not disclosed (RFC 7662 section 2.2)
+		c.JSON(http.StatusOK, gin.H{"active": false})
+		return
+	}
+	tokenType := "Bearer"
+	if t.Type == model.OAuthRefreshToken {
+		tokenType = "refresh_token"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"active":     true,
+		"scope":      t.Scope,
+		"client_id":  t.ClientID,
+		"username":   user.Name,
+		"token_type": tokenType,
+		"exp":        t.ExpiresAt.Unix(),
+		"iat":        t.CreatedAt.Unix(),
+		"sub":        strconv.FormatUint(uint64(user.ID), 10),
+		"aud":        t.ClientID,
+		"iss":        common.GetHeadscaleConfig().OIDC.Issuer,
+	})
+}
+
+// Revoke revokes a token of the client, revoking a refresh token revokes the tokens of the same authorization (RFC 7009)
+func (o *OIDCController) Revoke(c *gin.Context) {
+	req := &vo.TokenOperation{}
+	if err := c.ShouldBind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+	client, ok := o.authenticateClient(c, req.ClientID, req.ClientSecret)
+	if !ok {
+		return
+	}
+	if err := common.Validate.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	if err := o.tokenRepo.RevokeToken(req.Token, client.ClientID); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "server_error"})
+		log.Log.Errorf("revoke oauth token error: %v", err)
 		return
 	}
-	c.JSON(http.StatusOK, user)
+	// An invalid token is a success as well (RFC 7009 section 2.2)
+	c.Status(http.StatusOK)
 }
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	pb "github.com/juanfont/headscale/gen/go/headscale/v1"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"gorm.io/gorm"
 	"headscale-panel/common"
@@ -26,33 +27,43 @@ type preAuthKeyController struct {
 }
 
 // NewPreAuthKeyController new a controller to
-// Only obtain the user's own PreAuthKey and cannot operate other users' PreAuthKey
+// Only obtain the PreAuthKey of the headscale users mapped to the user and cannot operate other users' PreAuthKey
 func NewPreAuthKeyController() PreAuthKeyController {
 	return &preAuthKeyController{repo: repository.NewPreAuthkeyRepo(), userRepo: repository.NewUserRepository()}
 }
 
-// ListPreAuthKey get user PreAuthKey by current user
+// ListPreAuthKey get PreAuthKey of the headscale users mapped to the current user, or of the user in the query
 func (p *preAuthKeyController) ListPreAuthKey(c *gin.Context) {
-	// Get current user
-	user, err := p.userRepo.GetCurrentUser(c)
-	if err != nil {
-		response.Fail(c, nil, "Failed to get current user")
-		log.Log.Errorf("failed to get current user: %v", err)
+	access, ok := getHeadscaleAccess(c, p.userRepo)
+	if !ok {
 		return
 	}
 
-	rsp, err := p.repo.ListPreAuthKeyWithString(user.Name)
-	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
-		response.Fail(c, nil, "unknown error")
-		log.Log.Errorf("list preauth key error: %v", err)
-		return
+	users := access.Users()
+	if name := c.Query("user"); name != "" {
+		if !access.CanView(name) {
+			response.Fail(c, nil, repository.ErrHeadscaleUserDenied.Error())
+			return
+		}
+		users = []string{name}
+	}
+
+	keys := make([]*pb.PreAuthKey, 0)
+	for _, name := range users {
+		rsp, err := p.repo.ListPreAuthKeyWithString(name)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			response.Fail(c, nil, "unknown error")
+			log.Log.Errorf("list preauth key error: %v", err)
+			return
+		}
+		keys = append(keys, rsp...)
 	}
 	//if err != nil && err.Error() != "rpc error: code = Unknown desc = User not found" {
 	//	response.Fail(c, nil, "Not found user")
 	//	log.Log.Errorf("not found user: %v", err)
 	//	return
 	//}
-	response.Success(c, rsp, "Success")
+	response.Success(c, keys, "Success")
 }
 
 // CreatePreAuthKey create PreAuthKey by current user
@@ -71,14 +82,17 @@ func (p *preAuthKeyController) CreatePreAuthKey(c *gin.Context) {
 		return
 	}
 
-	// Get current user
-	user, err := p.userRepo.GetCurrentUser(c)
+	// The chosen headscale user, or the default one of the current user
+	access, ok := getHeadscaleAccess(c, p.userRepo)
+	if !ok {
+		return
+	}
+	user, err := access.Resolve(req.User)
 	if err != nil {
-		response.Fail(c, nil, "can't get user info")
-		log.Log.Error(err)
+		response.Fail(c, nil, err.Error())
 		return
 	}
-	req.User = user.Name
+	req.User = user
 
 	// Parse the ISO time format and convert it to timestamppb and reassign req.Expiration
 	expire, err := time.Parse("2006-01-02T15:04:05.000Z", req.Expire)
@@ -114,15 +128,18 @@ func (p *preAuthKeyController) ExpirePreAuthKey(c *gin.Context) {
 		return
 	}
 
-	// Get current user and reassign req.User
-	user, err := p.userRepo.GetCurrentUser(c)
+	// The chosen headscale user, or the default one of the current user
+	access, ok := getHeadscaleAccess(c, p.userRepo)
+	if !ok {
+		return
+	}
+	user, err := access.Resolve(req.User)
 	if err != nil {
-		response.Fail(c, nil, "can't get user info")
-		log.Log.Error(err)
+		response.Fail(c, nil, err.Error())
 		return
 	}
 
-	req.User = user.Name
+	req.User = user
 	if err := p.repo.ExpirePreAuthKey(&req); err != nil {
 		response.Fail(c, nil, "param error")
 		return
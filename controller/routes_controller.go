@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	pb "github.com/juanfont/headscale/gen/go/headscale/v1"
 	"headscale-panel/common"
 	"headscale-panel/log"
 	"headscale-panel/repository"
@@ -19,11 +20,13 @@ type RouteController interface {
 }
 
 type routeController struct {
-	repo repository.HeadscaleRouteRepository
+	repo      repository.HeadscaleRouteRepository
+	nodesRepo repository.HeadscaleNodesRepository
+	userRepo  repository.IUserRepository
 }
 
 func NewRoutesController() RouteController {
-	return &routeController{repo: repository.NewRouteRepo()}
+	return &routeController{repo: repository.NewRouteRepo(), nodesRepo: repository.NewNodesRepo(), userRepo: repository.NewUserRepository()}
 }
 
 func (r *routeController) GetMachinesRoute(c *gin.Context) {
@@ -34,18 +37,66 @@ func (r *routeController) GetMachinesRoute(c *gin.Context) {
 		return
 	}
 
-	var routes interface{}
+	access, ok := getHeadscaleAccess(c, r.userRepo)
+	if !ok {
+		return
+	}
+
+	var routes []*pb.Route
 	if id == 0 {
 		routes, err = r.repo.GetRoutes()
 	} else {
-		routes, err = r.repo.GetNodeRoutesWithId(id)
+		var node *pb.Node
+		if node, err = r.nodesRepo.GetNodeWithId(id); err == nil && !access.CanView(node.GetUser().GetName()) {
+			response.Fail(c, nil, repository.ErrHeadscaleUserDenied.Error())
+			return
+		}
+		if err == nil {
+			routes, err = r.repo.GetNodeRoutesWithId(id)
+		}
 	}
 	//routes, err := r.repo.GetMachineRoutesWithId(id)
 	if err != nil {
 		response.Fail(c, nil, "Get routes error")
 		return
 	}
-	response.Success(c, routes, "success")
+
+	// Only the routes of the headscale users mapped to the current user are returned
+	visible := make([]*pb.Route, 0, len(routes))
+	for _, route := range routes {
+		if access.CanView(route.GetNode().GetUser().GetName()) {
+			visible = append(visible, route)
+		}
+	}
+	response.Success(c, visible, "success")
+}
+
+// checkRouteManage returns whether the current user can manage the headscale user of the route's node,
+// the error response is sent if it can not
+func (r *routeController) checkRouteManage(c *gin.Context, routeId uint64) bool {
+	access, ok := getHeadscaleAccess(c, r.userRepo)
+	if !ok {
+		return false
+	}
+	if access.All {
+		return true
+	}
+	routes, err := r.repo.GetRoutes()
+	if err != nil {
+		response.Fail(c, nil, "Get routes error")
+		log.Log.Errorf("get routes error: %v", err)
+		return false
+	}
+	for _, route := range routes {
+		if route.GetId() == routeId {
+			if access.CanManage(route.GetNode().GetUser().GetName()) {
+				return true
+			}
+			break
+		}
+	}
+	response.Fail(c, nil, repository.ErrHeadscaleUserDenied.Error())
+	return false
 }
 
 func (r *routeController) DeleteRoute(c *gin.Context) {
@@ -62,6 +113,10 @@ func (r *routeController) DeleteRoute(c *gin.Context) {
 		return
 	}
 
+	if !r.checkRouteManage(c, req.RouteId) {
+		return
+	}
+
 	if err := r.repo.DeleteRoute(req); err != nil {
 		response.Fail(c, nil, "Failed to delete route")
 		log.Log.Errorf("delete route error: %v", err)
@@ -84,6 +139,10 @@ func (r *routeController) SwitchRoute(c *gin.Context) {
 		return
 	}
 
+	if !r.checkRouteManage(c, req.RouteId) {
+		return
+	}
+
 	err := r.repo.SwitchRoute(req)
 	if err != nil {
 		response.Fail(c, nil, fmt.Sprintf("Failed to switch to %v", req.Enable))
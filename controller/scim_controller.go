@@ -0,0 +1,310 @@
+package controller
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"headscale-panel/common"
+	"headscale-panel/dto"
+	"headscale-panel/log"
+	"headscale-panel/repository"
+	"headscale-panel/response"
+	"headscale-panel/vo"
+	"net/http"
+)
+
+type IScimController interface {
+	ServiceProviderConfig(c *gin.Context) // SCIM service provider capabilities
+
+	ListUsers(c *gin.Context)
+	GetUser(c *gin.Context)
+	CreateUser(c *gin.Context)
+	ReplaceUser(c *gin.Context)
+	PatchUser(c *gin.Context)
+	DeleteUser(c *gin.Context)
+
+	ListGroups(c *gin.Context)
+	GetGroup(c *gin.Context)
+	CreateGroup(c *gin.Context)
+	ReplaceGroup(c *gin.Context)
+	PatchGroup(c *gin.Context)
+	DeleteGroup(c *gin.Context)
+
+	ListTokens(c *gin.Context)  // List bearer tokens in the panel
+	CreateToken(c *gin.Context) // Create a bearer token in the panel
+	DeleteTokens(c *gin.Context)
+}
+
+type ScimController struct {
+	repo     repository.IScimRepository
+	userRepo repository.IUserRepository
+}
+
+func NewScimController() IScimController {
+	return ScimController{repo: repository.NewScimRepository(), userRepo: repository.NewUserRepository()}
+}
+
+func (s ScimController) ServiceProviderConfig(c *gin.Context) {
+	response.Scim(c, http.StatusOK, gin.H{
+		"schemas":        []string{dto.ScimProviderSchema},
+		"patch":          gin.H{"supported": true},
+		"bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         gin.H{"supported": true, "maxResults": 200},
+		"changePassword": gin.H{"supported": true},
+		"sort":           gin.H{"supported": false},
+		"etag":           gin.H{"supported": false},
+		"authenticationSchemes": []gin.H{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer Token",
+			"description": "Bearer token created in the panel",
+			"primary":     true,
+		}},
+	})
+}
+
+// Users
+
+func (s ScimController) ListUsers(c *gin.Context) {
+	var req vo.ScimListRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.ScimFail(c, http.StatusBadRequest, "invalidValue", "param error")
+		return
+	}
+	users, total, err := s.repo.ListUsers(&req)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	resources := make([]dto.ScimUser, 0, len(users))
+	for _, user := range users {
+		resources = append(resources, dto.ToScimUser(user))
+	}
+	response.Scim(c, http.StatusOK, dto.ScimListResponse{
+		Schemas:      []string{dto.ScimListSchema},
+		TotalResults: total,
+		StartIndex:   req.StartIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+func (s ScimController) GetUser(c *gin.Context) {
+	user, err := s.repo.GetUser(c.Param("id"))
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	response.Scim(c, http.StatusOK, dto.ToScimUser(user))
+}
+
+func (s ScimController) CreateUser(c *gin.Context) {
+	var req vo.ScimUserRequest
+	if !bindScim(c, &req) {
+		return
+	}
+	user, err := s.repo.CreateUser(&req)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	response.Scim(c, http.StatusCreated, dto.ToScimUser(user))
+}
+
+func (s ScimController) ReplaceUser(c *gin.Context) {
+	var req vo.ScimUserRequest
+	if !bindScim(c, &req) {
+		return
+	}
+	user, err := s.repo.ReplaceUser(c.Param("id"), &req)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	response.Scim(c, http.StatusOK, dto.ToScimUser(user))
+}
+
+func (s ScimController) PatchUser(c *gin.Context) {
+	var req vo.ScimPatchRequest
+	if !bindScim(c, &req) {
+		return
+	}
+	user, err := s.repo.PatchUser(c.Param("id"), &req)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	response.Scim(c, http.StatusOK, dto.ToScimUser(user))
+}
+
+func (s ScimController) DeleteUser(c *gin.Context) {
+	if err := s.repo.DeleteUser(c.Param("id")); err != nil {
+		scimFail(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Groups
+
+func (s ScimController) ListGroups(c *gin.Context) {
+	var req vo.ScimListRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.ScimFail(c, http.StatusBadRequest, "invalidValue", "param error")
+		return
+	}
+	roles, total, err := s.repo.ListGroups(&req)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	resources := make([]dto.ScimGroup, 0, len(roles))
+	for _, role := range roles {
+		resources = append(resources, dto.ToScimGroup(role))
+	}
+	response.Scim(c, http.StatusOK, dto.ScimListResponse{
+		Schemas:      []string{dto.ScimListSchema},
+		TotalResults: total,
+		StartIndex:   req.StartIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+func (s ScimController) GetGroup(c *gin.Context) {
+	role, err := s.repo.GetGroup(c.Param("id"))
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	response.Scim(c, http.StatusOK, dto.ToScimGroup(role))
+}
+
+func (s ScimController) CreateGroup(c *gin.Context) {
+	var req vo.ScimGroupRequest
+	if !bindScim(c, &req) {
+		return
+	}
+	role, err := s.repo.CreateGroup(&req)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	response.Scim(c, http.StatusCreated, dto.ToScimGroup(role))
+}
+
+func (s ScimController) ReplaceGroup(c *gin.Context) {
+	var req vo.ScimGroupRequest
+	if !bindScim(c, &req) {
+		return
+	}
+	role, err := s.repo.ReplaceGroup(c.Param("id"), &req)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	response.Scim(c, http.StatusOK, dto.ToScimGroup(role))
+}
+
+func (s ScimController) PatchGroup(c *gin.Context) {
+	var req vo.ScimPatchRequest
+	if !bindScim(c, &req) {
+		return
+	}
+	role, err := s.repo.PatchGroup(c.Param("id"), &req)
+	if err != nil {
+		scimFail(c, err)
+		return
+	}
+	response.Scim(c, http.StatusOK, dto.ToScimGroup(role))
+}
+
+func (s ScimController) DeleteGroup(c *gin.Context) {
+	if err := s.repo.DeleteGroup(c.Param("id")); err != nil {
+		scimFail(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Bearer tokens
+
+func (s ScimController) ListTokens(c *gin.Context) {
+	tokens, err := s.repo.ListTokens()
+	if err != nil {
+		response.Fail(c, nil, "Failed to get SCIM token list")
+		log.Log.Errorf("get scim token list error: %v", err)
+		return
+	}
+	response.Success(c, gin.H{"tokens": tokens}, "Successfully got SCIM token list")
+}
+
+func (s ScimController) CreateToken(c *gin.Context) {
+	var req vo.CreateScimTokenRequest
+	if err := c.ShouldBind(&req); err != nil {
+		response.Fail(c, nil, "param error")
+		return
+	}
+	if err := common.Validate.Struct(&req); err != nil {
+		errStr := err.(validator.ValidationErrors)[0].Translate(common.Trans)
+		response.Fail(c, nil, errStr)
+		return
+	}
+	ctxUser, err := s.userRepo.GetCurrentUser(c)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get current user information")
+		return
+	}
+
+	token, t, err := s.repo.CreateToken(&req, ctxUser.Name)
+	if err != nil {
+		response.Fail(c, nil, "Failed to create SCIM token")
+		log.Log.Errorf("create scim token error: %v", err)
+		return
+	}
+	// The token is only returned once
+	response.Success(c, gin.H{"token": token, "info": t}, "Successfully created SCIM token")
+}
+
+func (s ScimController) DeleteTokens(c *gin.Context) {
+	var req vo.DeleteScimTokenRequest
+	if err := c.ShouldBind(&req); err != nil {
+		response.Fail(c, nil, "param error")
+		return
+	}
+	if err := common.Validate.Struct(&req); err != nil {
+		errStr := err.(validator.ValidationErrors)[0].Translate(common.Trans)
+		response.Fail(c, nil, errStr)
+		return
+	}
+	if err := s.repo.DeleteTokens(req.Ids); err != nil {
+		response.Fail(c, nil, "Failed to delete SCIM token")
+		log.Log.Errorf("delete scim token error: %v", err)
+		return
+	}
+	response.Success(c, nil, "Successfully deleted SCIM token")
+}
+
+// bindScim binds and validates the SCIM request body, the error response is sent if it fails
+func bindScim(c *gin.Context, req interface{}) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		response.ScimFail(c, http.StatusBadRequest, "invalidSyntax", "invalid request body")
+		return false
+	}
+	if err := common.Validate.Struct(req); err != nil {
+		errStr := err.(validator.ValidationErrors)[0].Translate(common.Trans)
+		response.ScimFail(c, http.StatusBadRequest, "invalidValue", errStr)
+		return false
+	}
+	return true
+}
+
+// scimFail sends the SCIM error response of the repository error
+func scimFail(c *gin.Context, err error) {
+	var scimErr *repository.ScimError
+	if errors.As(err, &scimErr) {
+		response.ScimFail(c, scimErr.Status, scimErr.ScimType, scimErr.Detail)
+		return
+	}
+	log.Log.Errorf("scim %s %s error: %v", c.Request.Method, c.Request.URL.Path, err)
+	response.ScimFail(c, http.StatusInternalServerError, "", "Internal server error")
+}
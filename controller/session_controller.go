@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"headscale-panel/common"
+	"headscale-panel/log"
+	"headscale-panel/repository"
+	"headscale-panel/response"
+	"headscale-panel/vo"
+	"strconv"
+)
+
+type ISessionController interface {
+	GetSessions(c *gin.Context)         // Get the sessions of the current user
+	BatchRevokeSessions(c *gin.Context) // Revoke the sessions of the current user
+	GetUserSessions(c *gin.Context)     // Get the sessions of a user
+	RevokeUserSessions(c *gin.Context)  // Force logout a user
+}
+
+type SessionController struct {
+	repo     repository.ISessionRepository
+	userRepo repository.IUserRepository
+}
+
+func NewSessionController() ISessionController {
+	return SessionController{repo: repository.NewSessionRepository(), userRepo: repository.NewUserRepository()}
+}
+
+// Get the sessions of the current user, the session of the request is marked as current
+func (s SessionController) GetSessions(c *gin.Context) {
+	user, err := s.userRepo.GetCurrentUser(c)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get current user information")
+		return
+	}
+	sessions, err := s.repo.GetUserSessions(user.ID)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get session list")
+		log.Log.Errorf("get session list error: %v", err)
+		return
+	}
+	jti := c.GetString("jti")
+	for _, session := range sessions {
+		session.Current = session.TokenID == jti
+	}
+	response.Success(c, gin.H{"sessions": sessions}, "Successfully got session list")
+}
+
+// Revoke the sessions of the current user, all other sessions are revoked if no ID is passed
+func (s SessionController) BatchRevokeSessions(c *gin.Context) {
+	var req vo.RevokeSessionRequest
+	if err := c.ShouldBind(&req); err != nil {
+		response.Fail(c, nil, "param error")
+		return
+	}
+	if err := common.Validate.Struct(&req); err != nil {
+		errStr := err.(validator.ValidationErrors)[0].Translate(common.Trans)
+		response.Fail(c, nil, errStr)
+		return
+	}
+	user, err := s.userRepo.GetCurrentUser(c)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get current user information")
+		return
+	}
+
+	var except string
+	if len(req.SessionIds) == 0 {
+		except = c.GetString("jti")
+	}
+	if err = s.repo.RevokeUserSessions(user.ID, req.SessionIds, except); err != nil {
+		response.Fail(c, nil, "Failed to revoke sessions")
+		log.Log.Errorf("revoke sessions error: %v", err)
+		return
+	}
+	response.Success(c, nil, "Successfully revoked sessions")
+}
+
+// Get the sessions of a user
+func (s SessionController) GetUserSessions(c *gin.Context) {
+	userId, ok := checkUserLevel(c, s.userRepo, "Users cannot manage the sessions of users with higher or equal levels than themselves")
+	if !ok {
+		return
+	}
+	sessions, err := s.repo.GetUserSessions(userId)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get session list")
+		log.Log.Errorf("get session list error: %v", err)
+		return
+	}
+	response.Success(c, gin.H{"sessions": sessions}, "Successfully got session list")
+}
+
+// Force logout a user by revoking all its sessions and personal access tokens
+func (s SessionController) RevokeUserSessions(c *gin.Context) {
+	userId, ok := checkUserLevel(c, s.userRepo, "Users cannot manage the sessions of users with higher or equal levels than themselves")
+	if !ok {
+		return
+	}
+	if err := s.repo.RevokeUserSessions(userId, nil, ""); err != nil {
+		response.Fail(c, nil, "Failed to revoke sessions")
+		log.Log.Errorf("revoke sessions error: %v", err)
+		return
+	}
+	if err := repository.NewAccessTokenRepository().RevokeUserTokens(userId); err != nil {
+		response.Fail(c, nil, "Failed to revoke access tokens")
+		log.Log.Errorf("revoke access tokens error: %v", err)
+		return
+	}
+	response.Success(c, nil, "Successfully logged out the user")
+}
+
+// checkUserLevel returns the user ID in the path if the current user has a higher role level than the user
+func checkUserLevel(c *gin.Context, userRepo repository.IUserRepository, failMessage string) (uint, bool) {
+	userId, _ := strconv.Atoi(c.Param("userId"))
+	if userId <= 0 {
+		response.Fail(c, nil, "Incorrect user ID")
+		return 0, false
+	}
+	minSort, ctxUser, err := userRepo.GetCurrentUserMinRoleSort(c)
+	if err != nil {
+		response.Fail(c, nil, "Failed to get current user")
+		log.Log.Errorf("get current user error: %v", err)
+		return 0, false
+	}
+	if ctxUser.ID == uint(userId) {
+		return ctxUser.ID, true
+	}
+	roleMinSortList, err := userRepo.GetUserMinRoleSortsByIds([]uint{uint(userId)})
+	if err != nil {
+		response.Fail(c, nil, "Failed to get the minimum user role sort value by user ID")
+		return 0, false
+	}
+	for _, sort := range roleMinSortList {
+		if int(minSort) >= sort {
+			response.Fail(c, nil, failMessage)
+			return 0, false
+		}
+	}
+	return uint(userId), true
+}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"github.com/gin-gonic/gin"
+	"headscale-panel/config"
+	"headscale-panel/log"
+	"headscale-panel/repository"
+	"headscale-panel/response"
+	"headscale-panel/vo"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ssoStateCookie binds the sso state to the browser which started the login, a callback sent to another browser is
+// rejected (login CSRF)
+const ssoStateCookie = "hp_sso_state"
+
+// ssoStateMaxAge is how long the login can take, the states are kept as long in the cache
+const ssoStateMaxAge = 600
+
+type ISsoController interface {
+	Info(c *gin.Context)
+	Login(c *gin.Context)
+	Callback(c *gin.Context)
+}
+
+type SsoController struct{}
+
+func NewSsoController() ISsoController {
+	return SsoController{}
+}
+
+// Info returns whether the sso login is enabled, used by the frontend to show the login button
+func (s SsoController) Info(c *gin.Context) {
+	conf := config.Conf.Sso
+	if conf == nil || !conf.Enable {
+		response.Success(c, gin.H{"enable": false}, "")
+		return
+	}
+	response.Success(c, gin.H{"enable": true, "name": conf.Name}, "")
+}
+
+// Login redirects the browser to the authorization endpoint of the external identity provider
+func (s SsoController) Login(c *gin.Context) {
+	sso, err := repository.NewSsoProvider()
+	if err != nil {
+		response.Fail(c, nil, "SSO is not available")
+		log.Log.Errorf("sso login error: %v", err)
+		return
+	}
+	authURL, state := sso.AuthCodeURL()
+	setSsoStateCookie(c, sso.RedirectURL(), state, ssoStateMaxAge)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback is called by the external identity provider with the authorization code,
+// the browser is redirected to the frontend with a one-time ticket to get the panel token
+func (s SsoController) Callback(c *gin.Context) {
+	sso, err := repository.NewSsoProvider()
+	if err != nil {
+		response.Fail(c, nil, "SSO is not available")
+		log.Log.Errorf("sso callback error: %v", err)
+		return
+	}
+
+	var req vo.SsoCallbackRequest
+	if err = c.ShouldBindQuery(&req); err != nil {
+		ssoRedirect(c, sso.FrontendURL(), "sso_error", "param error")
+		return
+	}
+	if req.Error != "" {
+		log.Log.Errorf("sso callback error: %s %s", req.Error, req.ErrorDescription)
+		ssoRedirect(c, sso.FrontendURL(), "sso_error", req.Error)
+		return
+	}
+
+	// The state is single-use, the cookie is dropped whatever the result
+	browserState, _ := c.Cookie(ssoStateCookie)
+	setSsoStateCookie(c, sso.RedirectURL(), "", -1)
+	if browserState == "" || subtle.ConstantTimeCompare([]byte(browserState), []byte(req.State)) != 1 {
+		log.Log.Warn("sso callback rejected, the state was not started by this browser")
+		ssoRedirect(c, sso.FrontendURL(), "sso_error", "invalid sso state")
+		return
+	}
+
+	user, err := sso.Exchange(c.Request.Context(), req.State, req.Code)
+	if err != nil {
+		log.Log.Errorf("sso login error: %v", err)
+		ssoRedirect(c, sso.FrontendURL(), "sso_error", err.Error())
+		return
+	}
+	ssoRedirect(c, sso.FrontendURL(), "ticket", sso.IssueTicket(user))
+}
+
+// setSsoStateCookie keeps the state for the callback url only. It is sent on the redirection from the identity
+// provider, which is a top-level navigation.
+func setSsoStateCookie(c *gin.Context, redirectURL, state string, maxAge int) {
+	path := "/"
+	secure := false
+	if u, err := url.Parse(redirectURL); err == nil {
+		if u.Path != "" {
+			path = u.Path
+		}
+		secure = u.Scheme == "https"
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoStateCookie, state, maxAge, path, "", secure, true)
+}
+
+// ssoRedirect appends the parameter to the frontend url, it also works for the hash router of the frontend
+func ssoRedirect(c *gin.Context, frontend, key, value string) {
+	sep := "?"
+	if strings.Contains(frontend, "?") {
+		sep = "&"
+	}
+	c.Redirect(http.StatusFound, frontend+sep+key+"="+url.QueryEscape(value))
+}
@@ -23,17 +23,24 @@ type IUserController interface {
 	CreateUser(c *gin.Context)           // Create user
 	UpdateUserById(c *gin.Context)       // Update user
 	BatchDeleteUserByIds(c *gin.Context) // Batch delete users
+	PreviewImportUsers(c *gin.Context)   // Validate the imported users without creating them
+	ImportUsers(c *gin.Context)          // Import users from a CSV or JSON file
+	ExportUsers(c *gin.Context)          // Export users with their roles and node counts
+	GetInvite(c *gin.Context)            // Get the user of an invite
+	AcceptInvite(c *gin.Context)         // Set the password with an invite
 }
 
 type UserController struct {
 	UserRepository          repository.IUserRepository
 	HeadscaleUserRepository repository.HeadscaleUserRepository
+	UserImportRepository    repository.IUserImportRepository
 }
 
 func NewUserController() IUserController {
 	userRepository := repository.NewUserRepository()
 	headscaleUserRepository := repository.NewUserRepo()
-	userController := UserController{UserRepository: userRepository, HeadscaleUserRepository: headscaleUserRepository}
+	userImportRepository := repository.NewUserImportRepository()
+	userController := UserController{UserRepository: userRepository, HeadscaleUserRepository: headscaleUserRepository, UserImportRepository: userImportRepository}
 	return userController
 }
 
@@ -115,6 +122,12 @@ func (uc UserController) ChangePwd(c *gin.Context) {
 		response.Fail(c, nil, "Get current user failed")
 		return
 	}
+	// The password of the user from an external directory can only be changed in the directory, a SCIM user may sign
+	// in with a local password
+	if user.Source != "" && user.Source != repository.SourceLocal && user.Source != repository.SourceScim {
+		response.Fail(c, nil, "The password is managed by "+user.Source)
+		return
+	}
 	// Obtain the user's true and correct password
 	correctPasswd := user.Password
 	// Determine if the password requested by the front-end is equal to the real password
@@ -260,6 +273,11 @@ func (uc UserController) UpdateUserById(c *gin.Context) {
 		log.Log.Errorf("get user information to update error: %v", err)
 		return
 	}
+	// The name of a provisioned user belongs to its provider, LDAP finds the user by it and SCIM sets it again
+	if req.Username != oldUser.Name && (oldUser.Source == repository.SourceScim || oldUser.Source == repository.SourceLdap) {
+		response.Fail(c, nil, "The username is managed by "+oldUser.Source)
+		return
+	}
 
 	// Get current user
 	ctxUser, err := uc.UserRepository.GetCurrentUser(c)
@@ -315,6 +333,8 @@ func (uc UserController) UpdateUserById(c *gin.Context) {
 		Introduction: req.Introduction,
 		Status:       req.Status,
 		Creator:      ctxUser.Name,
+		Source:       oldUser.Source,
+		ExternalID:   oldUser.ExternalID,
 		Roles:        roles,
 	}
 	// Determining whether to update yourself or someone else
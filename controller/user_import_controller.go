@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"headscale-panel/common"
+	"headscale-panel/config"
+	"headscale-panel/log"
+	"headscale-panel/repository"
+	"headscale-panel/response"
+	"headscale-panel/util"
+	"headscale-panel/vo"
+	"net/http"
+	"time"
+)
+
+// Validate the imported users without creating them
+func (uc UserController) PreviewImportUsers(c *gin.Context) {
+	uc.importUsers(c, true)
+}
+
+// Import users from a CSV or JSON file, nothing is created if any user is invalid
+func (uc UserController) ImportUsers(c *gin.Context) {
+	uc.importUsers(c, false)
+}
+
+func (uc UserController) importUsers(c *gin.Context, preview bool) {
+	var req vo.ImportUsersRequest
+	// Bind parameters
+	if err := c.ShouldBind(&req); err != nil {
+		response.Fail(c, nil, "param error")
+		return
+	}
+	// Validate parameters
+	if err := common.Validate.Struct(&req); err != nil {
+		errStr := err.(validator.ValidationErrors)[0].Translate(common.Trans)
+		response.Fail(c, nil, errStr)
+		return
+	}
+	if req.CreatePreAuthKey && !req.CreateHeadscaleUser {
+		response.Fail(c, nil, "The pre-auth keys require the headscale users")
+		return
+	}
+
+	// Current user role sort minimum (highest ranked role) and current user
+	currentRoleSortMin, ctxUser, err := uc.UserRepository.GetCurrentUserMinRoleSort(c)
+	if err != nil {
+		response.Fail(c, nil, "Failed to current user role")
+		log.Log.Error(err)
+		return
+	}
+
+	results, ok, err := uc.UserImportRepository.ImportUsers(&req, &ctxUser, currentRoleSortMin, preview)
+	if err != nil {
+		response.Fail(c, nil, "Failed to import users: "+err.Error())
+		log.Log.Errorf("import users error: %v", err)
+		return
+	}
+	if !ok {
+		response.Fail(c, gin.H{"users": results}, "Some users are invalid, no user is imported")
+		return
+	}
+	if preview {
+		response.Success(c, gin.H{"users": results}, "All users are valid")
+		return
+	}
+	response.Success(c, gin.H{"users": results}, "Successfully imported users")
+}
+
+// Export users with their roles and node counts as a CSV or JSON file
+func (uc UserController) ExportUsers(c *gin.Context) {
+	var req vo.ExportUsersRequest
+	// Bind parameters
+	if err := c.ShouldBind(&req); err != nil {
+		response.Fail(c, nil, "param error")
+		return
+	}
+	// Validate parameters
+	if err := common.Validate.Struct(&req); err != nil {
+		errStr := err.(validator.ValidationErrors)[0].Translate(common.Trans)
+		response.Fail(c, nil, errStr)
+		return
+	}
+
+	users, err := uc.UserImportRepository.ExportUsers()
+	if err != nil {
+		response.Fail(c, nil, "Failed to export users")
+		log.Log.Errorf("export users error: %v", err)
+		return
+	}
+
+	filename := "users-" + time.Now().Format("20060102150405")
+	if req.Format == "json" {
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		c.JSON(http.StatusOK, users)
+		return
+	}
+	var buf bytes.Buffer
+	if err = repository.WriteUsersCSV(&buf, users); err != nil {
+		response.Fail(c, nil, "Failed to export users")
+		log.Log.Errorf("export users error: %v", err)
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
+// Get the user of an invite, used by the frontend to show the username
+func (uc UserController) GetInvite(c *gin.Context) {
+	user, err := uc.UserImportRepository.GetInvite(c.Query("token"))
+	if err != nil {
+		response.Fail(c, nil, err.Error())
+		return
+	}
+	response.Success(c, gin.H{"username": user.Name, "nickname": user.Nickname}, "")
+}
+
+// Set the password with an invite
+func (uc UserController) AcceptInvite(c *gin.Context) {
+	var req vo.AcceptInviteRequest
+	// Bind parameters
+	if err := c.ShouldBind(&req); err != nil {
+		response.Fail(c, nil, "param error")
+		return
+	}
+	// Validate parameters
+	if err := common.Validate.Struct(&req); err != nil {
+		errStr := err.(validator.ValidationErrors)[0].Translate(common.Trans)
+		response.Fail(c, nil, errStr)
+		return
+	}
+
+	// The password is decrypted by RSA
+	decodeData, err := util.RSADecrypt([]byte(req.Password), config.Conf.System.PrivateKey)
+	if err != nil {
+		response.Fail(c, nil, "Operate password error")
+		log.Log.Error(err)
+		return
+	}
+	req.Password = string(decodeData)
+	if len(req.Password) < 6 {
+		response.Fail(c, nil, "Password length must be at least 6 characters")
+		return
+	}
+
+	user, err := uc.UserImportRepository.AcceptInvite(req.Token, req.Password)
+	if errors.Is(err, repository.ErrInviteInvalid) {
+		response.Fail(c, nil, err.Error())
+		return
+	}
+	if err != nil {
+		response.Fail(c, nil, "Failed to set password")
+		log.Log.Errorf("accept invite of %s error: %v", user.Name, err)
+		return
+	}
+	response.Success(c, gin.H{"username": user.Name}, "Successfully set password, please login")
+}
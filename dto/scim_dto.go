@@ -0,0 +1,128 @@
+package dto
+
+import (
+	"headscale-panel/model"
+	"strconv"
+	"time"
+)
+
+// SCIM 2.0 schemas (RFC 7643, RFC 7644)
+const (
+	ScimUserSchema     = "urn:ietf:params:scim:schemas:core:2.0:User"
+	ScimGroupSchema    = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ScimListSchema     = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	ScimErrorSchema    = "urn:ietf:params:scim:api:messages:2.0:Error"
+	ScimPatchSchema    = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ScimProviderSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+)
+
+type ScimMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location,omitempty"`
+}
+
+type ScimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type ScimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type ScimMember struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type ScimUser struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id"`
+	ExternalID  string       `json:"externalId,omitempty"`
+	UserName    string       `json:"userName"`
+	Name        *ScimName    `json:"name,omitempty"`
+	DisplayName string       `json:"displayName,omitempty"`
+	Emails      []ScimEmail  `json:"emails,omitempty"`
+	Active      bool         `json:"active"`
+	Groups      []ScimMember `json:"groups,omitempty"`
+	Meta        ScimMeta     `json:"meta"`
+}
+
+type ScimGroup struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id"`
+	DisplayName string       `json:"displayName"`
+	Members     []ScimMember `json:"members"`
+	Meta        ScimMeta     `json:"meta"`
+}
+
+type ScimListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int64       `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+type ScimError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+func ToScimUser(user *model.User) ScimUser {
+	u := ScimUser{
+		Schemas:     []string{ScimUserSchema},
+		ID:          strconv.FormatUint(uint64(user.ID), 10),
+		ExternalID:  user.ExternalID,
+		UserName:    user.Name,
+		DisplayName: user.Nickname,
+		Active:      user.Status == 1,
+		Meta: ScimMeta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     "/scim/v2/Users/" + strconv.FormatUint(uint64(user.ID), 10),
+		},
+	}
+	if user.Nickname != "" {
+		u.Name = &ScimName{Formatted: user.Nickname}
+	}
+	if user.Email != "" {
+		u.Emails = []ScimEmail{{Value: user.Email, Type: "work", Primary: true}}
+	}
+	for _, role := range user.Roles {
+		u.Groups = append(u.Groups, ScimMember{Value: strconv.FormatUint(uint64(role.ID), 10), Display: role.Name})
+	}
+	return u
+}
+
+func ToScimGroup(role *model.Role) ScimGroup {
+	g := ScimGroup{
+		Schemas:     []string{ScimGroupSchema},
+		ID:          strconv.FormatUint(uint64(role.ID), 10),
+		DisplayName: role.Name,
+		Members:     make([]ScimMember, 0, len(role.Users)),
+		Meta: ScimMeta{
+			ResourceType: "Group",
+			Created:      role.CreatedAt,
+			LastModified: role.UpdatedAt,
+			Location:     "/scim/v2/Groups/" + strconv.FormatUint(uint64(role.ID), 10),
+		},
+	}
+	for _, user := range role.Users {
+		g.Members = append(g.Members, ScimMember{
+			Value:   strconv.FormatUint(uint64(user.ID), 10),
+			Display: user.Name,
+			Ref:     "/scim/v2/Users/" + strconv.FormatUint(uint64(user.ID), 10),
+		})
+	}
+	return g
+}
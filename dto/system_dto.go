@@ -71,3 +71,22 @@ type NetIO struct {
 	Up   uint64 `json:"up"`
 	Down uint64 `json:"down"`
 }
+
+// Status of the apply of a headscale config
+const (
+	ConfigJobRunning    = "running"     // Headscale is restarted with the new config
+	ConfigJobSucceeded  = "succeeded"   // Headscale is healthy with the new config
+	ConfigJobRolledBack = "rolled_back" // Headscale was not healthy, the previous config is restored
+	ConfigJobFailed     = "failed"      // Headscale was not healthy, and the previous config could not be restored
+)
+
+// HeadscaleConfigJob is the apply of a headscale config
+type HeadscaleConfigJob struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	Version    uint       `json:"version"` // The version of the config which is applied
+	Creator    string     `json:"creator"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
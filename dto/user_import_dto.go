@@ -0,0 +1,26 @@
+package dto
+
+// Result of a user in the imported file
+type ImportUserResult struct {
+	Line       int      `json:"line"`
+	Username   string   `json:"username"`
+	Email      string   `json:"email"`
+	Nickname   string   `json:"nickname"`
+	Roles      []string `json:"roles"`
+	Errors     []string `json:"errors"`
+	Invite     string   `json:"invite,omitempty"`     // Invite token, only returned once
+	PreAuthKey string   `json:"preAuthKey,omitempty"` // Pre-auth key of the headscale user
+	Warnings   []string `json:"warnings,omitempty"`   // Failures after the user is created
+}
+
+// User of the export file
+type ExportUserDto struct {
+	Username  string   `json:"username"`
+	Email     string   `json:"email"`
+	Nickname  string   `json:"nickname"`
+	Roles     []string `json:"roles"`
+	Status    uint     `json:"status"`
+	Source    string   `json:"source"`
+	NodeCount int      `json:"nodeCount"`
+	CreatedAt string   `json:"createdAt"`
+}
@@ -39,6 +39,9 @@ func main() {
 	// load headscale config to global config struct when the mode is not multi
 	config.InitHeadscaleConfig()
 
+	// record the versions of the headscale config which were changed outside the panel
+	repository.WatchHeadscaleConfigVersions()
+
 	// init headscale config info for headscale-panel
 	common.InitHeadscale()
 
@@ -49,6 +52,40 @@ func main() {
 		panic(err)
 	}
 
+	// Periodically delete the expired login sessions
+	if err = tk.AddJob("@every 1h", repository.NewSessionRepository().CleanExpiredSessions); err != nil {
+		log.Log.Error(err)
+		panic(err)
+	}
+
+	// Periodically delete the expired invites of the imported users
+	if err = tk.AddJob("@every 1h", repository.NewUserImportRepository().CleanExpiredInvites); err != nil {
+		log.Log.Error(err)
+		panic(err)
+	}
+
+	// Periodically delete the expired tokens of the OIDC clients
+	if err = tk.AddJob("@every 1h", repository.NewOAuthTokenRepository().CleanExpiredTokens); err != nil {
+		log.Log.Error(err)
+		panic(err)
+	}
+
+	// Periodically rotate the signing keys of the OIDC provider
+	keyRepo := repository.NewSigningKeyRepository()
+	keyRepo.RotateKeys()
+	if err = tk.AddJob("@every 1h", keyRepo.RotateKeys); err != nil {
+		log.Log.Error(err)
+		panic(err)
+	}
+
+	// Periodically disable the users removed from the LDAP directory
+	if ldapProvider := repository.NewLdapProvider(); ldapProvider != nil && config.Conf.Ldap.SyncInterval > 0 {
+		if err = tk.AddJob(fmt.Sprintf("@every %dm", config.Conf.Ldap.SyncInterval), ldapProvider.Sync); err != nil {
+			log.Log.Error(err)
+			panic(err)
+		}
+	}
+
 	// Instead of sending the logs to rabbitmq or kafka, the operation logging middleware sends the logs to a channel
 	// Here, three goroutines are enabled to handle the channels and log to the database
 	logRepository := repository.NewOperationLogRepository()
@@ -11,9 +11,14 @@ import (
 	"headscale-panel/response"
 	"headscale-panel/util"
 	"headscale-panel/vo"
+	"net/http"
+	"strings"
 	"time"
 )
 
+// sessionErrorKey keeps why authorizator rejected the session of the token, unauthorized answers it
+const sessionErrorKey = "sessionError"
+
 // Initialize jwt middleware
 func InitAuth() (*jwt.GinJWTMiddleware, error) {
 	authMiddleware, err := jwt.New(&jwt.GinJWTMiddleware{
@@ -46,6 +51,7 @@ func payloadFunc(data interface{}) jwt.MapClaims {
 			jwt.IdentityKey: user.ID,
 			"user":          v["user"],
 			"machineFlag":   v["machineFlag"],
+			"jti":           v["jti"],
 		}
 	}
 	return jwt.MapClaims{}
@@ -60,6 +66,7 @@ func identityHandler(c *gin.Context) interface{} {
 		"IdentityKey": claims[jwt.IdentityKey],
 		"user":        claims["user"],
 		"machineFlag": claims["machineFlag"],
+		"jti":         claims["jti"],
 	}
 }
 
@@ -77,39 +84,140 @@ func login(c *gin.Context) (interface{}, error) {
 		return nil, err
 	}
 
-	u := &model.User{
-		Name:     req.Username,
-		Password: string(decodeData),
+	// Password verification by the local database or the external directory
+	user, err := repository.Authenticate(req.Username, string(decodeData))
+	if err != nil {
+		return nil, err
 	}
 
-	// Password verification
-	userRepository := repository.NewUserRepository()
-	user, err := userRepository.Login(u)
+	return loginData(c, user)
+}
+
+// SsoLoginHandler issues the panel token for the ticket of the external identity provider,
+// the token is generated by the same jwt middleware as the password login
+func SsoLoginHandler(authMiddleware *jwt.GinJWTMiddleware) gin.HandlerFunc {
+	mw := *authMiddleware
+	mw.Authenticator = ssoLogin
+	return mw.LoginHandler
+}
+
+// Redeem the one-time ticket issued by the sso callback
+func ssoLogin(c *gin.Context) (interface{}, error) {
+	var req vo.SsoLoginRequest
+	if err := c.ShouldBind(&req); err != nil {
+		return "", err
+	}
+
+	sso, err := repository.NewSsoProvider()
 	if err != nil {
 		return nil, err
 	}
+	user, err := sso.RedeemTicket(req.Ticket)
+	if err != nil {
+		return nil, err
+	}
+	return loginData(c, user)
+}
 
-	menus, err := repository.NewMenuRepository().GetUserMenusByUserId(user.ID)
+// loginData creates the session and returns the login data of the user which is used by payloadFunc
+func loginData(c *gin.Context, user *model.User) (interface{}, error) {
+	mflag, err := machineFlag(user)
 	if err != nil {
 		return nil, err
 	}
 
 	repository.ClearUserRefreshToken(user.Name)
 
-	var mflag bool
-	for _, menu := range menus {
-		if menu.Name == "MachinesManage" {
-			mflag = true
-		}
+	// The session ID is saved in the jti claim, it is checked by authorizator
+	session, err := repository.NewSessionRepository().CreateSession(user, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		return nil, err
 	}
 
 	// Writing the user in json format, payloadFunc/authorizator will use the
 	return map[string]interface{}{
 		"user":        util.Struct2Json(user),
 		"machineFlag": mflag,
+		"jti":         session.TokenID,
 	}, nil
 }
 
+// machineFlag returns whether the user can manage the machines of all users
+func machineFlag(user *model.User) (bool, error) {
+	menus, err := repository.NewMenuRepository().GetUserMenusByUserId(user.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, menu := range menus {
+		if menu.Name == "MachinesManage" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// TokenAuth accepts the personal access tokens alongside the JWTs,
+// the scopes of the token are checked by the Casbin middleware
+func TokenAuth(authMiddleware *jwt.GinJWTMiddleware) gin.HandlerFunc {
+	jwtAuth := authMiddleware.MiddlewareFunc()
+	repo := repository.NewAccessTokenRepository()
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), authMiddleware.TokenHeadName+" ")
+		if !ok || !strings.HasPrefix(token, repository.AccessTokenPrefix) {
+			jwtAuth(c)
+			return
+		}
+
+		user, t, err := repo.VerifyToken(token)
+		if err != nil {
+			unauthorized(c, http.StatusUnauthorized, err.Error())
+			c.Abort()
+			return
+		}
+		mflag, err := machineFlag(user)
+		if err != nil {
+			unauthorized(c, http.StatusUnauthorized, err.Error())
+			c.Abort()
+			return
+		}
+		c.Set("user", *user)
+		c.Set("machineFlag", mflag)
+		c.Set("tokenScopes", t.Scopes)
+		c.Next()
+	}
+}
+
+// RefreshHandler refreshes the token of the session which is not revoked and extends the session
+func RefreshHandler(authMiddleware *jwt.GinJWTMiddleware) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := authMiddleware.CheckIfTokenExpire(c)
+		if err != nil {
+			unauthorized(c, http.StatusUnauthorized, authMiddleware.HTTPStatusMessageFunc(err, c))
+			return
+		}
+		jti, _ := claims["jti"].(string)
+		if err = repository.NewSessionRepository().RefreshSession(jti); err != nil {
+			unauthorized(c, http.StatusUnauthorized, err.Error())
+			return
+		}
+		authMiddleware.RefreshHandler(c)
+	}
+}
+
+// LogoutHandler revokes the session of the token, then clears the jwt cookie
+func LogoutHandler(authMiddleware *jwt.GinJWTMiddleware) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if claims, err := authMiddleware.CheckIfTokenExpire(c); err == nil {
+			if jti, ok := claims["jti"].(string); ok {
+				if err = repository.NewSessionRepository().RevokeSession(jti); err != nil {
+					log.Log.Errorf("revoke session error: %v", err)
+				}
+			}
+		}
+		authMiddleware.LogoutHandler(c)
+	}
+}
+
 // Processing of successful user login verification
 func authorizator(data interface{}, c *gin.Context) bool {
 	if kv, ok := data.(map[string]interface{}); ok {
@@ -130,6 +238,13 @@ func authorizator(data interface{}, c *gin.Context) bool {
 			}
 		}
 		if len(user.Name) > 0 && !repository.GetUserRefreshToken(user.Name) {
+			// The revoked session is rejected before its token expires
+			jti, _ := kv["jti"].(string)
+			if err := repository.NewSessionRepository().CheckSession(jti); err != nil {
+				log.Log.Debugf("session of %s is rejected: %v", user.Name, err)
+				c.Set(sessionErrorKey, err.Error())
+				return false
+			}
 			return true
 		}
 	}
@@ -138,6 +253,10 @@ func authorizator(data interface{}, c *gin.Context) bool {
 
 // Processing of failed user login verification
 func unauthorized(c *gin.Context, code int, message string) {
+	// The user logs in again when the session is rejected, it is not a missing permission
+	if sessionErr := c.GetString(sessionErrorKey); sessionErr != "" {
+		code, message = http.StatusUnauthorized, sessionErr
+	}
 	log.Log.Debugf("JWT authentication failed, error code: %d, error message: %s", code, message)
 	response.Response(c, code, code, nil, fmt.Sprintf("JWT authentication failed, error code: %d, error message: %s", code, message))
 }
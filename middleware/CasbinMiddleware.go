@@ -1,9 +1,11 @@
 package middleware
 
 import (
+	"github.com/casbin/casbin/v2/util"
 	"github.com/gin-gonic/gin"
 	"headscale-panel/common"
 	"headscale-panel/config"
+	"headscale-panel/model"
 	"headscale-panel/repository"
 	"headscale-panel/response"
 	"strings"
@@ -50,6 +52,13 @@ func CasbinMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// The personal access token can only access the APIs in its scopes
+		if scopes, ok := c.Get("tokenScopes"); ok && !checkScopes(scopes.([]model.TokenScope), obj, act) {
+			response.Response(c, 401, 401, nil, "The token has no permission for this API")
+			c.Abort()
+			return
+		}
+
 		c.Next()
 	}
 }
@@ -68,3 +77,13 @@ func check(subs []string, obj string, act string) bool {
 	}
 	return isPass
 }
+
+// checkScopes matches the request the same way as the Casbin model
+func checkScopes(scopes []model.TokenScope, obj string, act string) bool {
+	for _, scope := range scopes {
+		if (util.KeyMatch(obj, scope.Path) || util.KeyMatch2(obj, scope.Path)) && (act == scope.Method || scope.Method == "*") {
+			return true
+		}
+	}
+	return false
+}
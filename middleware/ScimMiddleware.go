@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"headscale-panel/config"
+	"headscale-panel/log"
+	"headscale-panel/model"
+	"headscale-panel/repository"
+	"headscale-panel/response"
+	"net/http"
+	"strings"
+)
+
+// ScimMiddleware authenticates the SCIM client by the bearer tokens created in the panel
+func ScimMiddleware() gin.HandlerFunc {
+	repo := repository.NewScimRepository()
+	return func(c *gin.Context) {
+		if conf := config.Conf.Scim; conf == nil || !conf.Enable {
+			response.ScimFail(c, http.StatusNotFound, "", "SCIM is not enabled")
+			c.Abort()
+			return
+		}
+
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			response.ScimFail(c, http.StatusUnauthorized, "", "Bearer token is required")
+			c.Abort()
+			return
+		}
+		if err := repo.VerifyToken(token); err != nil {
+			log.Log.Debugf("scim token verification failed: %v", err)
+			response.ScimFail(c, http.StatusUnauthorized, "", "Invalid bearer token")
+			c.Abort()
+			return
+		}
+		// Record the operation logs as the scim user
+		c.Set("user", model.User{Name: repository.SourceScim})
+		c.Next()
+	}
+}
@@ -0,0 +1,33 @@
+package model
+
+// ACLPolicy is the ACL policy file of headscale, in HuJSON or YAML
+type ACLPolicy struct {
+	Groups        map[string][]string `json:"groups" yaml:"groups"`
+	Hosts         map[string]string   `json:"hosts" yaml:"hosts"`
+	TagOwners     map[string][]string `json:"tagOwners" yaml:"tagOwners"`
+	ACLs          []ACLRule           `json:"acls" yaml:"acls"`
+	SSHs          []SSHRule           `json:"ssh" yaml:"ssh"`
+	AutoApprovers AutoApprovers       `json:"autoApprovers" yaml:"autoApprovers"`
+}
+
+// ACLRule is a rule of the acls section
+type ACLRule struct {
+	Action       string   `json:"action" yaml:"action"`
+	Protocol     string   `json:"proto,omitempty" yaml:"proto,omitempty"`
+	Sources      []string `json:"src" yaml:"src"`
+	Destinations []string `json:"dst" yaml:"dst"`
+}
+
+// SSHRule is a rule of the ssh section
+type SSHRule struct {
+	Action       string   `json:"action" yaml:"action"`
+	Sources      []string `json:"src" yaml:"src"`
+	Destinations []string `json:"dst" yaml:"dst"`
+	Users        []string `json:"users" yaml:"users"`
+}
+
+// AutoApprovers is the autoApprovers section, the approvers of the routes by prefix and of the exit nodes
+type AutoApprovers struct {
+	Routes   map[string][]string `json:"routes" yaml:"routes"`
+	ExitNode []string            `json:"exitNode" yaml:"exitNode"`
+}
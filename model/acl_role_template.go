@@ -0,0 +1,15 @@
+package model
+
+import "gorm.io/gorm"
+
+// ACLRoleTemplate generates the ACL group group:<keyword> of a role from its members, with the rules of the role.
+// $group in the sources and destinations of the rules is replaced with the group.
+type ACLRoleTemplate struct {
+	gorm.Model
+	RoleID    uint      `gorm:"not null;uniqueIndex;comment:Role of the group" json:"roleId"`
+	Role      *Role     `json:"role,omitempty"`
+	Rules     []ACLRule `gorm:"type:text;serializer:json;comment:Rules of the role" json:"rules"`
+	GroupName string    `gorm:"type:varchar(30);comment:Group written by the last sync" json:"group"`
+	Generated []ACLRule `gorm:"type:text;serializer:json;comment:Rules written by the last sync" json:"generated"`
+	Creator   string    `gorm:"type:varchar(20);comment:Created by" json:"creator"`
+}
@@ -0,0 +1,12 @@
+package model
+
+import "gorm.io/gorm"
+
+// ACLVersion is a saved content of the ACL policy file
+type ACLVersion struct {
+	gorm.Model
+	Content string `gorm:"type:text;comment:Content of the policy file" json:"content,omitempty"`
+	Comment string `gorm:"type:varchar(255);comment:Comment of the change" json:"comment"`
+	Creator string `gorm:"type:varchar(20);comment:Created by" json:"creator"`
+	Applied bool   `gorm:"comment:Headscale ran with the policy" json:"applied"`
+}
@@ -13,22 +13,38 @@ type Headscale struct {
 }
 
 type HeadscaleConfig struct {
-	GRPCListenAddr string    `json:"grpc_listen_addr" mapstructure:"grpc_listen_addr"`
-	ApiKey         string    `json:"api_key" mapstructure:"-"`
-	Insecure       bool      `json:"grpc_allow_insecure" mapstructure:"grpc_allow_insecure"`
-	CustomCert     bool      `json:"custom_cert" mapstructure:"-"`
-	Cert           []byte    `json:"tls_cert_path" mapstructure:"-"`
-	CA             []byte    `json:"ca_path" mapstructure:"-"`
-	Key            []byte    `json:"tls_key_path" mapstructure:"-"`
-	ServerName     string    `json:"server_name" mapstructure:"-"`
-	AccessControl  string    `json:"acl_policy_path" mapstructure:"acl_policy_path"`
-	DNS            DNSConfig `json:"dns_config" mapstructure:"dns_config"`
-	OIDC           OIDC      `json:"oidc" mapstructure:"oidc"`
+	GRPCListenAddr string       `json:"grpc_listen_addr" mapstructure:"grpc_listen_addr"`
+	ApiKey         string       `json:"api_key" mapstructure:"-"`
+	Insecure       bool         `json:"grpc_allow_insecure" mapstructure:"grpc_allow_insecure"`
+	CustomCert     bool         `json:"custom_cert" mapstructure:"-"`
+	Cert           []byte       `json:"tls_cert_path" mapstructure:"-"`
+	CA             []byte       `json:"ca_path" mapstructure:"-"`
+	Key            []byte       `json:"tls_key_path" mapstructure:"-"`
+	ServerName     string       `json:"server_name" mapstructure:"-"`
+	AccessControl  string       `json:"acl_policy_path" mapstructure:"acl_policy_path"`
+	Policy         PolicyConfig `json:"policy" mapstructure:"policy"`
+	DNS            DNSConfig    `json:"dns_config" mapstructure:"dns_config"`
+	OIDC           OIDC         `json:"oidc" mapstructure:"oidc"`
+	ServerURL      string       `json:"server_url" mapstructure:"server_url"`
 
 	//CertPath string `mapstructure:"tls_cert_path"`
 	//KeyPath  string `mapstraucture:"tls_key_path"`
 }
 
+// PolicyConfig is the policy section of headscale 0.23.0, which replaces acl_policy_path
+type PolicyConfig struct {
+	Mode string `json:"mode" mapstructure:"mode"` // file or database
+	Path string `json:"path" mapstructure:"path"`
+}
+
+// PolicyPath returns the path of the ACL policy file in the layouts before and from headscale 0.23.0
+func (h *HeadscaleConfig) PolicyPath() string {
+	if h.Policy.Path != "" {
+		return h.Policy.Path
+	}
+	return h.AccessControl
+}
+
 type DNSConfig struct {
 	BaseDomain string `gorm:"type:varchar(50)" json:"base_domain" mapstructure:"base_domain"`
 }
@@ -40,4 +56,5 @@ type OIDC struct {
 	ClientID                   string   `json:"client_id" mapstructure:"client_id"`
 	ClientSecret               string   `json:"client_secret" mapstructure:"client_secret"`
 	Scope                      []string `json:"scope" mapstructure:"scope"`
+	RedirectURIs               []string `json:"redirect_uris" mapstructure:"-"`
 }
@@ -0,0 +1,133 @@
+package model
+
+// The sections of the headscale config.yaml which the panel edits. The sections without a suffix are in the layout
+// of headscale before 0.23.0 such as v0.23.0-alpha2, the V23 sections replace them from headscale 0.23.0.
+// The keys which are not modeled are kept as they are in the file.
+
+// HeadscaleServerSection is the address of headscale and the addresses it listens on
+type HeadscaleServerSection struct {
+	ServerURL         string `yaml:"server_url" json:"server_url" validate:"required,http_url"`
+	ListenAddr        string `yaml:"listen_addr" json:"listen_addr" validate:"required,listenAddr"`
+	MetricsListenAddr string `yaml:"metrics_listen_addr" json:"metrics_listen_addr" validate:"omitempty,listenAddr"`
+	GRPCListenAddr    string `yaml:"grpc_listen_addr" json:"grpc_listen_addr" validate:"omitempty,listenAddr"`
+	GRPCAllowInsecure bool   `yaml:"grpc_allow_insecure" json:"grpc_allow_insecure"`
+}
+
+// HeadscalePrefixesSection is the prefixes the IPs of the nodes are allocated from
+type HeadscalePrefixesSection struct {
+	IPPrefixes []string `yaml:"ip_prefixes" json:"ip_prefixes" validate:"required,min=1,dive,cidr"`
+}
+
+type HeadscaleDERPSection struct {
+	Server            HeadscaleDERPServer `yaml:"server" json:"server"`
+	URLs              []string            `yaml:"urls" json:"urls" validate:"dive,url"`
+	Paths             []string            `yaml:"paths" json:"paths" validate:"dive,required"`
+	AutoUpdateEnabled bool                `yaml:"auto_update_enabled" json:"auto_update_enabled"`
+	UpdateFrequency   string              `yaml:"update_frequency" json:"update_frequency" validate:"omitempty,headscaleDuration"`
+}
+
+// HeadscaleDERPServer is the embedded DERP server
+type HeadscaleDERPServer struct {
+	Enabled        bool   `yaml:"enabled" json:"enabled"`
+	RegionID       int    `yaml:"region_id" json:"region_id" validate:"required_if=Enabled true,omitempty,min=1"`
+	RegionCode     string `yaml:"region_code" json:"region_code" validate:"required_if=Enabled true"`
+	RegionName     string `yaml:"region_name" json:"region_name" validate:"required_if=Enabled true"`
+	STUNListenAddr string `yaml:"stun_listen_addr" json:"stun_listen_addr" validate:"omitempty,listenAddr"`
+	PrivateKeyPath string `yaml:"private_key_path" json:"private_key_path" validate:"required_if=Enabled true"`
+}
+
+type HeadscaleDNSSection struct {
+	OverrideLocalDNS bool     `yaml:"override_local_dns" json:"override_local_dns"`
+	Nameservers      []string `yaml:"nameservers" json:"nameservers" validate:"dive,ip|url"`
+	Domains          []string `yaml:"domains" json:"domains" validate:"dive,fqdn"`
+	MagicDNS         bool     `yaml:"magic_dns" json:"magic_dns"`
+	BaseDomain       string   `yaml:"base_domain" json:"base_domain" validate:"required_if=MagicDNS true,omitempty,fqdn"`
+}
+
+type HeadscaleOIDCSection struct {
+	OnlyStartIfOIDCIsAvailable bool              `yaml:"only_start_if_oidc_is_available" json:"only_start_if_oidc_is_available"`
+	Issuer                     string            `yaml:"issuer" json:"issuer" validate:"omitempty,http_url"`
+	ClientID                   string            `yaml:"client_id" json:"client_id" validate:"required_with=Issuer"`
+	ClientSecret               string            `yaml:"client_secret" json:"client_secret" validate:"excluded_with=ClientSecretPath"`
+	ClientSecretPath           string            `yaml:"client_secret_path" json:"client_secret_path"`
+	Expiry                     string            `yaml:"expiry" json:"expiry" validate:"omitempty,headscaleDuration"`
+	UseExpiryFromToken         bool              `yaml:"use_expiry_from_token" json:"use_expiry_from_token"`
+	Scope                      []string          `yaml:"scope" json:"scope" validate:"dive,required"`
+	ExtraParams                map[string]string `yaml:"extra_params" json:"extra_params"`
+	AllowedDomains             []string          `yaml:"allowed_domains" json:"allowed_domains" validate:"dive,fqdn"`
+	AllowedGroups              []string          `yaml:"allowed_groups" json:"allowed_groups" validate:"dive,required"`
+	AllowedUsers               []string          `yaml:"allowed_users" json:"allowed_users" validate:"dive,required"`
+	StripEmailDomain           bool              `yaml:"strip_email_domain" json:"strip_email_domain"`
+}
+
+// HeadscaleDatabaseSection is the database of headscale, sqlite3 needs the path and postgres the host
+type HeadscaleDatabaseSection struct {
+	DBType string `yaml:"db_type" json:"db_type" validate:"required,oneof=sqlite3 postgres"`
+	DBPath string `yaml:"db_path" json:"db_path" validate:"required_if=DBType sqlite3"`
+	DBHost string `yaml:"db_host" json:"db_host" validate:"required_if=DBType postgres"`
+	DBPort int    `yaml:"db_port" json:"db_port" validate:"omitempty,min=1,max=65535"`
+	DBName string `yaml:"db_name" json:"db_name" validate:"required_if=DBType postgres"`
+	DBUser string `yaml:"db_user" json:"db_user" validate:"required_if=DBType postgres"`
+	DBPass string `yaml:"db_pass" json:"db_pass"`
+	DBSSL  string `yaml:"db_ssl" json:"db_ssl"` // true, false or a sslmode of postgres
+}
+
+type HeadscaleLogSection struct {
+	Format string `yaml:"format" json:"format" validate:"omitempty,oneof=text json"`
+	Level  string `yaml:"level" json:"level" validate:"omitempty,oneof=trace debug info warn error fatal panic disabled"`
+}
+
+// HeadscalePolicySection is the ACL policy file, .yaml or .yml is YAML, HuJSON otherwise
+type HeadscalePolicySection struct {
+	ACLPolicyPath string `yaml:"acl_policy_path" json:"acl_policy_path"`
+}
+
+// HeadscalePrefixesSectionV23 is the prefixes section of headscale 0.23.0
+type HeadscalePrefixesSectionV23 struct {
+	V4         string `yaml:"v4" json:"v4" validate:"required_without=V6,omitempty,cidrv4"`
+	V6         string `yaml:"v6" json:"v6" validate:"omitempty,cidrv6"`
+	Allocation string `yaml:"allocation" json:"allocation" validate:"omitempty,oneof=sequential random"`
+}
+
+// HeadscaleDNSSectionV23 is the dns section of headscale 0.23.0, which replaces dns_config
+type HeadscaleDNSSectionV23 struct {
+	MagicDNS         bool                    `yaml:"magic_dns" json:"magic_dns"`
+	BaseDomain       string                  `yaml:"base_domain" json:"base_domain" validate:"required_if=MagicDNS true,omitempty,fqdn"`
+	OverrideLocalDNS bool                    `yaml:"override_local_dns" json:"override_local_dns"`
+	Nameservers      HeadscaleDNSNameservers `yaml:"nameservers" json:"nameservers"`
+	SearchDomains    []string                `yaml:"search_domains" json:"search_domains" validate:"dive,fqdn"`
+}
+
+// HeadscaleDNSNameservers are the global nameservers, and the nameservers of the split domains
+type HeadscaleDNSNameservers struct {
+	Global []string            `yaml:"global" json:"global" validate:"dive,ip|url"`
+	Split  map[string][]string `yaml:"split" json:"split" validate:"dive,keys,fqdn,endkeys,dive,ip"`
+}
+
+// HeadscaleDatabaseSectionV23 is the database section of headscale 0.23.0, which replaces the db_ keys
+type HeadscaleDatabaseSectionV23 struct {
+	Type     string                    `yaml:"type" json:"type" validate:"required,oneof=sqlite sqlite3 postgres"`
+	Sqlite   HeadscaleDatabaseSqlite   `yaml:"sqlite" json:"sqlite"`
+	Postgres HeadscaleDatabasePostgres `yaml:"postgres" json:"postgres"`
+}
+
+type HeadscaleDatabaseSqlite struct {
+	Path          string `yaml:"path" json:"path"`
+	WriteAheadLog bool   `yaml:"write_ahead_log" json:"write_ahead_log"`
+}
+
+type HeadscaleDatabasePostgres struct {
+	Host string `yaml:"host" json:"host"`
+	Port int    `yaml:"port" json:"port" validate:"omitempty,min=1,max=65535"`
+	Name string `yaml:"name" json:"name"`
+	User string `yaml:"user" json:"user"`
+	Pass string `yaml:"pass" json:"pass"`
+	SSL  string `yaml:"ssl" json:"ssl"` // true, false or a sslmode of postgres
+}
+
+// HeadscalePolicySectionV23 is the policy section of headscale 0.23.0. The file mode loads the policy from the path,
+// .yaml or .yml is YAML, HuJSON otherwise. The database mode keeps it in the database of headscale.
+type HeadscalePolicySectionV23 struct {
+	Mode string `yaml:"mode" json:"mode" validate:"omitempty,oneof=file database"`
+	Path string `yaml:"path" json:"path"`
+}
@@ -0,0 +1,11 @@
+package model
+
+import "gorm.io/gorm"
+
+// HeadscaleConfigVersion is a content of the headscale config file, saved by the panel or found on disk
+type HeadscaleConfigVersion struct {
+	gorm.Model
+	Content string `gorm:"type:text;comment:Content of the config file" json:"content,omitempty"`
+	Comment string `gorm:"type:varchar(255);comment:Comment of the change" json:"comment"`
+	Creator string `gorm:"type:varchar(20);comment:Created by" json:"creator"`
+}
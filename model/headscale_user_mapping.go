@@ -0,0 +1,17 @@
+package model
+
+import "gorm.io/gorm"
+
+const (
+	MappingPermissionView   = "view"   // List the nodes, routes and pre-auth keys
+	MappingPermissionManage = "manage" // Also register, change and delete them
+)
+
+// HeadscaleUserMapping grants a panel user access to the nodes of a headscale user
+type HeadscaleUserMapping struct {
+	gorm.Model
+	UserID        uint   `gorm:"uniqueIndex:idx_user_headscale_user;comment:Panel user ID" json:"userId"`
+	HeadscaleUser string `gorm:"type:varchar(63);uniqueIndex:idx_user_headscale_user;comment:Headscale user name" json:"headscaleUser"`
+	Permission    string `gorm:"type:varchar(10);default:view;comment:view or manage" json:"permission"`
+	Creator       string `gorm:"type:varchar(20);comment:Created by" json:"creator"`
+}
@@ -0,0 +1,67 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"strings"
+)
+
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+)
+
+// OAuthClient is an application registered to use the built-in OpenID Connect provider
+type OAuthClient struct {
+	gorm.Model
+	ClientID     string   `gorm:"type:varchar(64);not null;unique;comment:OAuth client ID" json:"clientId"`
+	Name         string   `gorm:"type:varchar(100);comment:Client name" json:"name"`
+	SecretHash   string   `gorm:"type:varchar(64);comment:sha256 of the client secret" json:"-"`
+	SecretPrefix string   `gorm:"type:varchar(12);comment:First characters of the secret" json:"secretPrefix"`
+	RedirectURIs []string `gorm:"serializer:json;type:text;comment:Allowed redirect URIs" json:"redirectUris"`
+	Scopes       []string `gorm:"serializer:json;type:text;comment:Allowed scopes" json:"scopes"`
+	GrantTypes   []string `gorm:"serializer:json;type:text;comment:Allowed grant types" json:"grantTypes"`
+	Status       uint     `gorm:"type:smallint;default:1;comment:1 normal, 2 disabled" json:"status"`
+	Dynamic      bool     `gorm:"comment:Registered by the dynamic client registration" json:"dynamic"`
+	FirstParty   bool     `gorm:"comment:Trusted application which skips the consent" json:"firstParty"`
+	Creator      string   `gorm:"type:varchar(20);comment:Created by" json:"creator"`
+	BuiltIn      bool     `gorm:"-" json:"builtIn"` // The headscale client in the config file
+}
+
+// AllowsRedirectURI returns whether the redirect URI is registered, it must match exactly
+func (c *OAuthClient) AllowsRedirectURI(uri string) bool {
+	for _, allowed := range c.RedirectURIs {
+		if allowed == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsConsent returns whether the user must approve the access of the client
+func (c *OAuthClient) NeedsConsent() bool {
+	return !c.BuiltIn && !c.FirstParty
+}
+
+// AllowsGrantType returns whether the client can use the grant type
+func (c *OAuthClient) AllowsGrantType(grantType string) bool {
+	for _, allowed := range c.GrantTypes {
+		if allowed == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterScope returns the requested scopes the client is allowed to request
+func (c *OAuthClient) FilterScope(scope string) string {
+	var granted []string
+	for _, s := range strings.Fields(scope) {
+		for _, allowed := range c.Scopes {
+			if s == allowed {
+				granted = append(granted, s)
+				break
+			}
+		}
+	}
+	return strings.Join(granted, " ")
+}
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"strings"
+)
+
+// OAuthConsent is the scopes a user approved for an OIDC client, the user is not asked again for them
+type OAuthConsent struct {
+	gorm.Model
+	UserID   uint     `gorm:"not null;uniqueIndex:idx_oauth_consent;comment:User ID" json:"userId"`
+	ClientID string   `gorm:"type:varchar(64);not null;uniqueIndex:idx_oauth_consent;comment:OAuth client ID" json:"clientId"`
+	Scopes   []string `gorm:"serializer:json;type:text;comment:Approved scopes" json:"scopes"`
+}
+
+// Covers returns whether every requested scope was approved, an empty request is never approved
+func (c *OAuthConsent) Covers(scope string) bool {
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		return false
+	}
+	approved := make(map[string]bool, len(c.Scopes))
+	for _, s := range c.Scopes {
+		approved[s] = true
+	}
+	for _, s := range scopes {
+		if !approved[s] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	OAuthAccessToken  = "access"
+	OAuthRefreshToken = "refresh"
+)
+
+// OAuthToken is an access or refresh token issued by the built-in OpenID Connect provider
+type OAuthToken struct {
+	gorm.Model
+	Type      string     `gorm:"type:varchar(10);not null;comment:access or refresh" json:"type"`
+	Hash      string     `gorm:"type:varchar(64);not null;unique;comment:sha256 of the token" json:"-"`
+	Family    string     `gorm:"type:varchar(36);index;comment:Tokens rotated from the same authorization" json:"family"`
+	UserID    uint       `gorm:"index;comment:User ID" json:"userId"`
+	ClientID  string     `gorm:"type:varchar(255);comment:OAuth client ID" json:"clientId"`
+	Scope     string     `gorm:"type:varchar(255);comment:Granted scopes" json:"scope"`
+	ExpiresAt time.Time  `gorm:"index;comment:Expiration time" json:"expiresAt"`
+	UsedAt    *time.Time `gorm:"comment:Time the refresh token was rotated" json:"usedAt"`
+	RevokedAt *time.Time `gorm:"comment:Revocation time" json:"revokedAt"`
+}
@@ -0,0 +1,17 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// OIDCSigningKey is a key the OpenID Connect provider signs the ID tokens with.
+// A key is published in the JWKS before it signs tokens, and after it is replaced until the tokens it signed expire.
+type OIDCSigningKey struct {
+	gorm.Model
+	Kid        string     `gorm:"type:varchar(64);not null;unique;comment:Key ID" json:"kid"`
+	Algorithm  string     `gorm:"type:varchar(10);not null;comment:RS256 or ES256" json:"algorithm"`
+	PrivateKey string     `gorm:"type:text;not null;comment:PKCS8 PEM private key" json:"-"`
+	ActiveAt   time.Time  `gorm:"index;comment:Signing tokens from" json:"activeAt"`
+	RetiredAt  *time.Time `gorm:"comment:Replaced by a newer key at" json:"retiredAt"`
+}
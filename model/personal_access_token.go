@@ -0,0 +1,24 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// PersonalAccessToken is a long-lived bearer token of a user for automation against the panel API
+type PersonalAccessToken struct {
+	gorm.Model
+	UserID     uint         `gorm:"index;comment:User ID" json:"userId"`
+	Name       string       `gorm:"type:varchar(50);not null;comment:Token name" json:"name"`
+	Hash       string       `gorm:"type:varchar(64);not null;unique;comment:sha256 of the token" json:"-"`
+	Prefix     string       `gorm:"type:varchar(12);comment:First characters of the token" json:"prefix"`
+	Scopes     []TokenScope `gorm:"serializer:json;type:text;comment:Allowed APIs, a subset of the user's permissions" json:"scopes"`
+	ExpiresAt  *time.Time   `gorm:"comment:Expiration time, null never expires" json:"expiresAt"`
+	LastUsedAt *time.Time   `gorm:"comment:Last used time" json:"lastUsedAt"`
+}
+
+// TokenScope is an API the token can access, the same as a Casbin policy of the user's roles
+type TokenScope struct {
+	Path   string `json:"path"`
+	Method string `json:"method"`
+}
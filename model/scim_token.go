@@ -0,0 +1,17 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// ScimToken is the bearer token used by an identity provider to call the SCIM endpoints
+type ScimToken struct {
+	gorm.Model
+	Name       string     `gorm:"type:varchar(50);not null;comment:Token name" json:"name"`
+	Hash       string     `gorm:"type:varchar(64);not null;unique;comment:sha256 of the token" json:"-"`
+	Prefix     string     `gorm:"type:varchar(12);comment:First characters of the token" json:"prefix"`
+	ExpiresAt  *time.Time `gorm:"comment:Expiration time, null never expires" json:"expiresAt"`
+	LastUsedAt *time.Time `gorm:"comment:Last used time" json:"lastUsedAt"`
+	Creator    string     `gorm:"type:varchar(20);comment:Created by" json:"creator"`
+}
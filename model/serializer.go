@@ -0,0 +1,37 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+func init() {
+	schema.RegisterSerializer("nullstring", NullStringSerializer{})
+}
+
+// NullStringSerializer stores an empty string as NULL, so the empty values do not conflict in a unique column
+type NullStringSerializer struct{}
+
+func (NullStringSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var s string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("unsupported value of %s: %#v", field.Name, dbValue)
+	}
+	field.ReflectValueOf(ctx, dst).SetString(s)
+	return nil
+}
+
+func (NullStringSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	if s, _ := fieldValue.(string); s != "" {
+		return s, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,21 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// Session is a login of a user, the token ID is saved in the jti claim of the JWT
+type Session struct {
+	gorm.Model
+	TokenID    string     `gorm:"type:varchar(36);not null;unique;comment:jti of the JWT" json:"-"`
+	UserID     uint       `gorm:"index;comment:User ID" json:"userId"`
+	Username   string     `gorm:"type:varchar(63);comment:User Login Name" json:"username"`
+	Ip         string     `gorm:"type:varchar(64);comment:IP address at login" json:"ip"`
+	UserAgent  string     `gorm:"type:varchar(255);comment:Browser identification" json:"userAgent"`
+	IssuedAt   time.Time  `gorm:"comment:Login time" json:"issuedAt"`
+	LastSeenAt time.Time  `gorm:"comment:Last request time" json:"lastSeenAt"`
+	ExpiresAt  time.Time  `gorm:"index;comment:The time the token can not be refreshed anymore" json:"expiresAt"`
+	RevokedAt  *time.Time `gorm:"comment:Revocation time" json:"revokedAt"`
+	Current    bool       `gorm:"-" json:"current"`
+}
@@ -0,0 +1,15 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// UserInvite lets an imported user without an initial password set the password once
+type UserInvite struct {
+	gorm.Model
+	UserID    uint      `gorm:"index;comment:User ID" json:"userId"`
+	Hash      string    `gorm:"type:varchar(64);not null;unique;comment:sha256 of the invite token" json:"-"`
+	ExpiresAt time.Time `gorm:"comment:Expiration time" json:"expiresAt"`
+	Creator   string    `gorm:"type:varchar(20);comment:Created by" json:"creator"`
+}
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"testing"
+)
+
+func TestUserWithoutEmail(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AutoMigrate(&User{}); err != nil {
+		t.Fatal(err)
+	}
+	// The users without an email do not conflict in the unique column
+	users := []*User{{Name: "alice"}, {Name: "bob"}, {Name: "carol", Email: "carol@example.org"}}
+	for _, user := range users {
+		if err = db.Create(user).Error; err != nil {
+			t.Fatalf("create %s: %v", user.Name, err)
+		}
+	}
+	users[1].Nickname = "Bob"
+	if err = db.Save(users[1]).Error; err != nil {
+		t.Fatalf("save bob: %v", err)
+	}
+	if err = db.Create(&User{Name: "dave", Email: "carol@example.org"}).Error; err == nil {
+		t.Fatal("create a user with the email of another user, want an error")
+	}
+
+	users[2].Email = ""
+	if err = db.Model(users[2]).Select("email").Updates(users[2]).Error; err != nil {
+		t.Fatalf("remove the email of carol: %v", err)
+	}
+	users[2].Email = "carol@example.org"
+	if err = db.Model(users[2]).Select("email").Updates(users[2]).Error; err != nil {
+		t.Fatalf("set the email of carol: %v", err)
+	}
+
+	var nulls int64
+	if err = db.Model(&User{}).Where("email IS NULL").Count(&nulls).Error; err != nil || nulls != 2 {
+		t.Fatalf("users without an email got %d, %v, want 2", nulls, err)
+	}
+	var got []User
+	if err = db.Order("id").Find(&got).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got[0].Email != "" || got[2].Email != "carol@example.org" {
+		t.Errorf("emails got %q and %q", got[0].Email, got[2].Email)
+	}
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"headscale-panel/common"
+	"headscale-panel/log"
+	"headscale-panel/model"
+	"headscale-panel/util"
+	"headscale-panel/vo"
+	"strings"
+	"time"
+)
+
+// AccessTokenPrefix is the prefix of the personal access tokens, it distinguishes them from the JWTs
+const AccessTokenPrefix = "hpat_"
+
+type IAccessTokenRepository interface {
+	GetUserScopes(user *model.User) []model.TokenScope                                                          // Get the APIs the user can access
+	CreateToken(user *model.User, req *vo.CreateAccessTokenRequest) (string, *model.PersonalAccessToken, error) // Create a token, the token is only returned once
+	GetUserTokens(userID uint) ([]*model.PersonalAccessToken, error)                                            // Get the tokens of the user
+	RevokeTokens(userID uint, ids []uint) error                                                                 // Revoke the tokens of the user
+	RevokeUserTokens(userID uint) error                                                                         // Revoke all tokens of the user
+	VerifyToken(token string) (*model.User, *model.PersonalAccessToken, error)                                  // Verify a token and return its user
+}
+
+type AccessTokenRepository struct{}
+
+func NewAccessTokenRepository() IAccessTokenRepository {
+	return AccessTokenRepository{}
+}
+
+func (a AccessTokenRepository) GetUserScopes(user *model.User) []model.TokenScope {
+	scopes := make([]model.TokenScope, 0)
+	seen := make(map[model.TokenScope]bool)
+	for _, role := range user.Roles {
+		if role.Status != 1 {
+			continue
+		}
+		for _, policy := range common.CasbinEnforcer.GetFilteredPolicy(0, role.Keyword) {
+			scope := model.TokenScope{Path: policy[1], Method: policy[2]}
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return scopes
+}
+
+func (a AccessTokenRepository) CreateToken(user *model.User, req *vo.CreateAccessTokenRequest) (string, *model.PersonalAccessToken, error) {
+	allowed := make(map[model.TokenScope]bool)
+	for _, scope := range a.GetUserScopes(user) {
+		allowed[scope] = true
+	}
+	scopes := make([]model.TokenScope, 0, len(req.Scopes))
+	for _, s := range req.Scopes {
+		scope := model.TokenScope{Path: s.Path, Method: strings.ToUpper(s.Method)}
+		if !allowed[scope] {
+			return "", nil, fmt.Errorf("no permission for %s %s", scope.Method, scope.Path)
+		}
+		scopes = append(scopes, scope)
+	}
+
+	token, err := util.GenToken(AccessTokenPrefix)
+	if err != nil {
+		return "", nil, err
+	}
+	t := &model.PersonalAccessToken{
+		UserID: user.ID,
+		Name:   req.Name,
+		Hash:   util.HashToken(token),
+		Prefix: token[:12],
+		Scopes: scopes,
+	}
+	if req.ExpireDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, int(req.ExpireDays))
+		t.ExpiresAt = &expiresAt
+	}
+	if err = common.DB.Create(t).Error; err != nil {
+		return "", nil, err
+	}
+	return token, t, nil
+}
+
+func (a AccessTokenRepository) GetUserTokens(userID uint) ([]*model.PersonalAccessToken, error) {
+	var tokens []*model.PersonalAccessToken
+	err := common.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+func (a AccessTokenRepository) RevokeTokens(userID uint, ids []uint) error {
+	return common.DB.Where("user_id = ? AND id IN (?)", userID, ids).Delete(&model.PersonalAccessToken{}).Error
+}
+
+func (a AccessTokenRepository) RevokeUserTokens(userID uint) error {
+	return common.DB.Where("user_id = ?", userID).Delete(&model.PersonalAccessToken{}).Error
+}
+
+func (a AccessTokenRepository) VerifyToken(token string) (*model.User, *model.PersonalAccessToken, error) {
+	var t model.PersonalAccessToken
+	if err := common.DB.Where("hash = ?", util.HashToken(token)).First(&t).Error; err != nil {
+		return nil, nil, errors.New("invalid token")
+	}
+	now := time.Now()
+	if t.ExpiresAt != nil && t.ExpiresAt.Before(now) {
+		return nil, nil, errors.New("token expired")
+	}
+
+	user, err := NewUserRepository().GetUserById(t.UserID)
+	if err != nil {
+		return nil, nil, errors.New("user does not exist")
+	}
+	if err = checkUserStatus(&user); err != nil {
+		return nil, nil, err
+	}
+
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) > lastSeenInterval {
+		if err = common.DB.Model(&t).UpdateColumn("last_used_at", now).Error; err != nil {
+			log.Log.Errorf("update access token last used time error: %v", err)
+		}
+	}
+	return &user, &t, nil
+}
@@ -0,0 +1,285 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tailscale/hujson"
+	"github.com/thoas/go-funk"
+	"gopkg.in/yaml.v3"
+	"headscale-panel/model"
+	"headscale-panel/util"
+	"headscale-panel/vo"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrACLGroupNotFound  = errors.New("acl group not found")
+	ErrACLMemberExists   = errors.New("the user is already a member of the group")
+	ErrACLMemberNotFound = errors.New("the user is not a member of the group")
+	ErrACLHostNotFound   = errors.New("acl host not found")
+	ErrACLTagNotFound    = errors.New("acl tag not found")
+	ErrACLRuleNotFound   = errors.New("acl rule not found")
+)
+
+// ACLPolicyRepository edits the ACL policy file section by section. The file is patched, so the comments and the order
+// of the entries which are not edited are kept.
+type ACLPolicyRepository interface {
+	GetPolicy() (*model.ACLPolicy, error)
+	SetGroup(creator, name string, members []string) (uint, error)
+	DeleteGroup(creator, name string) (uint, error)
+	AddGroupMember(creator, name, member string) (uint, error)
+	RemoveGroupMember(creator, name, member string) (uint, error)
+	SetHost(creator, name, address string) (uint, error)
+	DeleteHost(creator, name string) (uint, error)
+	SetTagOwners(creator, tag string, owners []string) (uint, error)
+	DeleteTagOwners(creator, tag string) (uint, error)
+	AddACLRule(creator string, rule *model.ACLRule) (uint, error)
+	UpdateACLRule(creator string, index int, rule *model.ACLRule) (uint, error)
+	DeleteACLRule(creator string, index int) (uint, error)
+	AddSSHRule(creator string, rule *model.SSHRule) (uint, error)
+	UpdateSSHRule(creator string, index int, rule *model.SSHRule) (uint, error)
+	DeleteSSHRule(creator string, index int) (uint, error)
+	SetAutoApprovers(creator string, approvers *model.AutoApprovers) (uint, error)
+	Simulate(req *vo.ACLSimulateRequest) (*ACLSimulation, error) // Can the source reach the destination
+	Reachability(node string) ([]*ACLReach, error)               // What the node can reach on the other nodes
+}
+
+type aclPolicyRepository struct {
+	acl AccessControlRepository
+}
+
+// NewACLPolicyRepository new a repository of the ACL policy on top of the ACL file
+func NewACLPolicyRepository() ACLPolicyRepository {
+	return &aclPolicyRepository{acl: NewAccessControlRepository()}
+}
+
+func (s aclPolicyRepository) GetPolicy() (*model.ACLPolicy, error) {
+	content, err := s.acl.GetAccessControl()
+	if err != nil {
+		return nil, err
+	}
+	return ParseACLPolicy(s.acl.PolicyPath(), []byte(content))
+}
+
+func (s aclPolicyRepository) SetGroup(creator, name string, members []string) (uint, error) {
+	return s.patch(creator, "Set group "+name, func(policy *model.ACLPolicy) ([]util.PatchOperation, error) {
+		return setMember(policy.Groups == nil, "groups", name, nonNil(members)), nil
+	})
+}
+
+func (s aclPolicyRepository) DeleteGroup(creator, name string) (uint, error) {
+	return s.patch(creator, "Delete group "+name, func(policy *model.ACLPolicy) ([]util.PatchOperation, error) {
+		if _, ok := policy.Groups[name]; !ok {
+			return nil, ErrACLGroupNotFound
+		}
+		return []util.PatchOperation{{Op: "remove", Path: util.JSONPointer("groups", name)}}, nil
+	})
+}
+
+func (s aclPolicyRepository) AddGroupMember(creator, name, member string) (uint, error) {
+	return s.patch(creator, "Add "+member+" to group "+name, func(policy *model.ACLPolicy) ([]util.PatchOperation, error) {
+		members, ok := policy.Groups[name]
+		if !ok {
+			// A new group with the member
+			return setMember(policy.Groups == nil, "groups", name, []string{member}), nil
+		}
+		if funk.ContainsString(members, member) {
+			return nil, ErrACLMemberExists
+		}
+		return []util.PatchOperation{{Op: "add", Path: util.JSONPointer("groups", name, "-"), Value: member}}, nil
+	})
+}
+
+func (s aclPolicyRepository) RemoveGroupMember(creator, name, member string) (uint, error) {
+	return s.patch(creator, "Remove "+member+" from group "+name, func(policy *model.ACLPolicy) ([]util.PatchOperation, error) {
+		members, ok := policy.Groups[name]
+		if !ok {
+			return nil, ErrACLGroupNotFound
+		}
+		idx := funk.IndexOfString(members, member)
+		if idx < 0 {
+			return nil, ErrACLMemberNotFound
+		}
+		return []util.PatchOperation{{Op: "remove", Path: util.JSONPointer("groups", name, strconv.Itoa(idx))}}, nil
+	})
+}
+
+func (s aclPolicyRepository) SetHost(creator, name, address string) (uint, error) {
+	return s.patch(creator, "Set host "+name+" to "+address, func(policy *model.ACLPolicy) ([]util.PatchOperation, error) {
+		return setMember(policy.Hosts == nil, "hosts", name, address), nil
+	})
+}
+
+func (s aclPolicyRepository) DeleteHost(creator, name string) (uint, error) {
+	return s.patch(creator, "Delete host "+name, func(policy *model.ACLPolicy) ([]util.PatchOperation, error) {
+		if _, ok := policy.Hosts[name]; !ok {
+			return nil, ErrACLHostNotFound
+		}
+		return []util.PatchOperation{{Op: "remove", Path: util.JSONPointer("hosts", name)}}, nil
+	})
+}
+
+func (s aclPolicyRepository) SetTagOwners(creator, tag string, owners []string) (uint, error) {
+	return s.patch(creator, "Set owners of "+tag, func(policy *model.ACLPolicy) ([]util.PatchOperation, error) {
+		return setMember(policy.TagOwners == nil, "tagOwners", tag, nonNil(owners)), nil
+	})
+}
+
+func (s aclPolicyRepository) DeleteTagOwners(creator, tag string) (uint, error) {
+	return s.patch(creator, "Delete owners of "+tag, func(policy *model.ACLPolicy) ([]util.PatchOperation, error) {
+		if _, ok := policy.TagOwners[tag]; !ok {
+			return nil, ErrACLTagNotFound
+		}
+		return []util.PatchOperation{{Op: "remove", Path: util.JSONPointer("tagOwners", tag)}}, nil
+	})
+}
+
+func (s aclPolicyRepository) AddACLRule(creator string, rule *model.ACLRule) (uint, error) {
+	return s.patch(creator, "Add acl rule", func(policy *model.ACLPolicy) ([]util.PatchOperation, error) {
+		return appendRule(policy.ACLs == nil, "acls", rule), nil
+	})
+}
+
+func (s aclPolicyRepository) UpdateACLRule(creator string, index int, rule *model.ACLRule) (uint, error) {
+	return s.patch(creator, fmt.Sprintf("Update acl rule %d", index), func(policy *model.ACLPolicy) ([]util.PatchOperation, error) {
+		return ruleOperation("replace", "acls", index, len(policy.ACLs), rule)
+	})
+}
+
+func (s aclPolicyRepository) DeleteACLRule(creator string, index int) (uint, error) {
+	return s.patch(creator, fmt.Sprintf("Delete acl rule %d", index), func(policy *model.ACLPolicy) ([]util.PatchOperation, error) {
+		return ruleOperation("remove", "acls", index, len(policy.ACLs), nil)
+	})
+}
+
+func (s aclPolicyRepository) AddSSHRule(creator string, rule *model.SSHRule) (uint, error) {
+	return s.patch(creator, "Add ssh rule", func(policy *model.ACLPolicy) ([]util.PatchOperation, error) {
+		return appendRule(policy.SSHs == nil, "ssh", rule), nil
+	})
+}
+
+func (s aclPolicyRepository) UpdateSSHRule(creator string, index int, rule *model.SSHRule) (uint, error) {
+	return s.patch(creator, fmt.Sprintf("Update ssh rule %d", index), func(policy *model.ACLPolicy) ([]util.PatchOperation, error) {
+		return ruleOperation("replace", "ssh", index, len(policy.SSHs), rule)
+	})
+}
+
+func (s aclPolicyRepository) DeleteSSHRule(creator string, index int) (uint, error) {
+	return s.patch(creator, fmt.Sprintf("Delete ssh rule %d", index), func(policy *model.ACLPolicy) ([]util.PatchOperation, error) {
+		return ruleOperation("remove", "ssh", index, len(policy.SSHs), nil)
+	})
+}
+
+func (s aclPolicyRepository) SetAutoApprovers(creator string, approvers *model.AutoApprovers) (uint, error) {
+	if approvers.Routes == nil {
+		approvers.Routes = map[string][]string{}
+	}
+	approvers.ExitNode = nonNil(approvers.ExitNode)
+	return s.patch(creator, "Set auto approvers", func(policy *model.ACLPolicy) ([]util.PatchOperation, error) {
+		// add replaces an existing section
+		return []util.PatchOperation{{Op: "add", Path: util.JSONPointer("autoApprovers"), Value: approvers}}, nil
+	})
+}
+
+// aclPatchAttempts is how many times an edit is built again when the policy changed while it was built
+const aclPatchAttempts = 3
+
+// patch applies the operations built from the current policy to the ACL file, the change is recorded with the comment,
+// headscale loads it and its version is returned. If the policy changed meanwhile, the operations are built again from the new policy.
+func (s aclPolicyRepository) patch(creator, comment string, build func(policy *model.ACLPolicy) ([]util.PatchOperation, error)) (uint, error) {
+	var version uint
+	var err error
+	for i := 0; i < aclPatchAttempts; i++ {
+		if version, err = s.tryPatch(creator, comment, build); !errors.Is(err, ErrACLChanged) {
+			return version, err
+		}
+	}
+	return 0, err
+}
+
+func (s aclPolicyRepository) tryPatch(creator, comment string, build func(policy *model.ACLPolicy) ([]util.PatchOperation, error)) (uint, error) {
+	content, err := s.acl.GetAccessControl()
+	if err != nil {
+		return 0, err
+	}
+	policy, err := ParseACLPolicy(s.acl.PolicyPath(), []byte(content))
+	if err != nil {
+		return 0, err
+	}
+	ops, err := build(policy)
+	if err != nil {
+		return 0, err
+	}
+
+	var patched []byte
+	if isYAMLPolicy(s.acl.PolicyPath()) {
+		patched, err = util.PatchYAML([]byte(content), ops)
+	} else {
+		patched, err = util.PatchHuJSON([]byte(content), ops)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("patch acl policy error: %w", err)
+	}
+	// The patched policy is validated before it is saved
+	return s.acl.ReplaceAccessControl(content, string(patched), creator, comment)
+}
+
+// ParseACLPolicy parses the policy, the format is chosen by the extension of the file like headscale does
+func ParseACLPolicy(file string, content []byte) (*model.ACLPolicy, error) {
+	policy := &model.ACLPolicy{}
+	if len(strings.TrimSpace(string(content))) == 0 {
+		return policy, nil
+	}
+	if isYAMLPolicy(file) {
+		if err := yaml.Unmarshal(content, policy); err != nil {
+			return nil, fmt.Errorf("parse acl policy error: %w", err)
+		}
+		return policy, nil
+	}
+	standard, err := hujson.Standardize(content)
+	if err != nil {
+		return nil, fmt.Errorf("parse acl policy error: %w", err)
+	}
+	if err = json.Unmarshal(standard, policy); err != nil {
+		return nil, fmt.Errorf("parse acl policy error: %w", err)
+	}
+	return policy, nil
+}
+
+func isYAMLPolicy(file string) bool {
+	ext := strings.ToLower(filepath.Ext(file))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// setMember sets a member of a section, the section is created if it is missing
+func setMember(missing bool, section, name string, value interface{}) []util.PatchOperation {
+	if missing {
+		return []util.PatchOperation{{Op: "add", Path: util.JSONPointer(section), Value: map[string]interface{}{name: value}}}
+	}
+	return []util.PatchOperation{{Op: "add", Path: util.JSONPointer(section, name), Value: value}}
+}
+
+// appendRule appends a rule to a section, the section is created if it is missing
+func appendRule(missing bool, section string, rule interface{}) []util.PatchOperation {
+	if missing {
+		return []util.PatchOperation{{Op: "add", Path: util.JSONPointer(section), Value: []interface{}{rule}}}
+	}
+	return []util.PatchOperation{{Op: "add", Path: util.JSONPointer(section, "-"), Value: rule}}
+}
+
+func ruleOperation(op, section string, index, length int, rule interface{}) ([]util.PatchOperation, error) {
+	if index < 0 || index >= length {
+		return nil, ErrACLRuleNotFound
+	}
+	return []util.PatchOperation{{Op: op, Path: util.JSONPointer(section, strconv.Itoa(index)), Value: rule}}, nil
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
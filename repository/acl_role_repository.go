@@ -0,0 +1,294 @@
+package repository
+
+import (
+	"errors"
+	"github.com/thoas/go-funk"
+	"gorm.io/gorm"
+	"headscale-panel/common"
+	"headscale-panel/config"
+	"headscale-panel/log"
+	"headscale-panel/model"
+	"headscale-panel/util"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// aclRoleGroupPlaceholder is replaced with the group of the role in the rules of a template
+const aclRoleGroupPlaceholder = "$group"
+
+// errACLUnchanged stops a patch which would not change the policy
+var errACLUnchanged = errors.New("acl policy unchanged")
+
+// ACLRoleRepository generates the ACL groups group:<role keyword> from the members of the roles, with the rules of
+// each role. Only the roles with a template are generated, the groups and rules written by hand are kept.
+type ACLRoleRepository interface {
+	ListTemplates() ([]*model.ACLRoleTemplate, error)
+	SetTemplate(creator string, roleId uint, rules []model.ACLRule) error
+	DeleteTemplate(creator string, roleId uint) error
+	Sync(creator string) error
+}
+
+type aclRoleRepository struct {
+	policy aclPolicyRepository
+}
+
+// NewACLRoleRepository new a repository of the role groups on top of the ACL policy
+func NewACLRoleRepository() ACLRoleRepository {
+	return &aclRoleRepository{policy: aclPolicyRepository{acl: NewAccessControlRepository()}}
+}
+
+var (
+	aclRoleSyncLock  sync.Mutex // One sync at a time
+	aclRoleTimerLock sync.Mutex
+	aclRoleSyncTimer *time.Timer
+	aclRoleSyncDelay = 3 * time.Second
+)
+
+// TriggerACLRoleSync syncs the role groups shortly after the role membership changed, the changes of a burst such as an
+// import are synced once
+func TriggerACLRoleSync() {
+	aclRoleTimerLock.Lock()
+	defer aclRoleTimerLock.Unlock()
+	if aclRoleSyncTimer != nil {
+		aclRoleSyncTimer.Stop()
+	}
+	aclRoleSyncTimer = time.AfterFunc(aclRoleSyncDelay, func() {
+		if err := NewACLRoleRepository().Sync("System"); err != nil {
+			log.Log.Errorf("sync acl role groups error: %v", err)
+		}
+	})
+}
+
+func (s aclRoleRepository) ListTemplates() ([]*model.ACLRoleTemplate, error) {
+	var list []*model.ACLRoleTemplate
+	err := common.DB.Preload("Role").Order("id").Find(&list).Error
+	return list, err
+}
+
+// SetTemplate generates the group of the role with the rules, the policy is synced at once
+func (s aclRoleRepository) SetTemplate(creator string, roleId uint, rules []model.ACLRule) error {
+	role := &model.Role{}
+	if err := common.DB.First(role, roleId).Error; err != nil {
+		return err
+	}
+	// The template deleted before the last sync is reused
+	template := &model.ACLRoleTemplate{}
+	err := common.DB.Unscoped().Where("role_id = ?", roleId).Attrs(model.ACLRoleTemplate{RoleID: roleId}).FirstOrInit(template).Error
+	if err != nil {
+		return err
+	}
+	if rules == nil {
+		rules = []model.ACLRule{}
+	}
+	old := *template
+	template.Rules = rules
+	template.Creator = creator
+	template.DeletedAt = gorm.DeletedAt{}
+	if err = common.DB.Unscoped().Save(template).Error; err != nil {
+		return err
+	}
+	if err = s.Sync(creator); err != nil {
+		// The policy is not changed, neither is the template, so the next syncs do not fail with it
+		if old.ID == 0 {
+			common.DB.Unscoped().Delete(template)
+		} else {
+			common.DB.Unscoped().Save(&old)
+		}
+		return err
+	}
+	return nil
+}
+
+// DeleteTemplate stops generating the group of the role, its group and rules are removed from the policy by the sync
+func (s aclRoleRepository) DeleteTemplate(creator string, roleId uint) error {
+	result := common.DB.Where("role_id = ?", roleId).Delete(&model.ACLRoleTemplate{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("the role has no acl template")
+	}
+	return s.Sync(creator)
+}
+
+// Sync writes the groups and rules of the templates to the policy with the safe apply: the policy is validated, applied
+// and restored if headscale is not healthy with it
+func (s aclRoleRepository) Sync(creator string) error {
+	if !config.Conf.Headscale.ManagesACL() {
+		return nil
+	}
+	aclRoleSyncLock.Lock()
+	defer aclRoleSyncLock.Unlock()
+
+	// The deleted templates are loaded to remove what they wrote
+	var templates, deleted []*model.ACLRoleTemplate
+	if err := common.DB.Preload("Role.Users").Order("id").Find(&templates).Error; err != nil {
+		return err
+	}
+	if err := common.DB.Unscoped().Where("deleted_at IS NOT NULL").Order("id").Find(&deleted).Error; err != nil {
+		return err
+	}
+	templates = append(templates, deleted...)
+	if len(templates) == 0 {
+		return nil
+	}
+
+	users := headscaleUserNames()
+	_, err := s.policy.patch(creator, "Sync role groups", func(policy *model.ACLPolicy) ([]util.PatchOperation, error) {
+		ops := roleGroupOperations(policy, templates, users)
+		if len(ops) == 0 {
+			return nil, errACLUnchanged
+		}
+		return ops, nil
+	})
+	if errors.Is(err, errACLUnchanged) {
+		return s.saveGenerated(templates)
+	}
+	if err != nil {
+		return err
+	}
+	return s.saveGenerated(templates)
+}
+
+// saveGenerated records what the templates wrote, the templates of the deleted roles are deleted
+func (s aclRoleRepository) saveGenerated(templates []*model.ACLRoleTemplate) error {
+	for _, template := range templates {
+		if removedTemplate(template) {
+			if err := common.DB.Unscoped().Delete(template).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		template.GroupName, template.Generated = roleGroup(template), roleRules(template)
+		if err := common.DB.Model(template).Select("group_name", "generated").Updates(template).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// roleGroupOperations builds the operations which write the groups and rules of the templates. The rules written by the
+// last sync are removed and the rules of the templates are appended. Members who are not headscale users are skipped,
+// users is nil if headscale does not answer.
+func roleGroupOperations(policy *model.ACLPolicy, templates []*model.ACLRoleTemplate, users []string) []util.PatchOperation {
+	var ops []util.PatchOperation
+	groupsMissing := policy.Groups == nil
+	for _, template := range templates {
+		group := ""
+		if !removedTemplate(template) {
+			group = roleGroup(template)
+			members := roleMembers(template.Role, users)
+			if current, ok := policy.Groups[group]; !ok || !reflect.DeepEqual(nonNil(current), members) {
+				ops = append(ops, setMember(groupsMissing, "groups", group, members)...)
+				groupsMissing = false
+			}
+		}
+		if _, ok := policy.Groups[template.GroupName]; ok && template.GroupName != "" && template.GroupName != group {
+			ops = append(ops, util.PatchOperation{Op: "remove", Path: util.JSONPointer("groups", template.GroupName)})
+		}
+	}
+
+	// The generated rules are matched by their content, each rule once
+	var previous, desired []model.ACLRule
+	for _, template := range templates {
+		previous = append(previous, template.Generated...)
+		if !removedTemplate(template) {
+			desired = append(desired, roleRules(template)...)
+		}
+	}
+	used := make(map[int]bool)
+	var found []int
+	for _, rule := range previous {
+		for i := range policy.ACLs {
+			if !used[i] && aclRuleEqual(policy.ACLs[i], rule) {
+				used[i] = true
+				found = append(found, i)
+				break
+			}
+		}
+	}
+	if len(found) == len(previous) && aclRulesEqual(previous, desired) {
+		return ops
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(found)))
+	for _, i := range found {
+		ops = append(ops, util.PatchOperation{Op: "remove", Path: util.JSONPointer("acls", strconv.Itoa(i))})
+	}
+	aclsMissing := policy.ACLs == nil
+	for _, rule := range desired {
+		ops = append(ops, appendRule(aclsMissing, "acls", rule)...)
+		aclsMissing = false
+	}
+	return ops
+}
+
+// removedTemplate tells whether the template or its role is deleted
+func removedTemplate(template *model.ACLRoleTemplate) bool {
+	return template.DeletedAt.Valid || template.Role == nil
+}
+
+func roleGroup(template *model.ACLRoleTemplate) string {
+	return "group:" + template.Role.Keyword
+}
+
+// roleMembers returns the sorted names of the active members, the members of a disabled role are removed
+func roleMembers(role *model.Role, users []string) []string {
+	members := make([]string, 0, len(role.Users))
+	if role.Status != 1 {
+		return members
+	}
+	for _, user := range role.Users {
+		if user.Status != 1 {
+			continue
+		}
+		if users != nil && !funk.ContainsString(users, user.Name) {
+			continue
+		}
+		members = append(members, user.Name)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// roleRules returns the rules of the template with the group of the role
+func roleRules(template *model.ACLRoleTemplate) []model.ACLRule {
+	group := roleGroup(template)
+	rules := make([]model.ACLRule, 0, len(template.Rules))
+	for _, rule := range template.Rules {
+		rule.Sources = replaceRoleGroup(rule.Sources, group)
+		rule.Destinations = replaceRoleGroup(rule.Destinations, group)
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func replaceRoleGroup(aliases []string, group string) []string {
+	replaced := make([]string, len(aliases))
+	for i, alias := range aliases {
+		// The destinations have ports, $group:22
+		replaced[i] = strings.Replace(alias, aclRoleGroupPlaceholder, group, 1)
+	}
+	return replaced
+}
+
+func aclRuleEqual(a, b model.ACLRule) bool {
+	return a.Action == b.Action && a.Protocol == b.Protocol &&
+		reflect.DeepEqual(nonNil(a.Sources), nonNil(b.Sources)) &&
+		reflect.DeepEqual(nonNil(a.Destinations), nonNil(b.Destinations))
+}
+
+func aclRulesEqual(a, b []model.ACLRule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !aclRuleEqual(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"headscale-panel/model"
+	"headscale-panel/util"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRoleGroupOperations(t *testing.T) {
+	content := []byte(`{
+	// Written by hand
+	"groups": {"group:admin": ["alice"], "group:old": ["bob"]},
+	"acls": [
+		{"action": "accept", "src": ["group:admin"], "dst": ["*:*"]},
+		{"action": "accept", "src": ["group:old"], "dst": ["tag:server:22"]},
+	],
+}`)
+	policy, err := ParseACLPolicy("acl.hujson", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := []model.ACLRule{{Action: "accept", Sources: []string{"$group"}, Destinations: []string{"tag:server:22"}}}
+	dev := &model.ACLRoleTemplate{
+		Role: &model.Role{Keyword: "dev", Status: 1, Users: []*model.User{
+			{Name: "carol", Status: 1}, {Name: "bob", Status: 1}, {Name: "dave", Status: 2}, {Name: "erin", Status: 1},
+		}},
+		Rules: rules,
+		// The role was renamed from old
+		GroupName: "group:old",
+		Generated: []model.ACLRule{{Action: "accept", Sources: []string{"group:old"}, Destinations: []string{"tag:server:22"}}},
+	}
+	deleted := &model.ACLRoleTemplate{Model: gorm.Model{DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true}}}
+
+	ops := roleGroupOperations(policy, []*model.ACLRoleTemplate{dev, deleted}, []string{"alice", "bob", "carol", "dave"})
+	patched, err := util.PatchHuJSON(content, ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseACLPolicy("acl.hujson", patched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"group:admin": {"alice"}, "group:dev": {"bob", "carol"}}
+	if !reflect.DeepEqual(got.Groups, want) {
+		t.Errorf("groups = %v, want %v", got.Groups, want)
+	}
+	if len(got.ACLs) != 2 || got.ACLs[0].Sources[0] != "group:admin" || got.ACLs[1].Sources[0] != "group:dev" {
+		t.Errorf("acls = %+v", got.ACLs)
+	}
+
+	// Nothing to write once synced
+	dev.GroupName, dev.Generated = roleGroup(dev), roleRules(dev)
+	if ops = roleGroupOperations(got, []*model.ACLRoleTemplate{dev}, []string{"alice", "bob", "carol"}); len(ops) != 0 {
+		t.Errorf("synced policy ops = %+v", ops)
+	}
+}
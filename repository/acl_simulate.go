@@ -0,0 +1,391 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/thoas/go-funk"
+	"headscale-panel/model"
+	task "headscale-panel/tasks"
+	"headscale-panel/vo"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrACLNodeNotFound = errors.New("node not found")
+
+	// The addresses headscale gives to the nodes, the other ones are autogroup:internet
+	tailnetPrefixes = []netip.Prefix{netip.MustParsePrefix("100.64.0.0/10"), netip.MustParsePrefix("fd7a:115c:a1e0::/48")}
+
+	// aclProtocolNumbers are the IP protocol numbers of the protocol names of a rule
+	aclProtocolNumbers = map[string]int{
+		"icmp": 1, "igmp": 2, "ipv4": 4, "ip-in-ip": 4, "tcp": 6, "egp": 8, "igp": 9, "udp": 17, "gre": 47, "esp": 50,
+		"ah": 51, "sctp": 132,
+	}
+)
+
+// ACLNode is a node of headscale as the policy sees it
+type ACLNode struct {
+	ID       uint64   `json:"id"`
+	Name     string   `json:"name"`     // The given name, the hostname if it has none
+	Hostname string   `json:"hostname"` // The name the node registered with
+	User     string   `json:"user"`
+	IPs      []string `json:"ips"`
+	Tags     []string `json:"tags"`
+}
+
+// ACLSimulation is the answer to a reachability query
+type ACLSimulation struct {
+	Allowed     bool           `json:"allowed"`
+	Reason      string         `json:"reason"`
+	RuleIndex   int            `json:"ruleIndex"` // -1 if no rule matched
+	Rule        *model.ACLRule `json:"rule,omitempty"`
+	Source      *ACLNode       `json:"source,omitempty"`      // The node if the source is a node
+	Destination *ACLNode       `json:"destination,omitempty"` // The node if the destination is a node
+}
+
+// ACLReach is what a node can reach on another node
+type ACLReach struct {
+	Node   *ACLNode     `json:"node"`
+	Access []*ACLAccess `json:"access"` // Empty if the node can not be reached
+}
+
+// ACLAccess is the protocol and the ports a rule allows
+type ACLAccess struct {
+	RuleIndex int    `json:"ruleIndex"`
+	Proto     string `json:"proto"`
+	Ports     string `json:"ports"`
+}
+
+// aclPeer is the identity of a source or a destination: a node, or only a user, a tag or an IP
+type aclPeer struct {
+	user string
+	tags []string
+	ips  []netip.Addr
+}
+
+// Simulate answers whether the source can reach the destination with the current policy and the live nodes
+func (s aclPolicyRepository) Simulate(req *vo.ACLSimulateRequest) (*ACLSimulation, error) {
+	content, err := s.acl.GetAccessControl()
+	if err != nil {
+		return nil, err
+	}
+	policy, err := ParseACLPolicy(s.acl.PolicyPath(), []byte(content))
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := listACLNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	src, srcNode, err := resolvePeer(policy, nodes, req.SrcType, req.Src)
+	if err != nil {
+		return nil, fmt.Errorf("source: %w", err)
+	}
+	dst, dstNode, err := resolvePeer(policy, nodes, req.DstType, req.Dst)
+	if err != nil {
+		return nil, fmt.Errorf("destination: %w", err)
+	}
+	proto := req.Proto
+	if proto == "" {
+		proto = "tcp"
+	}
+
+	var result *ACLSimulation
+	if strings.TrimSpace(content) == "" {
+		result = &ACLSimulation{Allowed: true, RuleIndex: -1, Reason: "no ACL policy, headscale allows all traffic"}
+	} else {
+		result = simulateACL(policy, src, dst, proto, req.Port)
+	}
+	result.Source, result.Destination = srcNode, dstNode
+	return result, nil
+}
+
+// Reachability lists what the node can reach on every other node
+func (s aclPolicyRepository) Reachability(node string) ([]*ACLReach, error) {
+	content, err := s.acl.GetAccessControl()
+	if err != nil {
+		return nil, err
+	}
+	policy, err := ParseACLPolicy(s.acl.PolicyPath(), []byte(content))
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := listACLNodes()
+	if err != nil {
+		return nil, err
+	}
+	src := findACLNode(nodes, node)
+	if src == nil {
+		return nil, ErrACLNodeNotFound
+	}
+	allowAll := strings.TrimSpace(content) == ""
+
+	reach := make([]*ACLReach, 0, len(nodes))
+	for _, dst := range nodes {
+		if dst.ID == src.ID {
+			continue
+		}
+		r := &ACLReach{Node: dst, Access: []*ACLAccess{}}
+		if allowAll {
+			r.Access = append(r.Access, &ACLAccess{RuleIndex: -1, Proto: "*", Ports: "*"})
+		} else {
+			r.Access = reachableACL(policy, nodePeer(src), nodePeer(dst))
+		}
+		reach = append(reach, r)
+	}
+	return reach, nil
+}
+
+// simulateACL evaluates the acls section, the first rule which allows the traffic is returned
+func simulateACL(policy *model.ACLPolicy, src, dst *aclPeer, proto string, port int) *ACLSimulation {
+	for i, rule := range policy.ACLs {
+		if !protocolMatches(rule.Protocol, proto) || !anyAliasMatches(policy, rule.Sources, src) {
+			continue
+		}
+		for _, destination := range rule.Destinations {
+			alias, ports := splitDestination(destination)
+			if !aliasMatches(policy, alias, dst) {
+				continue
+			}
+			// ICMP has no port
+			if proto != "icmp" && !portsMatch(ports, port) {
+				continue
+			}
+			rule := rule
+			return &ACLSimulation{
+				Allowed:   true,
+				RuleIndex: i,
+				Rule:      &rule,
+				Reason:    fmt.Sprintf("allowed by acls[%d], destination %s", i, destination),
+			}
+		}
+	}
+	return &ACLSimulation{Allowed: false, RuleIndex: -1, Reason: "no rule allows the traffic, it is denied by default"}
+}
+
+// reachableACL lists the rules which allow traffic from the source to the destination
+func reachableACL(policy *model.ACLPolicy, src, dst *aclPeer) []*ACLAccess {
+	access := make([]*ACLAccess, 0)
+	for i, rule := range policy.ACLs {
+		if !anyAliasMatches(policy, rule.Sources, src) {
+			continue
+		}
+		proto := rule.Protocol
+		if proto == "" {
+			proto = "tcp, udp, icmp"
+		}
+		for _, destination := range rule.Destinations {
+			alias, ports := splitDestination(destination)
+			if aliasMatches(policy, alias, dst) {
+				access = append(access, &ACLAccess{RuleIndex: i, Proto: proto, Ports: ports})
+			}
+		}
+	}
+	return access
+}
+
+func anyAliasMatches(policy *model.ACLPolicy, aliases []string, peer *aclPeer) bool {
+	for _, alias := range aliases {
+		if aliasMatches(policy, alias, peer) {
+			return true
+		}
+	}
+	return false
+}
+
+// aliasMatches checks an alias of a rule against a peer. Like tailscale, a tagged node is not matched by its user.
+func aliasMatches(policy *model.ACLPolicy, alias string, peer *aclPeer) bool {
+	untaggedUser := peer.user != "" && len(peer.tags) == 0
+	switch {
+	case alias == "*":
+		return true
+	case alias == "autogroup:member":
+		return untaggedUser
+	case alias == "autogroup:tagged":
+		return len(peer.tags) > 0
+	case alias == "autogroup:internet":
+		for _, ip := range peer.ips {
+			if !inTailnet(ip) {
+				return true
+			}
+		}
+		return false
+	case strings.HasPrefix(alias, "group:"):
+		if !untaggedUser {
+			return false
+		}
+		for _, member := range policy.Groups[alias] {
+			if strings.TrimSuffix(member, "@") == peer.user {
+				return true
+			}
+		}
+		return false
+	case strings.HasPrefix(alias, "tag:"):
+		return funk.ContainsString(peer.tags, alias)
+	}
+
+	if host, ok := policy.Hosts[alias]; ok {
+		alias = host
+	}
+	if prefix, ok := parseIPOrPrefix(alias); ok {
+		for _, ip := range peer.ips {
+			if prefix.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return untaggedUser && strings.TrimSuffix(alias, "@") == peer.user
+}
+
+func protocolMatches(ruleProto, proto string) bool {
+	if ruleProto == "" {
+		// The default of tailscale
+		return proto == "tcp" || proto == "udp" || proto == "icmp"
+	}
+	if ruleProto == proto {
+		return true
+	}
+	n, err := strconv.Atoi(ruleProto)
+	return err == nil && n == aclProtocolNumbers[proto]
+}
+
+// splitDestination splits a destination into the alias and the ports
+func splitDestination(destination string) (string, string) {
+	idx := strings.LastIndex(destination, ":")
+	if idx < 0 {
+		return destination, ""
+	}
+	return strings.Trim(destination[:idx], "[]"), destination[idx+1:]
+}
+
+func portsMatch(ports string, port int) bool {
+	if ports == "*" {
+		return true
+	}
+	for _, part := range strings.Split(ports, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		if !isRange {
+			last = first
+		}
+		start, err1 := strconv.Atoi(first)
+		end, err2 := strconv.Atoi(last)
+		if err1 == nil && err2 == nil && port >= start && port <= end {
+			return true
+		}
+	}
+	return false
+}
+
+func parseIPOrPrefix(s string) (netip.Prefix, bool) {
+	if ip, err := netip.ParseAddr(s); err == nil {
+		return netip.PrefixFrom(ip, ip.BitLen()), true
+	}
+	prefix, err := netip.ParsePrefix(s)
+	return prefix, err == nil
+}
+
+func inTailnet(ip netip.Addr) bool {
+	for _, prefix := range tailnetPrefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePeer resolves the source or the destination of a query, the node is returned if it is a node
+func resolvePeer(policy *model.ACLPolicy, nodes []*ACLNode, kind, value string) (*aclPeer, *ACLNode, error) {
+	switch kind {
+	case "node":
+		node := findACLNode(nodes, value)
+		if node == nil {
+			return nil, nil, ErrACLNodeNotFound
+		}
+		return nodePeer(node), node, nil
+	case "user":
+		return &aclPeer{user: strings.TrimSuffix(value, "@")}, nil, nil
+	case "tag":
+		if _, ok := policy.TagOwners[value]; !ok {
+			return nil, nil, ErrACLTagNotFound
+		}
+		return &aclPeer{tags: []string{value}}, nil, nil
+	case "ip":
+		ip, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid IP %q", value)
+		}
+		// The IP of a node has the identity of the node
+		for _, node := range nodes {
+			if funk.ContainsString(node.IPs, ip.String()) {
+				return nodePeer(node), node, nil
+			}
+		}
+		return &aclPeer{ips: []netip.Addr{ip}}, nil, nil
+	}
+	return nil, nil, fmt.Errorf("unknown type %q", kind)
+}
+
+func nodePeer(node *ACLNode) *aclPeer {
+	peer := &aclPeer{user: node.User, tags: node.Tags}
+	for _, s := range node.IPs {
+		if ip, err := netip.ParseAddr(s); err == nil {
+			peer.ips = append(peer.ips, ip)
+		}
+	}
+	return peer
+}
+
+// findACLNode finds a node by its ID, its given name or its hostname. The given names are unique, they are matched
+// before the hostnames.
+func findACLNode(nodes []*ACLNode, value string) *ACLNode {
+	id, _ := strconv.ParseUint(value, 10, 64)
+	for _, node := range nodes {
+		if node.ID == id || node.Name == value {
+			return node
+		}
+	}
+	for _, node := range nodes {
+		if node.Hostname == value {
+			return node
+		}
+	}
+	return nil
+}
+
+// listACLNodes gets the live nodes from headscale
+func listACLNodes() ([]*ACLNode, error) {
+	if task.HeadscaleControl == nil || task.HeadscaleControl.HeadscaleServiceClient == nil {
+		return nil, errors.New("headscale is not connected")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	list, err := task.HeadscaleControl.ListNodes(ctx, &pb.ListNodesRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("list nodes error: %w", err)
+	}
+	nodes := make([]*ACLNode, 0, len(list.Nodes))
+	for _, node := range list.Nodes {
+		n := &ACLNode{
+			ID:       node.Id,
+			Name:     node.GivenName,
+			Hostname: node.Name,
+			IPs:      node.IpAddresses,
+			Tags:     funk.UniqString(append(append([]string{}, node.ForcedTags...), node.ValidTags...)),
+		}
+		if n.Name == "" {
+			n.Name = node.Name
+		}
+		if node.User != nil {
+			n.User = node.User.Name
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
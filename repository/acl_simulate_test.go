@@ -0,0 +1,68 @@
+package repository
+
+import "testing"
+
+func TestSimulateACL(t *testing.T) {
+	policy, err := ParseACLPolicy("acl.hujson", []byte(`{
+	"groups": {"group:dev": ["alice"]},
+	"hosts": {"db": "100.64.0.10"},
+	"tagOwners": {"tag:server": ["group:dev"]},
+	"acls": [
+		{"action": "accept", "src": ["group:dev"], "dst": ["tag:server:22,8000-8999"]},
+		{"action": "accept", "proto": "udp", "src": ["bob"], "dst": ["db:5432"]},
+		{"action": "accept", "src": ["tag:server"], "dst": ["db:5432"]},
+	],
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := nodePeer(&ACLNode{User: "alice", IPs: []string{"100.64.0.1"}})
+	bob := nodePeer(&ACLNode{User: "bob", IPs: []string{"100.64.0.2"}})
+	server := nodePeer(&ACLNode{User: "alice", IPs: []string{"100.64.0.3"}, Tags: []string{"tag:server"}})
+	db := nodePeer(&ACLNode{User: "carol", IPs: []string{"100.64.0.10"}})
+
+	tests := []struct {
+		name     string
+		src, dst *aclPeer
+		proto    string
+		port     int
+		rule     int
+	}{
+		{"group to tag", alice, server, "tcp", 8080, 0},
+		{"port outside the range", alice, server, "tcp", 9000, -1},
+		{"protocol of the rule", bob, db, "udp", 5432, 1},
+		{"other protocol", bob, db, "tcp", 5432, -1},
+		{"tagged node is not its user", server, server, "tcp", 22, -1},
+		{"tag to host", server, db, "tcp", 5432, 2},
+	}
+	for _, tt := range tests {
+		result := simulateACL(policy, tt.src, tt.dst, tt.proto, tt.port)
+		if result.RuleIndex != tt.rule || result.Allowed != (tt.rule >= 0) {
+			t.Errorf("%s: got rule %d allowed %v, want rule %d", tt.name, result.RuleIndex, result.Allowed, tt.rule)
+		}
+	}
+
+	if access := reachableACL(policy, alice, server); len(access) != 1 || access[0].Ports != "22,8000-8999" {
+		t.Errorf("alice should reach the server on 22,8000-8999, got %v", access)
+	}
+	if access := reachableACL(policy, alice, db); len(access) != 0 {
+		t.Errorf("alice should not reach the database, got %v", access)
+	}
+}
+
+func TestFindACLNode(t *testing.T) {
+	laptop := &ACLNode{ID: 1, Name: "alice-laptop", Hostname: "MacBook-Pro"}
+	server := &ACLNode{ID: 2, Name: "db", Hostname: "db"}
+	nodes := []*ACLNode{laptop, server}
+	for value, want := range map[string]*ACLNode{
+		"1":            laptop,
+		"alice-laptop": laptop,
+		"MacBook-Pro":  laptop,
+		"db":           server,
+		"unknown":      nil,
+	} {
+		if got := findACLNode(nodes, value); got != want {
+			t.Errorf("find %s got %+v, want %+v", value, got, want)
+		}
+	}
+}
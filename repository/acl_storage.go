@@ -0,0 +1,272 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"headscale-panel/common"
+	"headscale-panel/config"
+	"headscale-panel/util"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	task "headscale-panel/tasks"
+)
+
+// ACLStorage is where headscale loads the ACL policy from
+type ACLStorage interface {
+	Path() string               // Path of the policy, its extension gives the format
+	Read() (string, error)      // Empty if there is no policy yet
+	Check(content string) error // Check the policy with headscale before it is saved, nil if headscale can not check it
+	Write(content string) error // Save the policy, headscale does not load it yet
+	Apply() error               // Make headscale load the saved policy
+}
+
+// newACLStorage creates the storage of the mode. In standalone mode the panel edits the policy file of headscale and
+// reloads it, or uses the policy API when headscale keeps the policy in its database. In multi mode the storage is
+// configured in headscale.acl_storage.
+func newACLStorage() ACLStorage {
+	if config.GetMode() < config.MULTI {
+		conf := common.GetHeadscaleConfig()
+		if conf.Policy.Mode == "database" {
+			return databaseACLStorage{}
+		}
+		path := conf.PolicyPath()
+		if path == "" {
+			return unavailableACLStorage{errors.New("acl config not set")}
+		}
+		// Headscale keeps running the old policy when it rejects the new one on a reload, the new one is checked first
+		return fileACLStorage{path: path, check: func(content string) error {
+			return testHeadscalePolicy(path, content)
+		}, apply: func() error {
+			h := task.HeadscaleService{}
+			return h.Reload(context.Background())
+		}}
+	}
+
+	conf := config.Conf.Headscale.ACLStorage
+	if conf == nil || conf.Type == "" {
+		return unavailableACLStorage{errors.New("multi mode not support, set headscale.acl_storage to manage the acl")}
+	}
+	switch conf.Type {
+	case "file":
+		if conf.Path == "" || conf.ReloadCommand == "" {
+			return unavailableACLStorage{errors.New("headscale.acl_storage.path and reload_command are required")}
+		}
+		return fileACLStorage{path: conf.Path, apply: func() error { return runLocalCommand(conf.ReloadCommand) }}
+	case "ssh":
+		if conf.Path == "" || conf.ReloadCommand == "" || conf.SSH == nil || conf.SSH.Host == "" || conf.SSH.User == "" || conf.SSH.KnownHosts == "" {
+			return unavailableACLStorage{errors.New("headscale.acl_storage.path, reload_command, ssh.host, ssh.user and ssh.known_hosts are required")}
+		}
+		return sshACLStorage{conf: conf}
+	case "database":
+		return databaseACLStorage{}
+	}
+	return unavailableACLStorage{fmt.Errorf("unknown acl storage %q", conf.Type)}
+}
+
+// aclStorageKey identifies the settings newACLStorage uses, the storage is rebuilt when they change. The storage of
+// multi mode is in the panel config, which is only read at startup.
+func aclStorageKey() string {
+	if config.GetMode() >= config.MULTI {
+		return "multi"
+	}
+	conf := common.GetHeadscaleConfig()
+	return conf.Policy.Mode + ":" + conf.PolicyPath()
+}
+
+// unavailableACLStorage is used when the policy can not be managed, every operation fails
+type unavailableACLStorage struct {
+	err error
+}
+
+func (u unavailableACLStorage) Path() string               { return "" }
+func (u unavailableACLStorage) Read() (string, error)      { return "", u.err }
+func (u unavailableACLStorage) Check(content string) error { return u.err }
+func (u unavailableACLStorage) Write(content string) error { return u.err }
+func (u unavailableACLStorage) Apply() error               { return u.err }
+
+// fileACLStorage is a file of the panel host: the policy of headscale in standalone mode, or a shared filesystem
+type fileACLStorage struct {
+	path  string
+	check func(content string) error
+	apply func() error
+}
+
+func (f fileACLStorage) Path() string {
+	return f.path
+}
+
+func (f fileACLStorage) Read() (string, error) {
+	content, err := util.ReadFile(f.path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		return "", nil
+	}
+	return string(content), nil
+}
+
+func (f fileACLStorage) Check(content string) error {
+	if f.check == nil {
+		return nil
+	}
+	return f.check(content)
+}
+
+func (f fileACLStorage) Write(content string) error {
+	return util.SaveFile(f.path, []byte(content))
+}
+
+func (f fileACLStorage) Apply() error {
+	return f.apply()
+}
+
+// sshACLStorage is a file of the headscale host, edited over SSH
+type sshACLStorage struct {
+	conf *config.ACLStorage
+}
+
+func (s sshACLStorage) Path() string {
+	return s.conf.Path
+}
+
+func (s sshACLStorage) Read() (string, error) {
+	path := shellQuote(s.conf.Path)
+	out, err := s.run("if [ -e "+path+" ]; then cat -- "+path+"; fi", nil)
+	return string(out), err
+}
+
+// Check does nothing, headscale of another host can not check the policy
+func (s sshACLStorage) Check(content string) error {
+	return nil
+}
+
+// Write replaces the file at once, headscale never reads a partial policy
+func (s sshACLStorage) Write(content string) error {
+	path := shellQuote(s.conf.Path)
+	tmp := shellQuote(s.conf.Path + ".tmp")
+	_, err := s.run("cat > "+tmp+" && mv -f -- "+tmp+" "+path, strings.NewReader(content))
+	return err
+}
+
+func (s sshACLStorage) Apply() error {
+	_, err := s.run(s.conf.ReloadCommand, nil)
+	return err
+}
+
+func (s sshACLStorage) run(cmd string, stdin io.Reader) ([]byte, error) {
+	client, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("ssh session error: %w", err)
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdin, session.Stdout, session.Stderr = stdin, &stdout, &stderr
+	if err = session.Run(cmd); err != nil {
+		return nil, fmt.Errorf("ssh command error: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func (s sshACLStorage) dial() (*ssh.Client, error) {
+	conf := s.conf.SSH
+	var auth []ssh.AuthMethod
+	if conf.PrivateKey != "" {
+		key, err := util.ReadFile(conf.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("read ssh private key error: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("parse ssh private key error: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if conf.Password != "" {
+		auth = append(auth, ssh.Password(conf.Password))
+	}
+
+	// The policy and the password are only sent to the host of known_hosts
+	hostKeyCallback, err := knownhosts.New(conf.KnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("read known_hosts error: %w", err)
+	}
+
+	port := conf.Port
+	if port == 0 {
+		port = 22
+	}
+	client, err := ssh.Dial("tcp", net.JoinHostPort(conf.Host, strconv.Itoa(port)), &ssh.ClientConfig{
+		User:            conf.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         10 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ssh dial %s error: %w", conf.Host, err)
+	}
+	return client, nil
+}
+
+// databaseACLStorage is the policy API of headscale, headscale loads the policy when it is set
+type databaseACLStorage struct{}
+
+func (d databaseACLStorage) Path() string {
+	// The policy of the database is HuJSON
+	return "policy.hujson"
+}
+
+func (d databaseACLStorage) Read() (string, error) {
+	if task.HeadscaleControl == nil {
+		return "", errors.New("headscale is not connected")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return task.HeadscaleControl.GetPolicy(ctx)
+}
+
+// Check does nothing, headscale checks the policy when it is set
+func (d databaseACLStorage) Check(content string) error {
+	return nil
+}
+
+func (d databaseACLStorage) Write(content string) error {
+	if task.HeadscaleControl == nil {
+		return errors.New("headscale is not connected")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return task.HeadscaleControl.SetPolicy(ctx, content)
+}
+
+func (d databaseACLStorage) Apply() error {
+	return nil
+}
+
+// runLocalCommand runs the reload command of the file storage with the shell, like the ssh storage runs it on the host
+func runLocalCommand(command string) error {
+	if out, err := exec.Command("sh", "-c", command).CombinedOutput(); err != nil {
+		return fmt.Errorf("reload command error: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
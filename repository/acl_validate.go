@@ -0,0 +1,233 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/thoas/go-funk"
+	"headscale-panel/model"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// aclProtocols are the protocol names headscale accepts in the proto field of a rule
+var aclProtocols = []string{"igmp", "ipv4", "ip-in-ip", "tcp", "egp", "igp", "udp", "gre", "esp", "ah", "sctp", "icmp"}
+
+// ACLValidationError lists the problems found in a policy
+type ACLValidationError struct {
+	Problems []string `json:"problems"`
+}
+
+func (e *ACLValidationError) Error() string {
+	return "invalid acl policy: " + strings.Join(e.Problems, "; ")
+}
+
+type aclValidator struct {
+	policy   *model.ACLPolicy
+	users    map[string]bool // nil if the users of headscale are unknown, the user names are not checked
+	problems []string
+}
+
+// ValidateACLPolicy checks the references and the values of the policy before headscale loads it. users are the users
+// of headscale, if nil the user names are not checked.
+func ValidateACLPolicy(policy *model.ACLPolicy, users []string) error {
+	v := &aclValidator{policy: policy}
+	if users != nil {
+		v.users = make(map[string]bool, len(users))
+		for _, user := range users {
+			v.users[user] = true
+		}
+	}
+
+	for name, members := range policy.Groups {
+		if !strings.HasPrefix(name, "group:") {
+			v.addf("groups: %q must start with group:", name)
+		}
+		for _, member := range members {
+			if strings.HasPrefix(member, "group:") {
+				v.addf("groups: %s: groups can not be nested, found %q", name, member)
+				continue
+			}
+			v.checkUser("groups: "+name, member)
+		}
+	}
+	for name, address := range policy.Hosts {
+		if strings.ContainsAny(name, ":@") || name == "*" {
+			v.addf("hosts: invalid host name %q", name)
+		}
+		if !isIPOrPrefix(address) {
+			v.addf("hosts: %s: %q is not an IP or a CIDR", name, address)
+		}
+	}
+	for tag, owners := range policy.TagOwners {
+		if !strings.HasPrefix(tag, "tag:") {
+			v.addf("tagOwners: %q must start with tag:", tag)
+		}
+		for _, owner := range owners {
+			v.checkOwner("tagOwners: "+tag, owner, false)
+		}
+	}
+
+	for i, rule := range policy.ACLs {
+		where := fmt.Sprintf("acls[%d]", i)
+		if rule.Action != "accept" {
+			v.addf("%s: unknown action %q", where, rule.Action)
+		}
+		if !isACLProtocol(rule.Protocol) {
+			v.addf("%s: unknown protocol %q", where, rule.Protocol)
+		}
+		if len(rule.Sources) == 0 || len(rule.Destinations) == 0 {
+			v.addf("%s: src and dst are required", where)
+		}
+		for _, src := range rule.Sources {
+			v.checkAlias(where+": src", src)
+		}
+		for _, dst := range rule.Destinations {
+			idx := strings.LastIndex(dst, ":")
+			if idx < 0 {
+				v.addf("%s: dst %q has no ports, use %s:*", where, dst, dst)
+				continue
+			}
+			v.checkAlias(where+": dst", strings.Trim(dst[:idx], "[]"))
+			if err := checkPorts(dst[idx+1:]); err != nil {
+				v.addf("%s: dst %q: %v", where, dst, err)
+			}
+		}
+	}
+
+	for i, rule := range policy.SSHs {
+		where := fmt.Sprintf("ssh[%d]", i)
+		if rule.Action != "accept" && rule.Action != "check" {
+			v.addf("%s: unknown action %q", where, rule.Action)
+		}
+		if len(rule.Sources) == 0 || len(rule.Destinations) == 0 || len(rule.Users) == 0 {
+			v.addf("%s: src, dst and users are required", where)
+		}
+		for _, src := range rule.Sources {
+			v.checkAlias(where+": src", src)
+		}
+		for _, dst := range rule.Destinations {
+			v.checkAlias(where+": dst", dst)
+		}
+	}
+
+	for route, approvers := range policy.AutoApprovers.Routes {
+		if _, err := netip.ParsePrefix(route); err != nil {
+			v.addf("autoApprovers: routes: %q is not a CIDR", route)
+		}
+		for _, approver := range approvers {
+			v.checkOwner("autoApprovers: "+route, approver, true)
+		}
+	}
+	for _, approver := range policy.AutoApprovers.ExitNode {
+		v.checkOwner("autoApprovers: exitNode", approver, true)
+	}
+
+	if len(v.problems) > 0 {
+		sort.Strings(v.problems)
+		return &ACLValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+func (v *aclValidator) addf(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+// checkAlias checks a source or the host of a destination
+func (v *aclValidator) checkAlias(where, alias string) {
+	switch {
+	case alias == "*", strings.HasPrefix(alias, "autogroup:"):
+	case strings.HasPrefix(alias, "group:"):
+		if _, ok := v.policy.Groups[alias]; !ok {
+			v.addf("%s: unknown group %q", where, alias)
+		}
+	case strings.HasPrefix(alias, "tag:"):
+		if _, ok := v.policy.TagOwners[alias]; !ok {
+			v.addf("%s: tag %q has no owner in tagOwners", where, alias)
+		}
+	case isIPOrPrefix(alias):
+	default:
+		if _, ok := v.policy.Hosts[alias]; ok {
+			return
+		}
+		if strings.ContainsAny(alias, ":/") {
+			v.addf("%s: invalid IP or CIDR %q", where, alias)
+			return
+		}
+		v.checkUser(where, alias)
+	}
+}
+
+// checkOwner checks an owner of a tag or an auto approver, tags only approve routes
+func (v *aclValidator) checkOwner(where, owner string, allowTag bool) {
+	switch {
+	case strings.HasPrefix(owner, "group:"):
+		if _, ok := v.policy.Groups[owner]; !ok {
+			v.addf("%s: unknown group %q", where, owner)
+		}
+	case strings.HasPrefix(owner, "tag:"):
+		if !allowTag {
+			v.addf("%s: a tag can not own a tag, found %q", where, owner)
+		} else if _, ok := v.policy.TagOwners[owner]; !ok {
+			v.addf("%s: tag %q has no owner in tagOwners", where, owner)
+		}
+	default:
+		v.checkUser(where, owner)
+	}
+}
+
+func (v *aclValidator) checkUser(where, user string) {
+	if v.users == nil || v.users[strings.TrimSuffix(user, "@")] {
+		return
+	}
+	v.addf("%s: unknown user %q", where, user)
+}
+
+func isIPOrPrefix(s string) bool {
+	_, ok := parseIPOrPrefix(s)
+	return ok
+}
+
+func isACLProtocol(proto string) bool {
+	if proto == "" {
+		return true
+	}
+	if n, err := strconv.Atoi(proto); err == nil {
+		return n >= 0 && n <= 255
+	}
+	return funk.ContainsString(aclProtocols, proto)
+}
+
+// checkPorts checks the ports of a destination: *, a port, a range or a list of them
+func checkPorts(ports string) error {
+	if ports == "*" {
+		return nil
+	}
+	for _, part := range strings.Split(ports, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := parsePort(first)
+		if err != nil {
+			return err
+		}
+		if !isRange {
+			continue
+		}
+		end, err := parsePort(last)
+		if err != nil {
+			return err
+		}
+		if start > end {
+			return fmt.Errorf("invalid port range %q", part)
+		}
+	}
+	return nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
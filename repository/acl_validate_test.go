@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"errors"
+	"headscale-panel/model"
+	"strings"
+	"testing"
+)
+
+func TestValidateACLPolicy(t *testing.T) {
+	valid := `{
+	// comments and trailing commas are allowed
+	"groups": {"group:admin": ["alice", "bob@"]},
+	"hosts": {"office": "10.1.0.0/16"},
+	"tagOwners": {"tag:server": ["group:admin"]},
+	"acls": [
+		{"action": "accept", "src": ["group:admin"], "dst": ["office:22,80-90", "tag:server:*", "[fd7a::1]:443"]},
+		{"action": "accept", "proto": "udp", "src": ["*"], "dst": ["autogroup:internet:*"]},
+	],
+	"ssh": [{"action": "check", "src": ["alice"], "dst": ["tag:server"], "users": ["root"]}],
+	"autoApprovers": {"routes": {"10.0.0.0/8": ["tag:server"]}, "exitNode": ["group:admin"]},
+}`
+	policy, err := ParseACLPolicy("acl.hujson", []byte(valid))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err = ValidateACLPolicy(policy, []string{"alice", "bob"}); err != nil {
+		t.Errorf("valid policy: %v", err)
+	}
+
+	invalid := `
+groups:
+  group:admin: [alice, carol]
+hosts:
+  office: 10.1.0.0/33
+acls:
+  - action: allow
+    proto: tpc
+    src: [group:dev]
+    dst: ["office:70000", "tag:server:*", "10.0.0.1"]
+`
+	if policy, err = ParseACLPolicy("acl.yaml", []byte(invalid)); err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	err = ValidateACLPolicy(policy, []string{"alice"})
+	var invalidErr *ACLValidationError
+	if !errors.As(err, &invalidErr) {
+		t.Fatalf("got %v, want an ACLValidationError", err)
+	}
+	for _, want := range []string{`unknown user "carol"`, `"10.1.0.0/33" is not an IP`, `unknown action "allow"`,
+		`unknown protocol "tpc"`, `unknown group "group:dev"`, `invalid port "70000"`, `tag "tag:server" has no owner`,
+		`"10.0.0.1" has no ports`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%q not found in %v", want, err)
+		}
+	}
+
+	// The users are not checked if headscale does not answer
+	if err = ValidateACLPolicy(&model.ACLPolicy{Groups: map[string][]string{"group:dev": {"dave"}}}, nil); err != nil {
+		t.Errorf("unknown users: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pmezard/go-difflib/difflib"
+	"gorm.io/gorm"
+	"headscale-panel/common"
+	"headscale-panel/model"
+	"headscale-panel/vo"
+	"strings"
+)
+
+// saveVersion records a saved content of the ACL file and returns its ID
+func saveVersion(content, creator, comment string) (uint, error) {
+	version := &model.ACLVersion{Content: content, Creator: creator, Comment: comment}
+	if err := common.DB.Create(version).Error; err != nil {
+		return 0, fmt.Errorf("save acl version error: %w", err)
+	}
+	return version.ID, nil
+}
+
+// markAppliedVersion marks the version as a good one, headscale runs with it. 0 is the policy which was not changed.
+func markAppliedVersion(id uint) error {
+	if id == 0 {
+		return nil
+	}
+	return common.DB.Model(&model.ACLVersion{}).Where("id = ?", id).Update("applied", true).Error
+}
+
+// restorableVersion returns the last good version before the failed one, or the previous version if there is none
+func restorableVersion(failed uint) (*model.ACLVersion, error) {
+	version := &model.ACLVersion{}
+	err := common.DB.Where("id < ? AND applied = ?", failed, true).Last(version).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = common.DB.Where("id < ?", failed).Last(version).Error
+	}
+	if err != nil {
+		return nil, fmt.Errorf("no acl to restore: %w", err)
+	}
+	return version, nil
+}
+
+// ListVersions lists the versions without their content, the newest first
+func (s accessControlRepository) ListVersions(req *vo.ACLVersionListRequest) ([]model.ACLVersion, int64, error) {
+	var list []model.ACLVersion
+	db := common.DB.Model(&model.ACLVersion{}).Order("id DESC")
+
+	creator := strings.TrimSpace(req.Creator)
+	if creator != "" {
+		db = db.Where("creator LIKE ?", fmt.Sprintf("%%%s%%", creator))
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return list, total, err
+	}
+	db = db.Select("id", "created_at", "updated_at", "comment", "creator", "applied")
+	pageNum := req.PageNum
+	pageSize := req.PageSize
+	var err error
+	if pageNum > 0 && pageSize > 0 {
+		err = db.Offset((pageNum - 1) * pageSize).Limit(pageSize).Find(&list).Error
+	} else {
+		err = db.Find(&list).Error
+	}
+	return list, total, err
+}
+
+func (s accessControlRepository) GetVersion(id uint) (*model.ACLVersion, error) {
+	version := &model.ACLVersion{}
+	if err := common.DB.First(version, id).Error; err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// DiffVersions returns the unified diff from a version to another, 0 is the current content of the ACL file
+func (s accessControlRepository) DiffVersions(from, to uint) (string, error) {
+	fromName, fromContent, err := s.versionContent(from)
+	if err != nil {
+		return "", err
+	}
+	toName, toContent, err := s.versionContent(to)
+	if err != nil {
+		return "", err
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(fromContent),
+		B:        difflib.SplitLines(toContent),
+		FromFile: fromName,
+		ToFile:   toName,
+		Context:  3,
+	})
+}
+
+func (s accessControlRepository) versionContent(id uint) (string, string, error) {
+	if id == 0 {
+		content, err := s.GetAccessControl()
+		return "current", content, err
+	}
+	version, err := s.GetVersion(id)
+	if err != nil {
+		return "", "", fmt.Errorf("get acl version %d error: %w", id, err)
+	}
+	return fmt.Sprintf("version %d", id), version.Content, nil
+}
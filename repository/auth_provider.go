@@ -0,0 +1,197 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"headscale-panel/common"
+	"headscale-panel/config"
+	"headscale-panel/log"
+	"headscale-panel/model"
+	"strings"
+)
+
+// Account sources of model.User
+const (
+	SourceLocal = "local"
+	SourceLdap  = "ldap"
+	SourceOidc  = "oidc"
+	SourceScim  = "scim"
+)
+
+// defaultAvatar is used by the users created by an external directory
+const defaultAvatar = "https://wpimg.wallstcn.com/f778738c-e4f8-4870-b634-56703b4acafe.gif"
+
+// ErrUserNotHandled is returned by an AuthProvider when the user is not managed by it,
+// so that the next provider can try to authenticate the user
+var ErrUserNotHandled = errors.New("user is not handled by this provider")
+
+// AuthProvider authenticates a user by username and password against a user directory
+type AuthProvider interface {
+	Name() string                                                // Provider name, the same as model.User Source
+	Authenticate(username, password string) (*model.User, error) // Verify the password and return the panel user
+}
+
+// ExternalProfile is the user information provided by an external directory, used to provision model.User
+type ExternalProfile struct {
+	ExternalID string // Subject of the OIDC user or DN of the LDAP user
+	Username   string
+	Email      string
+	Nickname   string
+	Roles      []string // Role keywords
+	// The provider verified the email, only a verified email links an account
+	EmailVerified bool
+}
+
+// NewAuthProviders returns the enabled providers in the order they are tried
+func NewAuthProviders() []AuthProvider {
+	providers := []AuthProvider{localProvider{repo: UserRepository{}}}
+	if ldap := NewLdapProvider(); ldap != nil {
+		providers = append(providers, ldap)
+	}
+	return providers
+}
+
+// Authenticate tries the providers in order until one of them handles the user
+func Authenticate(username, password string) (*model.User, error) {
+	for _, provider := range NewAuthProviders() {
+		user, err := provider.Authenticate(username, password)
+		if errors.Is(err, ErrUserNotHandled) {
+			continue
+		}
+		return user, err
+	}
+	return nil, errors.New("user does not exist")
+}
+
+// localProvider verifies the bcrypt password stored in the panel database
+type localProvider struct {
+	repo IUserRepository
+}
+
+func (l localProvider) Name() string {
+	return SourceLocal
+}
+
+func (l localProvider) Authenticate(username, password string) (*model.User, error) {
+	var user model.User
+	err := common.DB.Select("source").Where("name = ?", username).First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotHandled
+		}
+		return nil, err
+	}
+	// The users provisioned by SCIM can also have a local password
+	if user.Source != "" && user.Source != SourceLocal && user.Source != SourceScim {
+		return nil, ErrUserNotHandled
+	}
+	return l.repo.Login(&model.User{Name: username, Password: password})
+}
+
+// ProvisionUser creates or updates the panel user of an external directory just in time,
+// the headscale user with the same name is created if it does not exist
+func ProvisionUser(source string, profile *ExternalProfile) (*model.User, error) {
+	var roles []*model.Role
+	if len(profile.Roles) > 0 {
+		if err := common.DB.Where("keyword IN (?)", profile.Roles).Find(&roles).Error; err != nil {
+			return nil, err
+		}
+	}
+	if len(roles) == 0 {
+		return nil, errors.New("no role is mapped to the user")
+	}
+
+	var user model.User
+	err := common.DB.Where("name = ?", profile.Username).Preload("Roles").First(&user).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		user = model.User{
+			Name:     profile.Username,
+			Email:    profile.Email,
+			Nickname: profile.Nickname,
+			Avatar:   defaultAvatar,
+			Status:   1,
+			Creator:  source,
+			Source:   source,
+			Roles:    roles,
+		}
+		if err = common.DB.Create(&user).Error; err != nil {
+			return nil, fmt.Errorf("create user error: %w", err)
+		}
+		log.Log.Infof("provisioned %s user %s", source, user.Name)
+	case err != nil:
+		return nil, err
+	default:
+		// The accounts and groups provisioned by SCIM are managed by the identity provider, only sign in them
+		if user.Source == SourceScim {
+			if err = linkScimUser(source, &user, profile); err != nil {
+				return nil, err
+			}
+			return &user, nil
+		}
+		if user.Source != source {
+			return nil, fmt.Errorf("user %s already exists with source %s", user.Name, user.Source)
+		}
+		user.Email = profile.Email
+		user.Nickname = profile.Nickname
+		if err = common.DB.Model(&user).Select("email", "nickname").Updates(&user).Error; err != nil {
+			return nil, err
+		}
+		if err = common.DB.Model(&user).Association("Roles").Replace(roles); err != nil {
+			return nil, err
+		}
+		user.Roles = roles
+		userInfoCache.Delete(user.Name)
+	}
+	TriggerACLRoleSync()
+
+	if err = ensureHeadscaleUser(user.Name); err != nil {
+		log.Log.Errorf("create headscale user %s error: %v", user.Name, err)
+	}
+	return &user, nil
+}
+
+// linkScimUser checks that the account of an external directory is the user provisioned by SCIM with the same name.
+// The directory must be allowed to link the SCIM users, and the external ids or the emails must match.
+func linkScimUser(source string, user *model.User, profile *ExternalProfile) error {
+	if conf := config.Conf.Scim; conf == nil || !containsFold(conf.LinkProviders, source) {
+		return fmt.Errorf("user %s already exists with source %s", user.Name, user.Source)
+	}
+	if user.ExternalID != "" && user.ExternalID == profile.ExternalID {
+		return nil
+	}
+	if user.Email != "" && profile.EmailVerified && strings.EqualFold(user.Email, profile.Email) {
+		return nil
+	}
+	return fmt.Errorf("the %s account %s is not the user provisioned by SCIM", source, profile.Username)
+}
+
+// ensureHeadscaleUser creates the headscale user if it does not exist
+func ensureHeadscaleUser(name string) error {
+	repo := NewUserRepo()
+	if _, err := repo.GetUserWithString(name); err == nil {
+		return nil
+	}
+	if _, err := repo.CreateUserWithString(name); err != nil && !strings.Contains(strings.ToLower(err.Error()), "already exists") {
+		return err
+	}
+	return nil
+}
+
+// mapGroupsToRoles returns the role keywords mapped from the groups, or the default role if none is mapped
+func mapGroupsToRoles(mappings []config.GroupMapping, groups []string, defaultRole string) []string {
+	roles := make([]string, 0)
+	for _, mapping := range mappings {
+		for _, group := range groups {
+			if strings.EqualFold(mapping.Group, group) {
+				roles = append(roles, mapping.Role)
+				break
+			}
+		}
+	}
+	if len(roles) == 0 && defaultRole != "" {
+		roles = append(roles, defaultRole)
+	}
+	return roles
+}
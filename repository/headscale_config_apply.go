@@ -0,0 +1,238 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aidarkhanov/nanoid"
+	"github.com/patrickmn/go-cache"
+	"headscale-panel/common"
+	"headscale-panel/config"
+	"headscale-panel/dto"
+	"headscale-panel/log"
+	task "headscale-panel/tasks"
+	"headscale-panel/util"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// headscaleConfigTestTimeout is how long headscale configtest may run
+const headscaleConfigTestTimeout = 30 * time.Second
+
+var (
+	// configJobs keeps the applies of the headscale config for a day, the jobs are stored by value so a read never
+	// races with the apply
+	configJobs = cache.New(24*time.Hour, time.Hour)
+	// configApplyLock is held from the write of a config until headscale is healthy with it or the previous one, and
+	// by an acl apply of standalone mode, which reloads or restarts headscale too
+	configApplyLock sync.Mutex
+)
+
+var (
+	ErrHeadscaleConfigApplying    = errors.New("another headscale config or acl is being applied")
+	ErrHeadscaleConfigJobNotFound = errors.New("headscale config job not found")
+)
+
+// lockHeadscaleApply takes configApplyLock for an acl apply of standalone mode, a reload during a config job would
+// restart headscale without the configtest and the rollback of the job
+func lockHeadscaleApply() (func(), error) {
+	if config.GetMode() >= config.MULTI {
+		return func() {}, nil
+	}
+	if !configApplyLock.TryLock() {
+		return nil, ErrHeadscaleConfigApplying
+	}
+	return configApplyLock.Unlock, nil
+}
+
+// ApplyHeadscaleConfig checks the config with headscale configtest and writes it, headscale is restarted with it in
+// the background. If headscale is not healthy with the new config, the previous config is written back and headscale
+// restarted again. The config is recorded as a version, the job tells how the apply went.
+func (s headscaleConfigRepository) ApplyHeadscaleConfig(file string, content []byte, creator, comment string) (*dto.HeadscaleConfigJob, error) {
+	if !configApplyLock.TryLock() {
+		return nil, ErrHeadscaleConfigApplying
+	}
+	job, err := s.startConfigJob(file, content, creator, comment)
+	if err != nil {
+		configApplyLock.Unlock()
+		return nil, err
+	}
+	return job, nil
+}
+
+func (s headscaleConfigRepository) startConfigJob(file string, content []byte, creator, comment string) (*dto.HeadscaleConfigJob, error) {
+	previous, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err = testHeadscaleConfig(file, content); err != nil {
+		return nil, err
+	}
+	version, err := recordConfigVersion(previous, content, creator, comment)
+	if err != nil {
+		return nil, err
+	}
+	if err = util.SaveFile(file, content); err != nil {
+		return nil, err
+	}
+	systemCache.Delete("headscale-config")
+
+	job := dto.HeadscaleConfigJob{
+		ID:        nanoid.New(),
+		Status:    dto.ConfigJobRunning,
+		Version:   version.ID,
+		Creator:   creator,
+		StartedAt: time.Now(),
+	}
+	configJobs.Set(job.ID, job, cache.DefaultExpiration)
+	go func() {
+		defer configApplyLock.Unlock()
+		s.runConfigJob(job, file, previous)
+	}()
+	return &job, nil
+}
+
+// runConfigJob restarts headscale with the written config, and restores the previous config if it is not healthy
+func (s headscaleConfigRepository) runConfigJob(job dto.HeadscaleConfigJob, file string, previous []byte) {
+	err := restartHeadscale()
+	if err == nil {
+		finishConfigJob(job, dto.ConfigJobSucceeded, nil)
+		return
+	}
+
+	log.Log.Errorf("headscale is not healthy with the new config, restore the previous one: %v", err)
+	if previous == nil {
+		finishConfigJob(job, dto.ConfigJobFailed, fmt.Errorf("%v, there is no previous config to restore", err))
+		return
+	}
+	// The restored config is recorded first, so it is not taken for an edit outside the panel
+	comment := fmt.Sprintf("Restored, headscale was not healthy with version %d", job.Version)
+	if _, versionErr := saveConfigVersion(string(previous), "System", comment); versionErr != nil {
+		log.Log.Error(versionErr)
+	}
+	if restoreErr := util.SaveFile(file, previous); restoreErr != nil {
+		finishConfigJob(job, dto.ConfigJobFailed, fmt.Errorf("%v, restore error: %v", err, restoreErr))
+		return
+	}
+	systemCache.Delete("headscale-config")
+	if restartErr := restartHeadscale(); restartErr != nil {
+		finishConfigJob(job, dto.ConfigJobFailed, fmt.Errorf("%v, headscale is not healthy with the previous config either: %v", err, restartErr))
+		return
+	}
+	finishConfigJob(job, dto.ConfigJobRolledBack, err)
+}
+
+func finishConfigJob(job dto.HeadscaleConfigJob, status string, err error) {
+	now := time.Now()
+	job.Status, job.FinishedAt = status, &now
+	if err != nil {
+		job.Error = err.Error()
+		log.Log.Errorf("apply headscale config %s %s: %v", job.ID, status, err)
+	}
+	configJobs.Set(job.ID, job, cache.DefaultExpiration)
+}
+
+// GetHeadscaleConfigJob returns an apply of the headscale config of the last day
+func (s headscaleConfigRepository) GetHeadscaleConfigJob(id string) (*dto.HeadscaleConfigJob, error) {
+	job, ok := configJobs.Get(id)
+	if !ok {
+		return nil, ErrHeadscaleConfigJobNotFound
+	}
+	result := job.(dto.HeadscaleConfigJob)
+	return &result, nil
+}
+
+// ListHeadscaleConfigJobs returns the applies of the headscale config of the last day, the latest first
+func (s headscaleConfigRepository) ListHeadscaleConfigJobs() []dto.HeadscaleConfigJob {
+	items := configJobs.Items()
+	jobs := make([]dto.HeadscaleConfigJob, 0, len(items))
+	for _, item := range items {
+		jobs = append(jobs, item.Object.(dto.HeadscaleConfigJob))
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartedAt.After(jobs[j].StartedAt)
+	})
+	return jobs
+}
+
+// testHeadscaleConfig runs headscale configtest on the content. The content is written next to the config, so the
+// relative paths in it resolve like they do for the config.
+func testHeadscaleConfig(file string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".configtest-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), headscaleConfigTestTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, config.Conf.Headscale.App, "configtest", "-c", tmp.Name()).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return fmt.Errorf("headscale configtest failed: %s", msg)
+		}
+		return fmt.Errorf("headscale configtest failed: %w", err)
+	}
+	return nil
+}
+
+// testHeadscalePolicy runs headscale configtest with the config pointed at the policy, headscale loads the policy in
+// configtest. The policy is written next to the policy file and the config next to the config file.
+func testHeadscalePolicy(path, content string) error {
+	file := config.Conf.Headscale.Config
+	conf, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	// Headscale resolves a relative policy path from the directory of the config
+	dir := filepath.Dir(path)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(file), dir)
+	}
+	tmp, err := os.CreateTemp(dir, ".acltest-*"+filepath.Ext(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	pointer := util.JSONPointer("acl_policy_path")
+	if common.GetHeadscaleConfig().Policy.Path != "" {
+		pointer = util.JSONPointer("policy", "path")
+	}
+	conf, err = util.PatchYAML(conf, []util.PatchOperation{{Op: "add", Path: pointer, Value: tmp.Name()}})
+	if err != nil {
+		return fmt.Errorf("point the headscale config at the acl error: %w", err)
+	}
+	return testHeadscaleConfig(file, conf)
+}
+
+// restartHeadscale restarts headscale, reconnects the gRPC client and waits until headscale is healthy
+func restartHeadscale() error {
+	h := task.HeadscaleService{}
+	if err := h.Restart(context.Background()); err != nil {
+		return err
+	}
+	if err := task.HeadscaleControl.ReConnect(); err != nil {
+		log.Log.Errorf("grpc err: %v", err)
+	}
+	return h.CheckHealth(headscaleHealthTimeout)
+}
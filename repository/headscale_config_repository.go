@@ -1,14 +1,20 @@
 package repository
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	pb "github.com/juanfont/headscale/gen/go/headscale/v1"
 	"github.com/patrickmn/go-cache"
 	"headscale-panel/common"
-	"headscale-panel/config"
+	"headscale-panel/dto"
+	"headscale-panel/log"
 	"headscale-panel/model"
+	task "headscale-panel/tasks"
 	"headscale-panel/util"
 	"headscale-panel/vo"
 	"os"
+	"sync"
 	"time"
 )
 
@@ -21,8 +27,17 @@ var systemCache = cache.New(168*time.Hour, 24*time.Hour)
 type HeadscaleConfigRepository interface {
 	GetHeadscaleConfigFromFile(file string) (string, error)
 	GetHeadscaleConfigFromDB() (*model.HeadscaleConfig, error)
-	SetHeadscaleConfigFromFile(file string, headscale *vo.SystemSettingHeadscale) error
+	SetHeadscaleConfigFromFile(file string, headscale *vo.SystemSettingHeadscale, creator string) (*dto.HeadscaleConfigJob, error)
 	SetHeadscaleConfigFromDB(headscale *vo.SystemSettingHeadscale) error
+	GetHeadscaleConfigSections(file string) (string, map[string]interface{}, error)
+	GetHeadscaleConfigSection(file, name string) (interface{}, error)
+	PatchHeadscaleConfigSection(file, name string, patch []byte, creator string) (interface{}, *dto.HeadscaleConfigJob, error)
+	GetHeadscaleConfigJob(id string) (*dto.HeadscaleConfigJob, error)
+	ListHeadscaleConfigJobs() []dto.HeadscaleConfigJob
+	ListConfigVersions(req *vo.HeadscaleConfigVersionListRequest) ([]model.HeadscaleConfigVersion, int64, error)
+	GetConfigVersion(id uint) (*model.HeadscaleConfigVersion, error)
+	DiffConfigVersions(file string, from, to uint) (string, error)
+	RestoreConfigVersion(file string, id uint, creator, comment string) (*dto.HeadscaleConfigJob, error)
 	//SetHeadscaleCert(reader io.Reader) error
 	//SetHeadscaleKey(reader io.Reader) error
 	//SetHeadscaleCA(reader io.Reader) error
@@ -65,13 +80,10 @@ func (s headscaleConfigRepository) GetHeadscaleConfigFromDB() (*model.HeadscaleC
 	return modelData, nil
 }
 
-// SetHeadscaleConfigFromFile sets the content of headscale config file.
-func (s headscaleConfigRepository) SetHeadscaleConfigFromFile(file string, h *vo.SystemSettingHeadscale) error {
-	if err := util.SaveFile(file, []byte(h.Yaml)); err != nil {
-		return err
-	}
-	systemCache.Delete("headscale-config")
-	return nil
+// SetHeadscaleConfigFromFile sets the content of headscale config file, and applies it. The content is recorded as a
+// version with the comment.
+func (s headscaleConfigRepository) SetHeadscaleConfigFromFile(file string, h *vo.SystemSettingHeadscale, creator string) (*dto.HeadscaleConfigJob, error) {
+	return s.ApplyHeadscaleConfig(file, []byte(h.Yaml), creator, h.Comment)
 }
 
 // SetHeadscaleConfigFromDB sets the grpc configuration to connect headscale in the database.
@@ -125,57 +137,226 @@ func (s headscaleConfigRepository) SetHeadscaleConfigFromDB(h *vo.SystemSettingH
 
 /* AccessControlRepository -------------------------------------- */
 
-var aclFile string
+// The acl storage is shared by the repositories, it is rebuilt when the policy settings of headscale change
+var (
+	sharedACLStorage     ACLStorage
+	sharedACLStorageKey  string
+	sharedACLStorageLock sync.Mutex
+)
+
+// aclWriteLock is held from the write of the acl policy until headscale is healthy with it or the last good one is
+// restored
+var aclWriteLock sync.Mutex
+
+// ErrACLChanged is returned when the acl policy changed since it was read for an edit
+var ErrACLChanged = errors.New("the acl policy changed while it was edited, try again")
+
+// headscaleHealthTimeout is how long headscale has to answer after an apply before the policy is rolled back
+const headscaleHealthTimeout = 30 * time.Second
 
 type AccessControlRepository interface {
+	PolicyPath() string // Path of the policy, its extension gives the format
 	GetAccessControl() (string, error)
-	SetAccessControl(aclContent string) error
+	SetAccessControl(aclContent, creator, comment string) (uint, error) // Returns the recorded version
+	ReplaceAccessControl(previous, aclContent, creator, comment string) (uint, error)
+	ValidateAccessControl(aclContent string) error
+	ListVersions(req *vo.ACLVersionListRequest) ([]model.ACLVersion, int64, error)
+	GetVersion(id uint) (*model.ACLVersion, error)
+	DiffVersions(from, to uint) (string, error)
 }
 
 type accessControlRepository struct{}
 
-// NewAccessControlRepository new a repository with acl file. Not available at Stand-alone mode
+// NewAccessControlRepository new a repository with the acl storage of the mode
 func NewAccessControlRepository() AccessControlRepository {
-	aclFile = common.GetHeadscaleConfig().AccessControl
 	return &accessControlRepository{}
 }
 
-// GetAccessControl get content of access_control.yaml
-func (s accessControlRepository) GetAccessControl() (string, error) {
-	if config.GetMode() >= config.MULTI {
-		return "", errors.New("multi mode not support")
+// aclStorage returns the storage of the current policy settings, the storage is rebuilt when they changed since it
+// was created, e.g. the policy path was set in the headscale config
+func aclStorage() ACLStorage {
+	sharedACLStorageLock.Lock()
+	defer sharedACLStorageLock.Unlock()
+	key := aclStorageKey()
+	if sharedACLStorage == nil || key != sharedACLStorageKey {
+		if sharedACLStorage != nil {
+			log.Log.Info("the acl policy settings of headscale changed, the acl storage is rebuilt")
+			systemCache.Delete("access_control")
+		}
+		sharedACLStorage, sharedACLStorageKey = newACLStorage(), key
 	}
+	return sharedACLStorage
+}
 
-	if aclFile == "" {
-		return "", errors.New("acl config not set")
-	}
+func (s accessControlRepository) PolicyPath() string {
+	return aclStorage().Path()
+}
 
+// GetAccessControl get content of the acl policy
+func (s accessControlRepository) GetAccessControl() (string, error) {
 	if data, ok := systemCache.Get("access_control"); ok {
 		return data.(string), nil
 	}
-	content, err := util.ReadFile(aclFile)
+	content, err := aclStorage().Read()
 	if err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			return "", err
-		}
-		return "", nil
+		return "", err
 	}
-	systemCache.Set("access_control", string(content), cache.DefaultExpiration)
-	return string(content), nil
+	systemCache.Set("access_control", content, cache.DefaultExpiration)
+	return content, nil
 }
 
-// SetAccessControl set content of access_control.yaml
-func (s accessControlRepository) SetAccessControl(acl string) error {
-	if config.GetMode() >= config.MULTI {
-		return errors.New("multi mode not support")
+// SetAccessControl validates and saves the acl policy, records it as a version and makes headscale load it. If
+// headscale does not come back the last good policy is restored.
+func (s accessControlRepository) SetAccessControl(acl, creator, comment string) (uint, error) {
+	aclWriteLock.Lock()
+	defer aclWriteLock.Unlock()
+	unlock, err := lockHeadscaleApply()
+	if err != nil {
+		return 0, err
 	}
-	if aclFile == "" {
-		return errors.New("acl config not set")
+	defer unlock()
+	storage := aclStorage()
+	version, err := s.setAccessControl(storage, acl, creator, comment)
+	if err != nil {
+		return 0, err
 	}
-	if err := util.SaveFile(aclFile, []byte(acl)); err != nil {
-		return err
+	return version, s.applyAccessControl(storage, version)
+}
+
+// ReplaceAccessControl saves the acl policy like SetAccessControl if it is still the previous content, an edit built
+// from the previous content would lose a change made meanwhile
+func (s accessControlRepository) ReplaceAccessControl(previous, acl, creator, comment string) (uint, error) {
+	aclWriteLock.Lock()
+	defer aclWriteLock.Unlock()
+	unlock, err := lockHeadscaleApply()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	storage := aclStorage()
+	current, err := storage.Read()
+	if err != nil {
+		return 0, err
+	}
+	if current != previous {
+		// The cached policy may be the stale one
+		systemCache.Delete("access_control")
+		return 0, ErrACLChanged
+	}
+	version, err := s.setAccessControl(storage, acl, creator, comment)
+	if err != nil {
+		return 0, err
+	}
+	return version, s.applyAccessControl(storage, version)
+}
+
+func (s accessControlRepository) setAccessControl(storage ACLStorage, acl, creator, comment string) (uint, error) {
+	policy, err := ParseACLPolicy(storage.Path(), []byte(acl))
+	if err != nil {
+		return 0, err
+	}
+	if err = ValidateACLPolicy(policy, headscaleUserNames()); err != nil {
+		return 0, err
+	}
+	if err = storage.Check(acl); err != nil {
+		return 0, err
+	}
+	if err = s.keepInitialAccessControl(); err != nil {
+		return 0, err
+	}
+	if err = storage.Write(acl); err != nil {
+		return 0, err
 	}
-	// todo restart headscale server if using oidc
 	systemCache.Delete("access_control")
+	return saveVersion(acl, creator, comment)
+}
+
+// ValidateAccessControl parses the policy and checks it with the users of headscale
+func (s accessControlRepository) ValidateAccessControl(acl string) error {
+	policy, err := ParseACLPolicy(aclStorage().Path(), []byte(acl))
+	if err != nil {
+		return err
+	}
+	return ValidateACLPolicy(policy, headscaleUserNames())
+}
+
+// applyAccessControl makes headscale load the saved policy of the version. If headscale does not come back the last
+// good policy before the version is restored and applied again. The caller holds aclWriteLock.
+func (s accessControlRepository) applyAccessControl(storage ACLStorage, version uint) error {
+	h := task.HeadscaleService{}
+	err := storage.Apply()
+	if err == nil {
+		err = h.CheckHealth(headscaleHealthTimeout)
+	}
+	if err == nil {
+		if err = markAppliedVersion(version); err != nil {
+			log.Log.Errorf("keep the good acl error: %v", err)
+		}
+		return nil
+	}
+
+	log.Log.Errorf("headscale is not healthy with the new acl, restore the last good one: %v", err)
+	restored, restoreErr := s.restoreAccessControl(storage, version)
+	if restoreErr != nil {
+		return fmt.Errorf("headscale is not healthy with the new acl: %v, restore error: %v", err, restoreErr)
+	}
+	if applyErr := storage.Apply(); applyErr != nil {
+		log.Log.Errorf("apply the restored acl error: %v", applyErr)
+	} else if healthErr := h.CheckHealth(headscaleHealthTimeout); healthErr != nil {
+		log.Log.Errorf("headscale is not healthy with the restored acl: %v", healthErr)
+	} else if markErr := markAppliedVersion(restored); markErr != nil {
+		log.Log.Errorf("keep the good acl error: %v", markErr)
+	}
+	return fmt.Errorf("headscale is not healthy with the new acl, the last good acl is restored: %v", err)
+}
+
+// keepInitialAccessControl records the policy headscale runs with before the first save, so it can be restored
+func (s accessControlRepository) keepInitialAccessControl() error {
+	var count int64
+	if err := common.DB.Model(&model.ACLVersion{}).Count(&count).Error; err != nil || count > 0 {
+		return err
+	}
+	current, err := s.GetAccessControl()
+	if err != nil || current == "" {
+		return err
+	}
+	version := &model.ACLVersion{Content: current, Creator: "System", Comment: "Initial policy", Applied: true}
+	if err = common.DB.Create(version).Error; err != nil {
+		return fmt.Errorf("save acl version error: %w", err)
+	}
 	return nil
 }
+
+// restoreAccessControl restores the last version before the failed one headscale ran with, or the previous version
+// if headscale never ran with a saved one. The restored policy is recorded as a new version. The caller holds
+// aclWriteLock.
+func (s accessControlRepository) restoreAccessControl(storage ACLStorage, failed uint) (uint, error) {
+	version, err := restorableVersion(failed)
+	if err != nil {
+		return 0, err
+	}
+	if err = storage.Write(version.Content); err != nil {
+		return 0, err
+	}
+	systemCache.Delete("access_control")
+	return saveVersion(version.Content, "System", "Restored, headscale was not healthy with the saved policy")
+}
+
+// headscaleUserNames returns the users of headscale, nil if headscale does not answer
+func headscaleUserNames() []string {
+	if task.HeadscaleControl == nil || task.HeadscaleControl.HeadscaleServiceClient == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	list, err := task.HeadscaleControl.ListUsers(ctx, &pb.ListUsersRequest{})
+	if err != nil {
+		log.Log.Warnf("list headscale users to validate the acl error: %v", err)
+		return nil
+	}
+	names := make([]string, 0, len(list.Users))
+	for _, user := range list.Users {
+		names = append(names, user.Name)
+	}
+	return names
+}
@@ -0,0 +1,351 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"gopkg.in/yaml.v3"
+	"headscale-panel/common"
+	"headscale-panel/dto"
+	"headscale-panel/model"
+	task "headscale-panel/tasks"
+	"headscale-panel/util"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// headscaleConfigSection is a section of the headscale config, key is the key of the section in the file, empty for the
+// sections whose keys are at the top level
+type headscaleConfigSection struct {
+	key string
+	new func() interface{}
+}
+
+// The layouts of the headscale config, headscale 0.23.0 moved the prefixes, dns, database and policy keys into sections
+const (
+	HeadscaleConfigLayoutV22 = "0.22"
+	HeadscaleConfigLayoutV23 = "0.23"
+)
+
+var headscaleConfigLayouts = map[string]map[string]headscaleConfigSection{
+	HeadscaleConfigLayoutV22: {
+		"server":   {"", func() interface{} { return &model.HeadscaleServerSection{} }},
+		"prefixes": {"", func() interface{} { return &model.HeadscalePrefixesSection{} }},
+		"derp":     {"derp", func() interface{} { return &model.HeadscaleDERPSection{} }},
+		"dns":      {"dns_config", func() interface{} { return &model.HeadscaleDNSSection{} }},
+		"oidc":     {"oidc", func() interface{} { return &model.HeadscaleOIDCSection{} }},
+		"database": {"", func() interface{} { return &model.HeadscaleDatabaseSection{} }},
+		"log":      {"log", func() interface{} { return &model.HeadscaleLogSection{} }},
+		"policy":   {"", func() interface{} { return &model.HeadscalePolicySection{} }},
+	},
+	HeadscaleConfigLayoutV23: {
+		"server":   {"", func() interface{} { return &model.HeadscaleServerSection{} }},
+		"prefixes": {"prefixes", func() interface{} { return &model.HeadscalePrefixesSectionV23{} }},
+		"derp":     {"derp", func() interface{} { return &model.HeadscaleDERPSection{} }},
+		"dns":      {"dns", func() interface{} { return &model.HeadscaleDNSSectionV23{} }},
+		"oidc":     {"oidc", func() interface{} { return &model.HeadscaleOIDCSection{} }},
+		"database": {"database", func() interface{} { return &model.HeadscaleDatabaseSectionV23{} }},
+		"log":      {"log", func() interface{} { return &model.HeadscaleLogSection{} }},
+		"policy":   {"policy", func() interface{} { return &model.HeadscalePolicySectionV23{} }},
+	},
+}
+
+var headscaleVersionRegexp = regexp.MustCompile(`(\d+)\.(\d+)\.(\d+)(-\S+)?`)
+
+// headscaleConfigLayout returns the layout of the installed headscale. The layout of a pre-release of 0.23.0, or of an
+// unknown version, is the one of the file.
+func headscaleConfigLayout(content []byte) string {
+	h := task.HeadscaleService{}
+	if layout := versionConfigLayout(h.GetVersion()); layout != "" {
+		return layout
+	}
+	return fileConfigLayout(content)
+}
+
+// versionConfigLayout returns the layout of a headscale version, empty if the version does not tell
+func versionConfigLayout(version string) string {
+	m := headscaleVersionRegexp.FindStringSubmatch(version)
+	if m == nil {
+		return ""
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	patch, _ := strconv.Atoi(m[3])
+	switch {
+	case major > 0 || minor > 23 || (minor == 23 && (patch > 0 || m[4] == "")):
+		return HeadscaleConfigLayoutV23
+	case minor < 23:
+		return HeadscaleConfigLayoutV22
+	}
+	return ""
+}
+
+// fileConfigLayout returns the layout of the keys in the file, the sections of 0.23.0 are maps
+func fileConfigLayout(content []byte) string {
+	var doc map[string]interface{}
+	if yaml.Unmarshal(content, &doc) == nil {
+		for _, key := range []string{"prefixes", "dns", "database", "policy"} {
+			if _, ok := doc[key].(map[string]interface{}); ok {
+				return HeadscaleConfigLayoutV23
+			}
+		}
+	}
+	return HeadscaleConfigLayoutV22
+}
+
+var ErrHeadscaleConfigSectionNotFound = errors.New("headscale config section not found")
+
+// HeadscaleConfigError is a headscale config section with invalid fields, Fields maps the JSON path of each field to
+// its problem
+type HeadscaleConfigError struct {
+	Fields map[string]string `json:"fields"`
+}
+
+func (e *HeadscaleConfigError) Error() string {
+	problems := make([]string, 0, len(e.Fields))
+	for field, problem := range e.Fields {
+		problems = append(problems, field+": "+problem)
+	}
+	sort.Strings(problems)
+	return "invalid headscale config: " + strings.Join(problems, "; ")
+}
+
+// GetHeadscaleConfigSections reads all the sections of the headscale config file, and tells their layout
+func (s headscaleConfigRepository) GetHeadscaleConfigSections(file string) (string, map[string]interface{}, error) {
+	content, err := s.GetHeadscaleConfigFromFile(file)
+	if err != nil {
+		return "", nil, err
+	}
+	layout := headscaleConfigLayout([]byte(content))
+	sections := make(map[string]interface{}, len(headscaleConfigLayouts[layout]))
+	for name := range headscaleConfigLayouts[layout] {
+		if sections[name], err = readConfigSection([]byte(content), layout, name); err != nil {
+			return "", nil, err
+		}
+	}
+	return layout, sections, nil
+}
+
+// GetHeadscaleConfigSection reads a section of the headscale config file
+func (s headscaleConfigRepository) GetHeadscaleConfigSection(file, name string) (interface{}, error) {
+	content, err := s.GetHeadscaleConfigFromFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return readConfigSection([]byte(content), headscaleConfigLayout([]byte(content)), name)
+}
+
+// PatchHeadscaleConfigSection sets the fields of the patch in a section of the headscale config file. The section is
+// validated as a whole, the keys which are not modeled and the comments of the file are kept. The patched file is
+// applied, the job is nil if the file did not change.
+func (s headscaleConfigRepository) PatchHeadscaleConfigSection(file, name string, patch []byte, creator string) (interface{}, *dto.HeadscaleConfigJob, error) {
+	content, err := s.GetHeadscaleConfigFromFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	patched, section, err := patchConfigSection([]byte(content), headscaleConfigLayout([]byte(content)), name, patch)
+	if err != nil || patched == nil {
+		return section, nil, err
+	}
+	job, err := s.ApplyHeadscaleConfig(file, patched, creator, fmt.Sprintf("Update the %s section", name))
+	if err != nil {
+		return nil, nil, err
+	}
+	return section, job, nil
+}
+
+// patchConfigSection returns the patched content of the config and the patched section, the content is nil if the
+// patch does not change the section
+func patchConfigSection(content []byte, layout, name string, patch []byte) ([]byte, interface{}, error) {
+	current, err := readConfigSection(content, layout, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	section, _ := readConfigSection(content, layout, name)
+
+	if err = decodeConfigPatch(section, patch); err != nil {
+		return nil, nil, fmt.Errorf("param error: %w", err)
+	}
+	if err = validateConfigSection(section); err != nil {
+		return nil, nil, err
+	}
+
+	var doc map[string]interface{}
+	if err = yaml.Unmarshal(content, &doc); err != nil {
+		return nil, nil, fmt.Errorf("parse headscale config error: %w", err)
+	}
+	if doc == nil {
+		doc = make(map[string]interface{})
+	}
+	var prefix []string
+	if key := headscaleConfigLayouts[layout][name].key; key != "" {
+		prefix = []string{key}
+	}
+	ops := configSectionOperations(doc, prefix, reflect.ValueOf(current).Elem(), reflect.ValueOf(section).Elem())
+	if len(ops) == 0 {
+		return nil, section, nil
+	}
+	patched, err := util.PatchYAML(content, ops)
+	if err != nil {
+		return nil, nil, fmt.Errorf("patch headscale config error: %w", err)
+	}
+	return patched, section, nil
+}
+
+// decodeConfigPatch sets the fields of the patch in the section. A map of the patch replaces the map of the section,
+// so its keys can be removed, the other keys of the section keep their values.
+func decodeConfigPatch(section interface{}, patch []byte) error {
+	clearPatchedMaps(reflect.ValueOf(section).Elem(), patch)
+	decoder := json.NewDecoder(bytes.NewReader(patch))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(section)
+}
+
+// clearPatchedMaps sets the maps of the struct which are in the patch to nil, encoding/json would merge them
+func clearPatchedMaps(v reflect.Value, patch []byte) {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(patch, &fields) != nil {
+		// The decoder reports the error
+		return
+	}
+	for i := 0; i < v.NumField(); i++ {
+		raw, ok := fields[strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]]
+		if !ok {
+			continue
+		}
+		switch field := v.Field(i); field.Kind() {
+		case reflect.Map:
+			field.Set(reflect.Zero(field.Type()))
+		case reflect.Struct:
+			clearPatchedMaps(field, raw)
+		}
+	}
+}
+
+// readConfigSection decodes a section of the layout from the config, the keys which are not set are zero
+func readConfigSection(content []byte, layout, name string) (interface{}, error) {
+	conf, ok := headscaleConfigLayouts[layout][name]
+	if !ok {
+		return nil, ErrHeadscaleConfigSectionNotFound
+	}
+	section := conf.new()
+	var err error
+	if conf.key == "" {
+		err = yaml.Unmarshal(content, section)
+	} else {
+		var doc map[string]yaml.Node
+		if err = yaml.Unmarshal(content, &doc); err == nil {
+			if node, ok := doc[conf.key]; ok {
+				err = node.Decode(section)
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse headscale config section %s error: %w", name, err)
+	}
+	return section, nil
+}
+
+// validateConfigSection validates all the fields of a section, the problems are keyed by the JSON path of the fields
+func validateConfigSection(section interface{}) error {
+	err := common.Validate.Struct(section)
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return err
+	}
+	fields := make(map[string]string, len(errs))
+	for _, e := range errs {
+		fields[jsonFieldPath(reflect.TypeOf(section).Elem(), e.StructNamespace())] = e.Translate(common.Trans)
+	}
+	return &HeadscaleConfigError{Fields: fields}
+}
+
+// jsonFieldPath turns the namespace of a struct field, such as HeadscaleDERPSection.Server.RegionID, into the JSON path
+// server.region_id
+func jsonFieldPath(t reflect.Type, namespace string) string {
+	names := strings.Split(namespace, ".")[1:]
+	path := make([]string, 0, len(names))
+	for _, name := range names {
+		index := ""
+		if i := strings.IndexByte(name, '['); i >= 0 {
+			name, index = name[:i], name[i:]
+		}
+		field, ok := t.FieldByName(name)
+		if !ok {
+			path = append(path, name+index)
+			continue
+		}
+		path = append(path, strings.Split(field.Tag.Get("json"), ",")[0]+index)
+		t = field.Type
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+			t = t.Elem()
+		}
+	}
+	return strings.Join(path, ".")
+}
+
+// configSectionOperations builds the operations which set the changed fields. A missing parent key is created with
+// the field, so the keys which are not modeled are never touched.
+func configSectionOperations(doc map[string]interface{}, prefix []string, current, patched reflect.Value) []util.PatchOperation {
+	var ops []util.PatchOperation
+	parent, exists := yamlMapAt(doc, prefix)
+	for i := 0; i < current.NumField(); i++ {
+		key := strings.Split(current.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		from, to := current.Field(i), patched.Field(i)
+		if reflect.DeepEqual(from.Interface(), to.Interface()) {
+			continue
+		}
+		path := append(append([]string{}, prefix...), key)
+		if from.Kind() == reflect.Struct {
+			ops = append(ops, configSectionOperations(doc, path, from, to)...)
+			parent, exists = yamlMapAt(doc, prefix)
+			continue
+		}
+		if !exists {
+			// The parent is created with the first changed field, the next fields are added to it
+			ops = append(ops, createYAMLParent(doc, prefix, key, to.Interface())...)
+			parent, exists = yamlMapAt(doc, prefix)
+			continue
+		}
+		ops = append(ops, util.PatchOperation{Op: "add", Path: util.JSONPointer(path...), Value: to.Interface()})
+		parent[key] = true
+	}
+	return ops
+}
+
+// createYAMLParent adds the value under the first missing map of the path, the created maps are recorded in the doc
+func createYAMLParent(doc map[string]interface{}, prefix []string, key string, value interface{}) []util.PatchOperation {
+	node := doc
+	for i, token := range prefix {
+		child, ok := node[token].(map[string]interface{})
+		if !ok {
+			wrapped := map[string]interface{}{key: value}
+			for j := len(prefix) - 1; j > i; j-- {
+				wrapped = map[string]interface{}{prefix[j]: wrapped}
+			}
+			node[token] = wrapped
+			return []util.PatchOperation{{Op: "add", Path: util.JSONPointer(prefix[:i+1]...), Value: wrapped}}
+		}
+		node = child
+	}
+	node[key] = value
+	return []util.PatchOperation{{Op: "add", Path: util.JSONPointer(append(append([]string{}, prefix...), key)...), Value: value}}
+}
+
+// yamlMapAt returns the map at the path of the doc, and whether it exists
+func yamlMapAt(doc map[string]interface{}, path []string) (map[string]interface{}, bool) {
+	node := doc
+	for _, token := range path {
+		child, ok := node[token].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		node = child
+	}
+	return node, true
+}
@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"headscale-panel/model"
+	"headscale-panel/util"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestConfigSectionOperations(t *testing.T) {
+	content := []byte(`# headscale
+server_url: http://127.0.0.1:8080 # public address
+listen_addr: 127.0.0.1:8080
+unix_socket: /var/run/headscale/headscale.sock
+derp:
+  urls:
+    - https://controlplane.tailscale.com/derpmap/default
+  future_key: kept
+`)
+	tests := []struct {
+		name    string
+		layout  string
+		section string
+		change  func(section interface{})
+		want    []string
+	}{
+		{"top level keys", HeadscaleConfigLayoutV22, "server", func(section interface{}) {
+			section.(*model.HeadscaleServerSection).ServerURL = "https://hs.example.com"
+			section.(*model.HeadscaleServerSection).GRPCListenAddr = "[::]:50443"
+		}, []string{"server_url: https://hs.example.com # public address", `grpc_listen_addr: '[::]:50443'`}},
+		{"missing nested map", HeadscaleConfigLayoutV22, "derp", func(section interface{}) {
+			section.(*model.HeadscaleDERPSection).Server.Enabled = true
+			section.(*model.HeadscaleDERPSection).Server.RegionID = 999
+		}, []string{"  server:\n    enabled: true\n    region_id: 999", "future_key: kept"}},
+		{"missing section", HeadscaleConfigLayoutV22, "log", func(section interface{}) {
+			section.(*model.HeadscaleLogSection).Level = "debug"
+		}, []string{"log:\n  level: debug"}},
+		{"policy mode", HeadscaleConfigLayoutV23, "policy", func(section interface{}) {
+			section.(*model.HeadscalePolicySectionV23).Mode = "database"
+		}, []string{"policy:\n  mode: database"}},
+	}
+	for _, tt := range tests {
+		name := tt.section
+		current, err := readConfigSection(content, tt.layout, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		section, _ := readConfigSection(content, tt.layout, name)
+		tt.change(section)
+
+		var doc map[string]interface{}
+		if err = yaml.Unmarshal(content, &doc); err != nil {
+			t.Fatal(err)
+		}
+		var prefix []string
+		if key := headscaleConfigLayouts[tt.layout][name].key; key != "" {
+			prefix = []string{key}
+		}
+		ops := configSectionOperations(doc, prefix, reflect.ValueOf(current).Elem(), reflect.ValueOf(section).Elem())
+		patched, err := util.PatchYAML(content, ops)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for _, want := range append(tt.want, "# headscale", "unix_socket: /var/run/headscale/headscale.sock") {
+			if !strings.Contains(string(patched), want) {
+				t.Errorf("%s: %q not in\n%s", tt.name, want, patched)
+			}
+		}
+		got, _ := readConfigSection(patched, tt.layout, name)
+		if !reflect.DeepEqual(got, section) {
+			t.Errorf("%s: read back %+v, want %+v", tt.name, got, section)
+		}
+	}
+}
+
+func TestDecodeConfigPatch(t *testing.T) {
+	content := []byte(`oidc:
+  issuer: https://sso.example.com
+  extra_params:
+    domain_hint: example.com
+    prompt: login
+dns:
+  nameservers:
+    split:
+      corp.example.com: [10.0.0.53]
+      lab.example.com: [10.1.0.53]
+`)
+	oidc, _ := readConfigSection(content, HeadscaleConfigLayoutV23, "oidc")
+	if err := decodeConfigPatch(oidc, []byte(`{"extra_params": {"prompt": "consent"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	got := oidc.(*model.HeadscaleOIDCSection)
+	if !reflect.DeepEqual(got.ExtraParams, map[string]string{"prompt": "consent"}) || got.Issuer != "https://sso.example.com" {
+		t.Errorf("oidc got %+v", got)
+	}
+
+	dns, _ := readConfigSection(content, HeadscaleConfigLayoutV23, "dns")
+	if err := decodeConfigPatch(dns, []byte(`{"nameservers": {"split": {"lab.example.com": ["10.1.0.54"]}}}`)); err != nil {
+		t.Fatal(err)
+	}
+	split := dns.(*model.HeadscaleDNSSectionV23).Nameservers.Split
+	if !reflect.DeepEqual(split, map[string][]string{"lab.example.com": {"10.1.0.54"}}) {
+		t.Errorf("split got %v", split)
+	}
+
+	// A patch without the map keeps it
+	dns, _ = readConfigSection(content, HeadscaleConfigLayoutV23, "dns")
+	if err := decodeConfigPatch(dns, []byte(`{"magic_dns": true}`)); err != nil {
+		t.Fatal(err)
+	}
+	if split = dns.(*model.HeadscaleDNSSectionV23).Nameservers.Split; len(split) != 2 {
+		t.Errorf("split got %v, want it kept", split)
+	}
+}
+
+func TestJSONFieldPath(t *testing.T) {
+	got := jsonFieldPath(reflect.TypeOf(model.HeadscaleDERPSection{}), "HeadscaleDERPSection.Server.RegionID")
+	if got != "server.region_id" {
+		t.Errorf("got %s", got)
+	}
+	got = jsonFieldPath(reflect.TypeOf(model.HeadscalePrefixesSection{}), "HeadscalePrefixesSection.IPPrefixes[1]")
+	if got != "ip_prefixes[1]" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestHeadscaleConfigLayout(t *testing.T) {
+	versions := map[string]string{
+		"v0.22.3":        HeadscaleConfigLayoutV22,
+		"v0.23.0":        HeadscaleConfigLayoutV23,
+		"0.24.1":         HeadscaleConfigLayoutV23,
+		"v0.23.0-alpha2": "",
+		"Unknown":        "",
+	}
+	for version, want := range versions {
+		if got := versionConfigLayout(version); got != want {
+			t.Errorf("version %s: got %q, want %q", version, got, want)
+		}
+	}
+	if got := fileConfigLayout([]byte("acl_policy_path: acl.hujson\ndns_config:\n  magic_dns: true\n")); got != HeadscaleConfigLayoutV22 {
+		t.Errorf("flat file: got %q", got)
+	}
+	if got := fileConfigLayout([]byte("policy:\n  mode: file\n")); got != HeadscaleConfigLayoutV23 {
+		t.Errorf("sections file: got %q", got)
+	}
+}
@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pmezard/go-difflib/difflib"
+	"gorm.io/gorm"
+	"headscale-panel/common"
+	"headscale-panel/config"
+	"headscale-panel/dto"
+	"headscale-panel/log"
+	"headscale-panel/model"
+	"headscale-panel/vo"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// externalEditDelay is how long the config file has to be quiet before an edit outside the panel is recorded, an
+// editor may write the file several times
+const externalEditDelay = time.Second
+
+// externalEditCreator is the creator of the versions which were not written by the panel
+const externalEditCreator = "external edit"
+
+var (
+	externalEditTimer     *time.Timer
+	externalEditTimerLock sync.Mutex
+)
+
+// WatchHeadscaleConfigVersions records the headscale config file as a version when it was changed outside the panel,
+// while the panel was down or later
+func WatchHeadscaleConfigVersions() {
+	if config.GetMode() >= config.MULTI {
+		return
+	}
+	recordExternalConfigEdit()
+	config.OnHeadscaleConfigChange(triggerExternalConfigEdit)
+}
+
+func triggerExternalConfigEdit() {
+	externalEditTimerLock.Lock()
+	defer externalEditTimerLock.Unlock()
+	if externalEditTimer != nil {
+		externalEditTimer.Stop()
+	}
+	externalEditTimer = time.AfterFunc(externalEditDelay, recordExternalConfigEdit)
+}
+
+// recordExternalConfigEdit records the content of the config file if it is not the latest version, the first
+// version is the initial config
+func recordExternalConfigEdit() {
+	// The panel is applying a config, check the file again once it is done, the edit may have been made meanwhile
+	if !configApplyLock.TryLock() {
+		triggerExternalConfigEdit()
+		return
+	}
+	defer configApplyLock.Unlock()
+
+	content, err := os.ReadFile(config.Conf.Headscale.Config)
+	if err != nil {
+		log.Log.Errorf("read headscale config error: %v", err)
+		return
+	}
+	latest := &model.HeadscaleConfigVersion{}
+	err = common.DB.Last(latest).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Log.Errorf("get the latest headscale config version error: %v", err)
+		return
+	}
+	if latest.Content == string(content) {
+		return
+	}
+
+	systemCache.Delete("headscale-config")
+	creator, comment := externalEditCreator, "Changed outside the panel"
+	if latest.ID == 0 {
+		creator, comment = "System", "Initial config"
+	}
+	if _, err = saveConfigVersion(string(content), creator, comment); err != nil {
+		log.Log.Error(err)
+	}
+}
+
+// recordConfigVersion records a content of the config file written by the panel. The config before the first
+// version is recorded as the initial config, so it can be restored.
+func recordConfigVersion(previous, content []byte, creator, comment string) (*model.HeadscaleConfigVersion, error) {
+	var count int64
+	if err := common.DB.Model(&model.HeadscaleConfigVersion{}).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 && len(previous) > 0 {
+		if _, err := saveConfigVersion(string(previous), "System", "Initial config"); err != nil {
+			return nil, err
+		}
+	}
+	return saveConfigVersion(string(content), creator, comment)
+}
+
+func saveConfigVersion(content, creator, comment string) (*model.HeadscaleConfigVersion, error) {
+	version := &model.HeadscaleConfigVersion{Content: content, Creator: creator, Comment: comment}
+	if err := common.DB.Create(version).Error; err != nil {
+		return nil, fmt.Errorf("save headscale config version error: %w", err)
+	}
+	return version, nil
+}
+
+// ListConfigVersions lists the versions of the headscale config without their content, the newest first
+func (s headscaleConfigRepository) ListConfigVersions(req *vo.HeadscaleConfigVersionListRequest) ([]model.HeadscaleConfigVersion, int64, error) {
+	var list []model.HeadscaleConfigVersion
+	db := common.DB.Model(&model.HeadscaleConfigVersion{}).Order("id DESC")
+
+	creator := strings.TrimSpace(req.Creator)
+	if creator != "" {
+		db = db.Where("creator LIKE ?", fmt.Sprintf("%%%s%%", creator))
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return list, total, err
+	}
+	db = db.Select("id", "created_at", "updated_at", "comment", "creator")
+	pageNum := req.PageNum
+	pageSize := req.PageSize
+	var err error
+	if pageNum > 0 && pageSize > 0 {
+		err = db.Offset((pageNum - 1) * pageSize).Limit(pageSize).Find(&list).Error
+	} else {
+		err = db.Find(&list).Error
+	}
+	return list, total, err
+}
+
+func (s headscaleConfigRepository) GetConfigVersion(id uint) (*model.HeadscaleConfigVersion, error) {
+	version := &model.HeadscaleConfigVersion{}
+	if err := common.DB.First(version, id).Error; err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// DiffConfigVersions returns the unified diff from a version to another, 0 is the current content of the config file
+func (s headscaleConfigRepository) DiffConfigVersions(file string, from, to uint) (string, error) {
+	fromName, fromContent, err := s.configVersionContent(file, from)
+	if err != nil {
+		return "", err
+	}
+	toName, toContent, err := s.configVersionContent(file, to)
+	if err != nil {
+		return "", err
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(fromContent),
+		B:        difflib.SplitLines(toContent),
+		FromFile: fromName,
+		ToFile:   toName,
+		Context:  3,
+	})
+}
+
+func (s headscaleConfigRepository) configVersionContent(file string, id uint) (string, string, error) {
+	if id == 0 {
+		content, err := s.GetHeadscaleConfigFromFile(file)
+		return "current", content, err
+	}
+	version, err := s.GetConfigVersion(id)
+	if err != nil {
+		return "", "", fmt.Errorf("get headscale config version %d error: %w", id, err)
+	}
+	return fmt.Sprintf("version %d", id), version.Content, nil
+}
+
+// RestoreConfigVersion applies a previous version of the config again, like any change
+func (s headscaleConfigRepository) RestoreConfigVersion(file string, id uint, creator, comment string) (*dto.HeadscaleConfigJob, error) {
+	version, err := s.GetConfigVersion(id)
+	if err != nil {
+		return nil, fmt.Errorf("get headscale config version %d error: %w", id, err)
+	}
+	restoreComment := fmt.Sprintf("Restore version %d", version.ID)
+	if comment != "" {
+		restoreComment += ": " + comment
+	}
+	return s.ApplyHeadscaleConfig(file, []byte(version.Content), creator, restoreComment)
+}
@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"headscale-panel/common"
+	"headscale-panel/model"
+	"headscale-panel/vo"
+	"sort"
+)
+
+var ErrHeadscaleUserDenied = errors.New("no permission for the headscale user")
+
+type IHeadscaleMappingRepository interface {
+	GetUserMappings(user *model.User) ([]*model.HeadscaleUserMapping, error)                // Get the effective mappings of the user
+	GetExplicitMappings(userID uint) ([]*model.HeadscaleUserMapping, error)                 // Get the mappings stored for the user
+	SetUserMappings(userID uint, items []vo.HeadscaleUserMappingItem, creator string) error // Replace the mappings of the user
+	GetUserAccess(user *model.User, all bool) (*HeadscaleAccess, error)                     // Get the headscale users the user can access
+	RenameHeadscaleUser(oldName, newName string) error                                      // Keep the mappings when a headscale user is renamed
+	DeleteUserMappings(userIDs []uint) error                                                // Delete the mappings of deleted panel users
+}
+
+type HeadscaleMappingRepository struct{}
+
+func NewHeadscaleMappingRepository() IHeadscaleMappingRepository {
+	return HeadscaleMappingRepository{}
+}
+
+// HeadscaleAccess is the headscale users a panel user can access and the permission of each
+type HeadscaleAccess struct {
+	All         bool              // The user can manage the nodes of all users
+	Permissions map[string]string // Headscale user name to permission
+	Default     string            // The headscale user used when the request does not choose one
+}
+
+// CanView returns whether the nodes of the headscale user can be listed
+func (a *HeadscaleAccess) CanView(name string) bool {
+	if a.All {
+		return true
+	}
+	_, ok := a.Permissions[name]
+	return ok
+}
+
+// CanManage returns whether the nodes of the headscale user can be changed
+func (a *HeadscaleAccess) CanManage(name string) bool {
+	return a.All || a.Permissions[name] == model.MappingPermissionManage
+}
+
+// CheckGrant returns an error if the mappings of a user give more than this access. Granting a headscale user needs
+// the manage permission of it, so no permission is higher than the own one. An empty list grants the headscale user
+// with the same name as the user.
+func (a *HeadscaleAccess) CheckGrant(userName string, items []vo.HeadscaleUserMappingItem) error {
+	if len(items) == 0 {
+		items = []vo.HeadscaleUserMappingItem{{HeadscaleUser: userName, Permission: model.MappingPermissionManage}}
+	}
+	for _, item := range items {
+		if !a.CanManage(item.HeadscaleUser) {
+			return fmt.Errorf("%w %s", ErrHeadscaleUserDenied, item.HeadscaleUser)
+		}
+	}
+	return nil
+}
+
+// Users returns the mapped headscale users in order, it is empty if the user can access all users
+func (a *HeadscaleAccess) Users() []string {
+	users := make([]string, 0, len(a.Permissions))
+	for name := range a.Permissions {
+		users = append(users, name)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// Resolve returns the requested headscale user or the default one, it must be manageable
+func (a *HeadscaleAccess) Resolve(name string) (string, error) {
+	if name == "" {
+		name = a.Default
+	}
+	if name == "" {
+		return "", errors.New("please choose a headscale user")
+	}
+	if !a.CanManage(name) {
+		return "", ErrHeadscaleUserDenied
+	}
+	return name, nil
+}
+
+// GetUserMappings returns the stored mappings, or the headscale user with the same name
+// with the manage permission if nothing is stored, which is how the users were bound before the mappings
+func (h HeadscaleMappingRepository) GetUserMappings(user *model.User) ([]*model.HeadscaleUserMapping, error) {
+	mappings, err := h.GetExplicitMappings(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(mappings) == 0 {
+		mappings = append(mappings, &model.HeadscaleUserMapping{
+			UserID:        user.ID,
+			HeadscaleUser: user.Name,
+			Permission:    model.MappingPermissionManage,
+		})
+	}
+	return mappings, nil
+}
+
+func (h HeadscaleMappingRepository) GetExplicitMappings(userID uint) ([]*model.HeadscaleUserMapping, error) {
+	var mappings []*model.HeadscaleUserMapping
+	err := common.DB.Where("user_id = ?", userID).Order("headscale_user").Find(&mappings).Error
+	return mappings, err
+}
+
+func (h HeadscaleMappingRepository) SetUserMappings(userID uint, items []vo.HeadscaleUserMappingItem, creator string) error {
+	seen := make(map[string]bool)
+	for _, item := range items {
+		if seen[item.HeadscaleUser] {
+			return fmt.Errorf("headscale user %s is duplicated", item.HeadscaleUser)
+		}
+		seen[item.HeadscaleUser] = true
+		if _, err := NewUserRepo().GetUserWithString(item.HeadscaleUser); err != nil {
+			return fmt.Errorf("headscale user %s does not exist", item.HeadscaleUser)
+		}
+	}
+	return common.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&model.HeadscaleUserMapping{}).Error; err != nil {
+			return err
+		}
+		for _, item := range items {
+			mapping := &model.HeadscaleUserMapping{
+				UserID:        userID,
+				HeadscaleUser: item.HeadscaleUser,
+				Permission:    item.Permission,
+				Creator:       creator,
+			}
+			if err := tx.Create(mapping).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (h HeadscaleMappingRepository) GetUserAccess(user *model.User, all bool) (*HeadscaleAccess, error) {
+	access := &HeadscaleAccess{All: all, Permissions: make(map[string]string)}
+	mappings, err := h.GetUserMappings(user)
+	if err != nil {
+		return nil, err
+	}
+	var manageable []string
+	for _, mapping := range mappings {
+		access.Permissions[mapping.HeadscaleUser] = mapping.Permission
+		if mapping.Permission == model.MappingPermissionManage {
+			manageable = append(manageable, mapping.HeadscaleUser)
+		}
+	}
+	// The headscale user with the same name is preferred, otherwise the only manageable one
+	if access.CanManage(user.Name) {
+		access.Default = user.Name
+	} else if len(manageable) == 1 {
+		access.Default = manageable[0]
+	}
+	return access, nil
+}
+
+func (h HeadscaleMappingRepository) RenameHeadscaleUser(oldName, newName string) error {
+	return common.DB.Model(&model.HeadscaleUserMapping{}).
+		Where("headscale_user = ?", oldName).
+		Update("headscale_user", newName).Error
+}
+
+func (h HeadscaleMappingRepository) DeleteUserMappings(userIDs []uint) error {
+	return common.DB.Unscoped().Where("user_id IN (?)", userIDs).Delete(&model.HeadscaleUserMapping{}).Error
+}
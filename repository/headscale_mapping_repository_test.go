@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"errors"
+	"headscale-panel/model"
+	"headscale-panel/vo"
+	"testing"
+)
+
+func TestCheckGrant(t *testing.T) {
+	access := &HeadscaleAccess{Permissions: map[string]string{
+		"infra": model.MappingPermissionManage,
+		"dev":   model.MappingPermissionView,
+	}}
+	tests := []struct {
+		name     string
+		userName string
+		items    []vo.HeadscaleUserMappingItem
+		allowed  bool
+	}{
+		{"managed user", "bob", []vo.HeadscaleUserMappingItem{{HeadscaleUser: "infra", Permission: model.MappingPermissionManage}}, true},
+		{"viewed user", "bob", []vo.HeadscaleUserMappingItem{{HeadscaleUser: "dev", Permission: model.MappingPermissionView}}, false},
+		{"unknown user", "bob", []vo.HeadscaleUserMappingItem{{HeadscaleUser: "prod", Permission: model.MappingPermissionView}}, false},
+		{"fallback of a managed user", "infra", nil, true},
+		{"fallback of another user", "bob", nil, false},
+	}
+	for _, tt := range tests {
+		err := access.CheckGrant(tt.userName, tt.items)
+		if tt.allowed && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrHeadscaleUserDenied) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, ErrHeadscaleUserDenied)
+		}
+	}
+
+	all := &HeadscaleAccess{All: true}
+	if err := all.CheckGrant("bob", []vo.HeadscaleUserMappingItem{{HeadscaleUser: "prod", Permission: model.MappingPermissionManage}}); err != nil {
+		t.Errorf("the access to all users should grant any user: %v", err)
+	}
+}
@@ -0,0 +1,284 @@
+package repository
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/go-ldap/ldap/v3"
+	"headscale-panel/common"
+	"headscale-panel/config"
+	"headscale-panel/log"
+	"headscale-panel/model"
+	"net/url"
+	"strings"
+)
+
+// LdapProvider authenticates users by binding to an LDAP or Active Directory server,
+// maps the directory groups to panel roles and creates the users just in time
+type LdapProvider struct {
+	conf *config.LdapConfig
+}
+
+// ldapEntry is the user information found in the directory
+type ldapEntry struct {
+	DN       string
+	Username string
+	Email    string
+	Nickname string
+	Groups   []string // DN and name of the groups the user is a member of
+}
+
+// NewLdapProvider returns nil when LDAP is not enabled in the config file
+func NewLdapProvider() *LdapProvider {
+	conf := config.Conf.Ldap
+	if conf == nil || !conf.Enable {
+		return nil
+	}
+	return newLdapProvider(conf)
+}
+
+func newLdapProvider(conf *config.LdapConfig) *LdapProvider {
+	c := *conf
+	if c.UserFilter == "" {
+		c.UserFilter = "(&(objectClass=person)(uid=%s))"
+	}
+	if c.UsernameAttribute == "" {
+		c.UsernameAttribute = "uid"
+	}
+	if c.EmailAttribute == "" {
+		c.EmailAttribute = "mail"
+	}
+	if c.NicknameAttribute == "" {
+		c.NicknameAttribute = "displayName"
+	}
+	if c.GroupBaseDN == "" {
+		c.GroupBaseDN = c.BaseDN
+	}
+	if c.GroupNameAttribute == "" {
+		c.GroupNameAttribute = "cn"
+	}
+	return &LdapProvider{conf: &c}
+}
+
+func (l *LdapProvider) Name() string {
+	return SourceLdap
+}
+
+// Authenticate searches the user with the service account, binds as the user to verify the password,
+// then creates or updates the panel user with the roles mapped from the directory groups
+func (l *LdapProvider) Authenticate(username, password string) (*model.User, error) {
+	if password == "" {
+		// An empty password is an unauthenticated bind which most servers accept
+		return nil, errors.New("wrong password")
+	}
+	entry, err := l.lookup(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := ProvisionUser(SourceLdap, &ExternalProfile{
+		ExternalID: entry.DN,
+		Username:   strings.ToLower(entry.Username),
+		Email:      entry.Email,
+		Nickname:   entry.Nickname,
+		Roles:      l.mapRoles(entry.Groups),
+		// The emails of the directory are managed by its administrators
+		EmailVerified: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = checkUserStatus(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Sync disables the ldap users which have been removed from the directory
+func (l *LdapProvider) Sync() {
+	var users []*model.User
+	if err := common.DB.Where("source = ? AND status = ?", SourceLdap, 1).Find(&users).Error; err != nil {
+		log.Log.Errorf("ldap sync get users error: %v", err)
+		return
+	}
+	if len(users) == 0 {
+		return
+	}
+
+	conn, err := l.dial()
+	if err != nil {
+		log.Log.Errorf("ldap sync error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	disabled := 0
+	for _, user := range users {
+		entries, err := l.searchUser(conn, user.Name)
+		if err != nil {
+			log.Log.Errorf("ldap sync search user %s error: %v", user.Name, err)
+			continue
+		}
+		if len(entries) > 0 {
+			continue
+		}
+		if err = common.DB.Model(user).Update("status", 2).Error; err != nil {
+			log.Log.Errorf("ldap sync disable user %s error: %v", user.Name, err)
+			continue
+		}
+		user.Status = 2
+		disabled++
+		SetUserRefreshFlag(user)
+		if err = NewSessionRepository().RevokeUserSessions(user.ID, nil, ""); err != nil {
+			log.Log.Errorf("ldap sync revoke sessions of %s error: %v", user.Name, err)
+		}
+		if err = NewOAuthTokenRepository().RevokeUserTokens(user.ID); err != nil {
+			log.Log.Errorf("ldap sync revoke oauth tokens of %s error: %v", user.Name, err)
+		}
+		log.Log.Infof("ldap sync disabled user %s which is removed from the directory", user.Name)
+	}
+	// The disabled users are dropped from the role groups
+	if disabled > 0 {
+		TriggerACLRoleSync()
+	}
+}
+
+// lookup finds the user in the directory and verifies the password
+func (l *LdapProvider) lookup(username, password string) (*ldapEntry, error) {
+	conn, err := l.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	entries, err := l.searchUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+	switch len(entries) {
+	case 0:
+		return nil, ErrUserNotHandled
+	case 1:
+	default:
+		return nil, fmt.Errorf("ldap found %d users named %s", len(entries), username)
+	}
+
+	entry := &ldapEntry{
+		DN:       entries[0].DN,
+		Username: entries[0].GetAttributeValue(l.conf.UsernameAttribute),
+		Email:    entries[0].GetAttributeValue(l.conf.EmailAttribute),
+		Nickname: entries[0].GetAttributeValue(l.conf.NicknameAttribute),
+		Groups:   entries[0].GetAttributeValues("memberOf"),
+	}
+	if entry.Username == "" {
+		entry.Username = username
+	}
+
+	// Verify the password by binding as the user
+	if err = conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, errors.New("wrong password")
+		}
+		return nil, err
+	}
+
+	// Bind as the service account again, the user may not be allowed to search groups
+	if err = l.bindServiceAccount(conn); err != nil {
+		return nil, err
+	}
+	groups, err := l.searchGroups(conn, entry.DN)
+	if err != nil {
+		return nil, err
+	}
+	entry.Groups = append(entry.Groups, groups...)
+	return entry, nil
+}
+
+// dial connects and binds to the directory with the service account
+func (l *LdapProvider) dial() (*ldap.Conn, error) {
+	tlsConfig, err := l.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := ldap.DialURL(l.conf.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("connect ldap server error: %w", err)
+	}
+	if l.conf.StartTLS {
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap start tls error: %w", err)
+		}
+	}
+	if err = l.bindServiceAccount(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// tlsConfig verifies the certificate of the host of the url, StartTLS does not take the host from the connection
+func (l *LdapProvider) tlsConfig() (*tls.Config, error) {
+	u, err := url.Parse(l.conf.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parse ldap url error: %w", err)
+	}
+	return &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: l.conf.InsecureSkipVerify}, nil
+}
+
+func (l *LdapProvider) bindServiceAccount(conn *ldap.Conn) error {
+	var err error
+	if l.conf.BindDN == "" {
+		err = conn.UnauthenticatedBind("")
+	} else {
+		err = conn.Bind(l.conf.BindDN, l.conf.BindPassword)
+	}
+	if err != nil {
+		return fmt.Errorf("ldap bind service account error: %w", err)
+	}
+	return nil
+}
+
+func (l *LdapProvider) searchUser(conn *ldap.Conn, username string) ([]*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(
+		l.conf.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(l.conf.UserFilter, ldap.EscapeFilter(username)),
+		[]string{"dn", l.conf.UsernameAttribute, l.conf.EmailAttribute, l.conf.NicknameAttribute, "memberOf"},
+		nil,
+	)
+	result, err := conn.Search(req)
+	if err != nil {
+		return nil, fmt.Errorf("ldap search user error: %w", err)
+	}
+	return result.Entries, nil
+}
+
+// searchGroups returns the DN and name of the groups which have the user as member
+func (l *LdapProvider) searchGroups(conn *ldap.Conn, userDN string) ([]string, error) {
+	if l.conf.GroupFilter == "" {
+		return nil, nil
+	}
+	req := ldap.NewSearchRequest(
+		l.conf.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf(l.conf.GroupFilter, ldap.EscapeFilter(userDN)),
+		[]string{"dn", l.conf.GroupNameAttribute},
+		nil,
+	)
+	result, err := conn.Search(req)
+	if err != nil {
+		return nil, fmt.Errorf("ldap search groups error: %w", err)
+	}
+	groups := make([]string, 0, len(result.Entries)*2)
+	for _, entry := range result.Entries {
+		groups = append(groups, entry.DN)
+		if name := entry.GetAttributeValue(l.conf.GroupNameAttribute); name != "" {
+			groups = append(groups, name)
+		}
+	}
+	return groups, nil
+}
+
+// mapRoles returns the role keywords mapped from the groups, or the default role if none is mapped
+func (l *LdapProvider) mapRoles(groups []string) []string {
+	return mapGroupsToRoles(l.conf.GroupMappings, groups, l.conf.DefaultRole)
+}
@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"headscale-panel/config"
+	"math/big"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLdapMapRoles(t *testing.T) {
+	l := newLdapProvider(&config.LdapConfig{
+		GroupMappings: []config.GroupMapping{
+			{Group: "admins", Role: "admin"},
+			{Group: "cn=network,ou=groups,dc=example,dc=org", Role: "manager"},
+		},
+		DefaultRole: "user",
+	})
+
+	cases := []struct {
+		groups []string
+		roles  []string
+	}{
+		{groups: []string{"Admins"}, roles: []string{"admin"}},
+		{groups: []string{"CN=network,OU=groups,DC=example,DC=org", "admins"}, roles: []string{"admin", "manager"}},
+		{groups: []string{"others"}, roles: []string{"user"}},
+		{groups: nil, roles: []string{"user"}},
+	}
+	for _, c := range cases {
+		if roles := l.mapRoles(c.groups); !reflect.DeepEqual(roles, c.roles) {
+			t.Errorf("map %v got %v, want %v", c.groups, roles, c.roles)
+		}
+	}
+}
+
+// TestLdapLookup runs against a local LDAP server, see docs/LDAP.md
+func TestLdapLookup(t *testing.T) {
+	url := os.Getenv("LDAP_TEST_URL")
+	if url == "" {
+		t.Skip("LDAP_TEST_URL is not set")
+	}
+	l := newLdapProvider(&config.LdapConfig{
+		URL:          url,
+		BindDN:       os.Getenv("LDAP_TEST_BIND_DN"),
+		BindPassword: os.Getenv("LDAP_TEST_BIND_PASSWORD"),
+		BaseDN:       os.Getenv("LDAP_TEST_BASE_DN"),
+		UserFilter:   "(&(objectClass=inetOrgPerson)(uid=%s))",
+		GroupFilter:  "(&(objectClass=groupOfNames)(member=%s))",
+	})
+	username := os.Getenv("LDAP_TEST_USER")
+	password := os.Getenv("LDAP_TEST_PASSWORD")
+
+	entry, err := l.lookup(username, password)
+	if err != nil {
+		t.Fatalf("lookup %s error: %v", username, err)
+	}
+	if entry.Username != username {
+		t.Errorf("got username %s, want %s", entry.Username, username)
+	}
+	t.Logf("found %s with groups %v", entry.DN, entry.Groups)
+
+	if _, err = l.lookup(username, password+"-wrong"); err == nil {
+		t.Error("lookup with wrong password should fail")
+	}
+	if _, err = l.lookup("not-exist-"+username, password); !errors.Is(err, ErrUserNotHandled) {
+		t.Errorf("lookup not exist user got %v, want ErrUserNotHandled", err)
+	}
+}
+
+// TestLdapStartTLS runs StartTLS against a fake server, the certificate is verified for the host of the url
+func TestLdapStartTLS(t *testing.T) {
+	cert, roots := ldapTestCertificate(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Answer the StartTLS extended request with success, then start TLS
+		request, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		response := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+		response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, request.Children[0].Value, "MessageID"))
+		extended := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationExtendedResponse, nil, "Extended Response")
+		extended.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, 0, "Result Code"))
+		extended.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+		extended.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+		response.AppendChild(extended)
+		if _, err = conn.Write(response.Bytes()); err != nil {
+			return
+		}
+		_ = tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	l := newLdapProvider(&config.LdapConfig{URL: "ldap://localhost:" + port, StartTLS: true})
+	tlsConfig, err := l.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ServerName != "localhost" {
+		t.Fatalf("server name got %q, want localhost", tlsConfig.ServerName)
+	}
+	tlsConfig.RootCAs = roots
+	conn, err := ldap.DialURL(l.conf.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err = conn.StartTLS(tlsConfig); err != nil {
+		t.Fatalf("start tls: %v", err)
+	}
+}
+
+// ldapTestCertificate creates a self-signed certificate for localhost
+func ldapTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(parsed)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, roots
+}
@@ -0,0 +1,290 @@
+package repository
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/thoas/go-funk"
+	"headscale-panel/common"
+	"headscale-panel/model"
+	"headscale-panel/util"
+	"headscale-panel/vo"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const oauthClientSecretPrefix = "hpcs_"
+
+// OAuthSupportedScopes are the scopes the provider can grant
+var OAuthSupportedScopes = []string{"openid", "profile", "email", "groups"}
+
+// OAuthSupportedGrantTypes are the grant types the token endpoint implements
+var OAuthSupportedGrantTypes = []string{model.GrantAuthorizationCode, model.GrantRefreshToken}
+
+var ErrInvalidClient = errors.New("invalid_client")
+
+// RegistrationError is an error of the dynamic client registration (RFC 7591 section 3.2.2)
+type RegistrationError struct {
+	Code        string
+	Description string
+}
+
+func (e *RegistrationError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+type IOAuthClientRepository interface {
+	GetClient(clientID string) (*model.OAuthClient, error)                                             // Get an enabled client, including the built-in headscale client
+	AuthenticateClient(clientID, secret string) (*model.OAuthClient, error)                            // Verify the client credentials
+	ListClients() ([]*model.OAuthClient, error)                                                        // List the clients, the built-in client is the first
+	CreateClient(req *vo.CreateOAuthClientRequest, creator string) (*model.OAuthClient, string, error) // Create a client, the secret is only returned once
+	UpdateClient(id uint, req *vo.UpdateOAuthClientRequest) (*model.OAuthClient, error)                // Update a client
+	RotateSecret(id uint) (string, error)                                                              // Replace the secret of a client
+	DeleteClients(ids []uint) error                                                                    // Delete clients and revoke their tokens
+	RegisterClient(req *vo.RegisterClientRequest) (*model.OAuthClient, string, error)                  // Dynamic client registration (RFC 7591)
+	HasConsent(userID uint, clientID, scope string) bool                                               // Whether the user approved the scopes for the client
+	SaveConsent(userID uint, clientID, scope string) error                                             // Save the approved scopes, they are added to the approved ones
+}
+
+type OAuthClientRepository struct{}
+
+func NewOAuthClientRepository() IOAuthClientRepository {
+	return OAuthClientRepository{}
+}
+
+// builtInClient is the headscale client in the config file, it is not stored in the database
+func builtInClient() *model.OAuthClient {
+	conf := common.GetHeadscaleConfig()
+	client := &model.OAuthClient{
+		ClientID:     conf.OIDC.ClientID,
+		Name:         "headscale",
+		RedirectURIs: conf.OIDC.RedirectURIs,
+		Scopes:       OAuthSupportedScopes,
+		GrantTypes:   OAuthSupportedGrantTypes,
+		Status:       1,
+		BuiltIn:      true,
+	}
+	if len(client.RedirectURIs) == 0 && conf.ServerURL != "" {
+		if callback, err := url.JoinPath(conf.ServerURL, "/oidc/callback"); err == nil {
+			client.RedirectURIs = []string{callback}
+		}
+	}
+	return client
+}
+
+func (o OAuthClientRepository) GetClient(clientID string) (*model.OAuthClient, error) {
+	if clientID == "" {
+		return nil, ErrInvalidClient
+	}
+	if builtIn := builtInClient(); builtIn.ClientID == clientID {
+		return builtIn, nil
+	}
+	var client model.OAuthClient
+	if err := common.DB.Where("client_id = ?", clientID).First(&client).Error; err != nil {
+		return nil, ErrInvalidClient
+	}
+	if client.Status != 1 {
+		return nil, ErrInvalidClient
+	}
+	return &client, nil
+}
+
+func (o OAuthClientRepository) AuthenticateClient(clientID, secret string) (*model.OAuthClient, error) {
+	client, err := o.GetClient(clientID)
+	if err != nil || secret == "" {
+		return nil, ErrInvalidClient
+	}
+	expected, actual := client.SecretHash, util.HashToken(secret)
+	if client.BuiltIn {
+		expected, actual = common.GetHeadscaleConfig().OIDC.ClientSecret, secret
+	}
+	if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) != 1 {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+func (o OAuthClientRepository) ListClients() ([]*model.OAuthClient, error) {
+	var clients []*model.OAuthClient
+	if err := common.DB.Order("created_at DESC").Find(&clients).Error; err != nil {
+		return nil, err
+	}
+	return append([]*model.OAuthClient{builtInClient()}, clients...), nil
+}
+
+func (o OAuthClientRepository) CreateClient(req *vo.CreateOAuthClientRequest, creator string) (*model.OAuthClient, string, error) {
+	return createClient(req, creator, false)
+}
+
+func (o OAuthClientRepository) UpdateClient(id uint, req *vo.UpdateOAuthClientRequest) (*model.OAuthClient, error) {
+	var client model.OAuthClient
+	if err := common.DB.First(&client, id).Error; err != nil {
+		return nil, errors.New("client does not exist")
+	}
+	client.Name = req.Name
+	client.Status = req.Status
+	applyClientRequest(&client, &req.CreateOAuthClientRequest)
+	if err := common.DB.Save(&client).Error; err != nil {
+		return nil, err
+	}
+	if client.Status != 1 {
+		if err := revokeClientTokens([]string{client.ClientID}); err != nil {
+			return nil, err
+		}
+	}
+	return &client, nil
+}
+
+func (o OAuthClientRepository) RotateSecret(id uint) (string, error) {
+	var client model.OAuthClient
+	if err := common.DB.First(&client, id).Error; err != nil {
+		return "", errors.New("client does not exist")
+	}
+	secret, err := setClientSecret(&client)
+	if err != nil {
+		return "", err
+	}
+	return secret, common.DB.Model(&client).Select("secret_hash", "secret_prefix").Updates(&client).Error
+}
+
+func (o OAuthClientRepository) DeleteClients(ids []uint) error {
+	var clientIDs []string
+	if err := common.DB.Model(&model.OAuthClient{}).Where("id IN (?)", ids).Pluck("client_id", &clientIDs).Error; err != nil {
+		return err
+	}
+	if err := common.DB.Unscoped().Where("id IN (?)", ids).Delete(&model.OAuthClient{}).Error; err != nil {
+		return err
+	}
+	if len(clientIDs) > 0 {
+		if err := common.DB.Unscoped().Where("client_id IN (?)", clientIDs).Delete(&model.OAuthConsent{}).Error; err != nil {
+			return err
+		}
+	}
+	return revokeClientTokens(clientIDs)
+}
+
+func (o OAuthClientRepository) RegisterClient(req *vo.RegisterClientRequest) (*model.OAuthClient, string, error) {
+	if len(req.RedirectURIs) == 0 {
+		return nil, "", &RegistrationError{"invalid_redirect_uri", "redirect_uris is required"}
+	}
+	for _, uri := range req.RedirectURIs {
+		if !common.ValidRedirectURI(uri) {
+			return nil, "", &RegistrationError{"invalid_redirect_uri", "invalid redirect uri: " + uri}
+		}
+	}
+	if req.TokenEndpointAuthMethod != "" &&
+		req.TokenEndpointAuthMethod != "client_secret_basic" && req.TokenEndpointAuthMethod != "client_secret_post" {
+		return nil, "", &RegistrationError{"invalid_client_metadata", "unsupported token_endpoint_auth_method"}
+	}
+	for _, responseType := range req.ResponseTypes {
+		if responseType != "code" {
+			return nil, "", &RegistrationError{"invalid_client_metadata", "unsupported response type: " + responseType}
+		}
+	}
+	for _, grantType := range req.GrantTypes {
+		if !funk.ContainsString(OAuthSupportedGrantTypes, grantType) {
+			return nil, "", &RegistrationError{"invalid_client_metadata", "unsupported grant type: " + grantType}
+		}
+	}
+	var scopes []string
+	for _, scope := range strings.Fields(req.Scope) {
+		if !funk.ContainsString(OAuthSupportedScopes, scope) {
+			return nil, "", &RegistrationError{"invalid_client_metadata", "unsupported scope: " + scope}
+		}
+		scopes = append(scopes, scope)
+	}
+
+	name := req.ClientName
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	return createClient(&vo.CreateOAuthClientRequest{
+		Name:         name,
+		RedirectURIs: req.RedirectURIs,
+		Scopes:       scopes,
+		GrantTypes:   req.GrantTypes,
+	}, "registration", true)
+}
+
+func createClient(req *vo.CreateOAuthClientRequest, creator string, dynamic bool) (*model.OAuthClient, string, error) {
+	client := &model.OAuthClient{
+		ClientID: uuid.NewString(),
+		Name:     req.Name,
+		Status:   1,
+		Dynamic:  dynamic,
+		Creator:  creator,
+	}
+	applyClientRequest(client, req)
+	secret, err := setClientSecret(client)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = common.DB.Create(client).Error; err != nil {
+		return nil, "", err
+	}
+	return client, secret, nil
+}
+
+func (o OAuthClientRepository) HasConsent(userID uint, clientID, scope string) bool {
+	var consent model.OAuthConsent
+	if err := common.DB.Where("user_id = ? AND client_id = ?", userID, clientID).First(&consent).Error; err != nil {
+		return false
+	}
+	return consent.Covers(scope)
+}
+
+// AuthorizeScope returns the requested scopes the client is allowed to request, an authorization without openid is
+// not an OpenID Connect request and is rejected
+func AuthorizeScope(client *model.OAuthClient, scope string) (string, error) {
+	scope = client.FilterScope(scope)
+	if !funk.ContainsString(strings.Fields(scope), "openid") {
+		return "", ErrInvalidScope
+	}
+	return scope, nil
+}
+
+func (o OAuthClientRepository) SaveConsent(userID uint, clientID, scope string) error {
+	var consent model.OAuthConsent
+	err := common.DB.Where("user_id = ? AND client_id = ?", userID, clientID).First(&consent).Error
+	if err != nil {
+		consent = model.OAuthConsent{UserID: userID, ClientID: clientID}
+	}
+	consent.Scopes = funk.UniqString(append(consent.Scopes, strings.Fields(scope)...))
+	return common.DB.Save(&consent).Error
+}
+
+// applyClientRequest sets the metadata of the client, the empty lists are all supported values
+func applyClientRequest(client *model.OAuthClient, req *vo.CreateOAuthClientRequest) {
+	client.RedirectURIs = req.RedirectURIs
+	client.Scopes = req.Scopes
+	if len(client.Scopes) == 0 {
+		client.Scopes = OAuthSupportedScopes
+	}
+	client.GrantTypes = req.GrantTypes
+	if len(client.GrantTypes) == 0 {
+		client.GrantTypes = OAuthSupportedGrantTypes
+	}
+	// A registered client is never trusted
+	client.FirstParty = req.FirstParty && !client.Dynamic
+}
+
+func setClientSecret(client *model.OAuthClient) (string, error) {
+	secret, err := util.GenToken(oauthClientSecretPrefix)
+	if err != nil {
+		return "", fmt.Errorf("generate client secret error: %w", err)
+	}
+	client.SecretHash = util.HashToken(secret)
+	client.SecretPrefix = secret[:12]
+	return secret, nil
+}
+
+func revokeClientTokens(clientIDs []string) error {
+	if len(clientIDs) == 0 {
+		return nil
+	}
+	return common.DB.Model(&model.OAuthToken{}).
+		Where("client_id IN (?) AND revoked_at IS NULL", clientIDs).
+		UpdateColumn("revoked_at", time.Now()).Error
+}
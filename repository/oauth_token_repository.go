@@ -0,0 +1,241 @@
+package repository
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/thoas/go-funk"
+	"gorm.io/gorm"
+	"headscale-panel/common"
+	"headscale-panel/config"
+	"headscale-panel/log"
+	"headscale-panel/model"
+	"headscale-panel/util"
+	"strings"
+	"time"
+)
+
+const (
+	oauthAccessTokenPrefix  = "hpoa_"
+	oauthRefreshTokenPrefix = "hpor_"
+)
+
+// ErrInvalidGrant is returned for an unknown, expired, revoked or reused token (RFC 6749 section 5.2)
+var ErrInvalidGrant = errors.New("invalid_grant")
+
+// ErrInvalidScope is returned if the refresh requests a scope which is not granted
+var ErrInvalidScope = errors.New("invalid_scope")
+
+// OAuthTokens is the result of issuing tokens
+type OAuthTokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int64 // Lifetime of the access token in seconds
+	Scope        string
+	ClientID     string
+	User         *model.User
+}
+
+type IOAuthTokenRepository interface {
+	IssueTokens(user *model.User, clientID, scope string) (*OAuthTokens, error)     // Issue the tokens of a new authorization
+	RefreshTokens(refreshToken, clientID, scope string) (*OAuthTokens, error)       // Rotate the refresh token and issue a new access token
+	VerifyAccessToken(accessToken string) (*model.User, *model.OAuthToken, error)   // Verify an access token and return its user
+	IntrospectToken(token, clientID string) (*model.User, *model.OAuthToken, error) // Get an active token of the client (RFC 7662)
+	RevokeToken(token, clientID string) error                                       // Revoke a token of the client (RFC 7009)
+	RevokeUserTokens(userID uint) error                                             // Revoke all tokens of the user
+	CleanExpiredTokens()                                                            // Delete the expired tokens
+}
+
+type OAuthTokenRepository struct{}
+
+func NewOAuthTokenRepository() IOAuthTokenRepository {
+	return OAuthTokenRepository{}
+}
+
+func (o OAuthTokenRepository) IssueTokens(user *model.User, clientID, scope string) (*OAuthTokens, error) {
+	return issueOAuthTokens(common.DB, user, clientID, scope, uuid.NewString())
+}
+
+// RefreshTokens implements the refresh token rotation, a refresh token can only be used once.
+// If a used refresh token is presented again, it may have been stolen, so all tokens of its family are revoked.
+func (o OAuthTokenRepository) RefreshTokens(refreshToken, clientID, scope string) (*OAuthTokens, error) {
+	var tokens *OAuthTokens
+	err := common.DB.Transaction(func(tx *gorm.DB) error {
+		var old model.OAuthToken
+		err := tx.Where("hash = ? AND type = ?", util.HashToken(refreshToken), model.OAuthRefreshToken).First(&old).Error
+		if err != nil {
+			return ErrInvalidGrant
+		}
+		now := time.Now()
+		if old.ClientID != clientID || old.RevokedAt != nil || old.ExpiresAt.Before(now) {
+			return ErrInvalidGrant
+		}
+		if old.UsedAt != nil {
+			log.Log.Warnf("refresh token of user %d reused, revoking token family %s", old.UserID, old.Family)
+			if err = revokeTokenFamily(tx, old.Family); err != nil {
+				return err
+			}
+			// The revocation is committed, the request still fails
+			tokens = nil
+			return nil
+		}
+
+		// The requested scope must not include any scope not originally granted (RFC 6749 section 6)
+		granted := strings.Fields(old.Scope)
+		for _, s := range strings.Fields(scope) {
+			if !funk.ContainsString(granted, s) {
+				return ErrInvalidScope
+			}
+		}
+		if scope == "" {
+			scope = old.Scope
+		}
+
+		// Mark the token as used, the condition makes two concurrent requests with the same token fail
+		result := tx.Model(&old).Where("used_at IS NULL").UpdateColumn("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidGrant
+		}
+
+		user, err := NewUserRepository().GetUserById(old.UserID)
+		if err != nil {
+			return ErrInvalidGrant
+		}
+		if err = checkUserStatus(&user); err != nil {
+			return ErrInvalidGrant
+		}
+		tokens, err = issueOAuthTokens(tx, &user, old.ClientID, scope, old.Family)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tokens == nil {
+		return nil, ErrInvalidGrant
+	}
+	return tokens, nil
+}
+
+func (o OAuthTokenRepository) VerifyAccessToken(accessToken string) (*model.User, *model.OAuthToken, error) {
+	var t model.OAuthToken
+	err := common.DB.Where("hash = ? AND type = ?", util.HashToken(accessToken), model.OAuthAccessToken).First(&t).Error
+	if err != nil {
+		return nil, nil, errors.New("invalid token")
+	}
+	if t.RevokedAt != nil || t.ExpiresAt.Before(time.Now()) {
+		return nil, nil, errors.New("token expired or revoked")
+	}
+	user, err := NewUserRepository().GetUserById(t.UserID)
+	if err != nil {
+		return nil, nil, errors.New("user does not exist")
+	}
+	if err = checkUserStatus(&user); err != nil {
+		return nil, nil, err
+	}
+	return &user, &t, nil
+}
+
+// IntrospectToken returns the token if it is active, a client can only introspect its own tokens
+func (o OAuthTokenRepository) IntrospectToken(token, clientID string) (*model.User, *model.OAuthToken, error) {
+	var t model.OAuthToken
+	if err := common.DB.Where("hash = ?", util.HashToken(token)).First(&t).Error; err != nil {
+		return nil, nil, errors.New("invalid token")
+	}
+	if t.ClientID != clientID {
+		return nil, nil, errors.New("token of another client")
+	}
+	if t.RevokedAt != nil || t.UsedAt != nil || t.ExpiresAt.Before(time.Now()) {
+		return nil, nil, errors.New("token expired or revoked")
+	}
+	user, err := NewUserRepository().GetUserById(t.UserID)
+	if err != nil {
+		return nil, nil, errors.New("user does not exist")
+	}
+	if err = checkUserStatus(&user); err != nil {
+		return nil, nil, err
+	}
+	return &user, &t, nil
+}
+
+// RevokeToken revokes a token of the client. Revoking a refresh token revokes all tokens of its authorization,
+// an unknown token or a token of another client is ignored (RFC 7009 section 2.2)
+func (o OAuthTokenRepository) RevokeToken(token, clientID string) error {
+	var t model.OAuthToken
+	if err := common.DB.Where("hash = ?", util.HashToken(token)).First(&t).Error; err != nil {
+		return nil
+	}
+	if t.ClientID != clientID {
+		log.Log.Warnf("client %s tried to revoke a token of client %s", clientID, t.ClientID)
+		return nil
+	}
+	if t.Type == model.OAuthRefreshToken {
+		return revokeTokenFamily(common.DB, t.Family)
+	}
+	return common.DB.Model(&t).Where("revoked_at IS NULL").UpdateColumn("revoked_at", time.Now()).Error
+}
+
+func (o OAuthTokenRepository) RevokeUserTokens(userID uint) error {
+	return common.DB.Model(&model.OAuthToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		UpdateColumn("revoked_at", time.Now()).Error
+}
+
+func (o OAuthTokenRepository) CleanExpiredTokens() {
+	err := common.DB.Unscoped().Where("expires_at < ?", time.Now()).Delete(&model.OAuthToken{}).Error
+	if err != nil {
+		log.Log.Errorf("clean expired oauth tokens error: %v", err)
+	}
+}
+
+// issueOAuthTokens creates an access token and a refresh token of the family
+func issueOAuthTokens(db *gorm.DB, user *model.User, clientID, scope, family string) (*OAuthTokens, error) {
+	accessToken, err := util.GenToken(oauthAccessTokenPrefix)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := util.GenToken(oauthRefreshTokenPrefix)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	accessTTL, refreshTTL := config.Conf.Headscale.OIDC.AccessTokenTTL(), config.Conf.Headscale.OIDC.RefreshTokenTTL()
+	records := []*model.OAuthToken{
+		{
+			Type:      model.OAuthAccessToken,
+			Hash:      util.HashToken(accessToken),
+			Family:    family,
+			UserID:    user.ID,
+			ClientID:  clientID,
+			Scope:     scope,
+			ExpiresAt: now.Add(accessTTL),
+		},
+		{
+			Type:      model.OAuthRefreshToken,
+			Hash:      util.HashToken(refreshToken),
+			Family:    family,
+			UserID:    user.ID,
+			ClientID:  clientID,
+			Scope:     scope,
+			ExpiresAt: now.Add(refreshTTL),
+		},
+	}
+	if err = db.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return &OAuthTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTTL.Seconds()),
+		Scope:        scope,
+		ClientID:     clientID,
+		User:         user,
+	}, nil
+}
+
+func revokeTokenFamily(db *gorm.DB, family string) error {
+	return db.Model(&model.OAuthToken{}).
+		Where("family = ? AND revoked_at IS NULL", family).
+		UpdateColumn("revoked_at", time.Now()).Error
+}
@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/thoas/go-funk"
+	"headscale-panel/common"
+	"headscale-panel/config"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	OIDCCheckOK      = "ok"
+	OIDCCheckWarning = "warning"
+	OIDCCheckError   = "error"
+)
+
+// OIDCCheck is the result of a check of the provider configuration
+type OIDCCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// SelfCheck validates the provider as headscale uses it: the issuer in the headscale config, the discovery document
+// served at the issuer, the JWKS, the client and the signing key
+func (o *oidc) SelfCheck() []*OIDCCheck {
+	conf := common.GetHeadscaleConfig()
+	checks := []*OIDCCheck{o.checkIssuer(conf.OIDC.Issuer)}
+
+	// The issuer of headscale is used in the tokens, the panel config should not differ
+	if panelIssuer := config.Conf.Headscale.OIDC.Issuer; panelIssuer != conf.OIDC.Issuer {
+		checks = append(checks, &OIDCCheck{"panel issuer", OIDCCheckWarning,
+			fmt.Sprintf("oidc.issuer of the panel %q differs from headscale's %q", panelIssuer, conf.OIDC.Issuer)})
+	} else {
+		checks = append(checks, &OIDCCheck{"panel issuer", OIDCCheckOK, "the panel and headscale use the same issuer"})
+	}
+
+	checks = append(checks, checkScopes(conf.OIDC.Scope))
+
+	client := builtInClient()
+	switch {
+	case client.ClientID == "" || conf.OIDC.ClientSecret == "":
+		checks = append(checks, &OIDCCheck{"client", OIDCCheckError, "client_id and client_secret of headscale are required"})
+	case len(client.RedirectURIs) == 0:
+		checks = append(checks, &OIDCCheck{"client", OIDCCheckError, "no redirect URI, set server_url of headscale or oidc.redirect_uris"})
+	default:
+		checks = append(checks, &OIDCCheck{"client", OIDCCheckOK, "redirect URIs: " + strings.Join(client.RedirectURIs, ", ")})
+	}
+
+	if key, err := o.keys.GetSigningKey(); err != nil {
+		checks = append(checks, &OIDCCheck{"signing key", OIDCCheckError, err.Error()})
+	} else {
+		checks = append(checks, &OIDCCheck{"signing key", OIDCCheckOK, fmt.Sprintf("%s key %s", key.Method.Alg(), key.Kid)})
+	}
+
+	return append(checks, o.checkDiscovery(conf.OIDC.Issuer)...)
+}
+
+func (o *oidc) checkIssuer(issuer string) *OIDCCheck {
+	if issuer == "" {
+		return &OIDCCheck{"issuer", OIDCCheckError, "oidc.issuer of headscale is empty"}
+	}
+	u, err := url.Parse(issuer)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return &OIDCCheck{"issuer", OIDCCheckError, "the issuer must be an absolute URL"}
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return &OIDCCheck{"issuer", OIDCCheckError, "the issuer must not have a query or a fragment"}
+	}
+	// The issuer of the discovery document must be the same string, a trailing slash fails the verification
+	if strings.HasSuffix(issuer, "/") {
+		return &OIDCCheck{"issuer", OIDCCheckError, "the issuer must not end with a slash"}
+	}
+	if u.Scheme != "https" && u.Hostname() != "localhost" && u.Hostname() != "127.0.0.1" {
+		return &OIDCCheck{"issuer", OIDCCheckWarning, "the issuer should use https"}
+	}
+	return &OIDCCheck{"issuer", OIDCCheckOK, issuer}
+}
+
+func checkScopes(scopes []string) *OIDCCheck {
+	if len(scopes) == 0 {
+		return &OIDCCheck{"scopes", OIDCCheckOK, "headscale requests the default scopes openid, profile and email"}
+	}
+	if !funk.ContainsString(scopes, "openid") {
+		return &OIDCCheck{"scopes", OIDCCheckError, "the scope of headscale must include openid"}
+	}
+	for _, scope := range scopes {
+		if !funk.ContainsString(OAuthSupportedScopes, scope) {
+			return &OIDCCheck{"scopes", OIDCCheckWarning, "unsupported scope " + scope + " is ignored"}
+		}
+	}
+	return &OIDCCheck{"scopes", OIDCCheckOK, strings.Join(scopes, " ")}
+}
+
+// checkDiscovery fetches the discovery document and the JWKS like headscale at startup
+func (o *oidc) checkDiscovery(issuer string) []*OIDCCheck {
+	client := &http.Client{Timeout: 5 * time.Second}
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JwksURI string `json:"jwks_uri"`
+	}
+	wellKnown := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(client, wellKnown, &discovery); err != nil {
+		return []*OIDCCheck{{"discovery", OIDCCheckError, err.Error()}}
+	}
+	checks := []*OIDCCheck{{"discovery", OIDCCheckOK, wellKnown}}
+	if discovery.Issuer != issuer {
+		checks[0] = &OIDCCheck{"discovery", OIDCCheckError,
+			fmt.Sprintf("the discovery document has issuer %q, headscale expects %q", discovery.Issuer, issuer)}
+	}
+
+	var jwks struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := getJSON(client, discovery.JwksURI, &jwks); err != nil {
+		return append(checks, &OIDCCheck{"jwks", OIDCCheckError, err.Error()})
+	}
+	if len(jwks.Keys) == 0 {
+		return append(checks, &OIDCCheck{"jwks", OIDCCheckError, "the JWKS has no key"})
+	}
+	return append(checks, &OIDCCheck{"jwks", OIDCCheckOK, fmt.Sprintf("%d keys at %s", len(jwks.Keys), discovery.JwksURI)})
+}
+
+func getJSON(client *http.Client, uri string, v interface{}) error {
+	resp, err := client.Get(uri)
+	if err != nil {
+		return fmt.Errorf("get %s error: %w", uri, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %s: status %d", uri, resp.StatusCode)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode %s error: %w", uri, err)
+	}
+	return nil
+}
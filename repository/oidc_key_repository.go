@@ -0,0 +1,218 @@
+package repository
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/aidarkhanov/nanoid"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/golang-jwt/jwt/v4"
+	"headscale-panel/common"
+	"headscale-panel/config"
+	"headscale-panel/log"
+	"headscale-panel/model"
+	"sync"
+	"time"
+)
+
+// signingKeyOverlap is how long a new key is published before it signs tokens, so the clients caching the JWKS get it
+const signingKeyOverlap = 24 * time.Hour
+
+var (
+	// parsedSigningKeys caches the parsed private keys by kid
+	parsedSigningKeys sync.Map
+	// firstKeyLock makes the concurrent first requests create a single key
+	firstKeyLock sync.Mutex
+)
+
+// SigningKey is a parsed signing key
+type SigningKey struct {
+	Kid    string
+	Method jwt.SigningMethod
+	Signer crypto.Signer
+}
+
+type ISigningKeyRepository interface {
+	GetSigningKey() (*SigningKey, error)       // Get the key which signs the tokens, a key is created at the first use
+	GetPublicKeys() ([]jose.JSONWebKey, error) // Get the published public keys
+	Sign(claims jwt.Claims) (string, error)    // Sign the claims with the current key
+	RotateKeys()                               // Publish the next key, activate it and remove the expired keys
+}
+
+type SigningKeyRepository struct{}
+
+func NewSigningKeyRepository() ISigningKeyRepository {
+	return SigningKeyRepository{}
+}
+
+func (s SigningKeyRepository) GetSigningKey() (*SigningKey, error) {
+	key, err := activeSigningKey()
+	if err == nil {
+		return parseSigningKey(key)
+	}
+	// The first start, the key is used at once. Another request may have created it while this one waited.
+	firstKeyLock.Lock()
+	defer firstKeyLock.Unlock()
+	if key, err = activeSigningKey(); err == nil {
+		return parseSigningKey(key)
+	}
+	var count int64
+	if err = common.DB.Model(&model.OIDCSigningKey{}).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("no active signing key")
+	}
+	return s.createKey(time.Now())
+}
+
+func activeSigningKey() (*model.OIDCSigningKey, error) {
+	key := &model.OIDCSigningKey{}
+	err := common.DB.Where("active_at <= ?", time.Now()).Order("active_at DESC").First(key).Error
+	return key, err
+}
+
+func (s SigningKeyRepository) GetPublicKeys() ([]jose.JSONWebKey, error) {
+	var keys []*model.OIDCSigningKey
+	if err := common.DB.Order("active_at DESC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	jwks := make([]jose.JSONWebKey, 0, len(keys))
+	for _, key := range keys {
+		parsed, err := parseSigningKey(key)
+		if err != nil {
+			log.Log.Errorf("parse oidc signing key %s error: %v", key.Kid, err)
+			continue
+		}
+		jwks = append(jwks, jose.JSONWebKey{
+			Key:       parsed.Signer.Public(),
+			KeyID:     parsed.Kid,
+			Algorithm: parsed.Method.Alg(),
+			Use:       "sig",
+		})
+	}
+	return jwks, nil
+}
+
+func (s SigningKeyRepository) Sign(claims jwt.Claims) (string, error) {
+	key, err := s.GetSigningKey()
+	if err != nil {
+		return "", fmt.Errorf("get signing key error: %w", err)
+	}
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.Kid
+	return token.SignedString(key.Signer)
+}
+
+// RotateKeys runs periodically. A new key is published signingKeyOverlap before the current key reaches the rotation
+// period, or at once if the configured algorithm changed. A replaced key is published until the ID tokens it signed expire.
+func (s SigningKeyRepository) RotateKeys() {
+	current, err := s.GetSigningKey()
+	if err != nil {
+		log.Log.Errorf("get oidc signing key error: %v", err)
+		return
+	}
+	now := time.Now()
+	conf := config.Conf.Headscale.OIDC
+
+	var currentKey model.OIDCSigningKey
+	if err = common.DB.Where("kid = ?", current.Kid).First(&currentKey).Error; err != nil {
+		log.Log.Errorf("get oidc signing key error: %v", err)
+		return
+	}
+	var next int64
+	if err = common.DB.Model(&model.OIDCSigningKey{}).Where("active_at > ?", now).Count(&next).Error; err != nil {
+		log.Log.Errorf("get next oidc signing key error: %v", err)
+		return
+	}
+	due := now.Sub(currentKey.ActiveAt) >= conf.KeyRotationPeriod()-signingKeyOverlap
+	if next == 0 && (due || current.Method.Alg() != conf.SigningAlg()) {
+		if _, err = s.createKey(now.Add(signingKeyOverlap)); err != nil {
+			log.Log.Errorf("create oidc signing key error: %v", err)
+			return
+		}
+		log.Log.Infof("next oidc signing key published, it signs tokens from %s", now.Add(signingKeyOverlap).Format(time.RFC3339))
+	}
+
+	// The older keys are replaced by the current one
+	err = common.DB.Model(&model.OIDCSigningKey{}).
+		Where("active_at < ? AND retired_at IS NULL", currentKey.ActiveAt).
+		UpdateColumn("retired_at", now).Error
+	if err != nil {
+		log.Log.Errorf("retire oidc signing keys error: %v", err)
+		return
+	}
+	retention := conf.IDTokenTTL()
+	if retention < signingKeyOverlap {
+		retention = signingKeyOverlap
+	}
+	err = common.DB.Unscoped().Where("retired_at < ?", now.Add(-retention)).Delete(&model.OIDCSigningKey{}).Error
+	if err != nil {
+		log.Log.Errorf("delete retired oidc signing keys error: %v", err)
+	}
+}
+
+func (s SigningKeyRepository) createKey(activeAt time.Time) (*SigningKey, error) {
+	alg := config.Conf.Headscale.OIDC.SigningAlg()
+	var signer crypto.Signer
+	var err error
+	switch alg {
+	case "RS256":
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %s", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	key := &model.OIDCSigningKey{
+		Kid:        nanoid.New(),
+		Algorithm:  alg,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		ActiveAt:   activeAt,
+	}
+	if err = common.DB.Create(key).Error; err != nil {
+		return nil, err
+	}
+	return parseSigningKey(key)
+}
+
+func parseSigningKey(key *model.OIDCSigningKey) (*SigningKey, error) {
+	if parsed, ok := parsedSigningKeys.Load(key.Kid); ok {
+		return parsed.(*SigningKey), nil
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, errors.New("failed to decode PEM block containing private key")
+	}
+	privKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	parsed := &SigningKey{Kid: key.Kid}
+	switch k := privKey.(type) {
+	case *rsa.PrivateKey:
+		parsed.Method, parsed.Signer = jwt.SigningMethodRS256, k
+	case *ecdsa.PrivateKey:
+		parsed.Method, parsed.Signer = jwt.SigningMethodES256, k
+	default:
+		return nil, errors.New("unsupported private key type")
+	}
+	if parsed.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("the key is not a %s key", key.Algorithm)
+	}
+	parsedSigningKeys.Store(key.Kid, parsed)
+	return parsed, nil
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt/v4"
+	"headscale-panel/model"
+	"testing"
+)
+
+func TestParseSigningKey(t *testing.T) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &model.OIDCSigningKey{
+		Kid:        "test-es256",
+		Algorithm:  "ES256",
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	}
+
+	parsed, err := parseSigningKey(key)
+	if err != nil {
+		t.Fatalf("parse signing key error: %v", err)
+	}
+	if parsed.Method.Alg() != "ES256" {
+		t.Errorf("got algorithm %s, want ES256", parsed.Method.Alg())
+	}
+	signed, err := jwt.NewWithClaims(parsed.Method, jwt.MapClaims{"sub": "1"}).SignedString(parsed.Signer)
+	if err != nil {
+		t.Fatalf("sign error: %v", err)
+	}
+	if _, err = jwt.Parse(signed, func(*jwt.Token) (interface{}, error) { return parsed.Signer.Public(), nil }); err != nil {
+		t.Errorf("verify error: %v", err)
+	}
+
+	// The algorithm of the record must match the key
+	if _, err = parseSigningKey(&model.OIDCSigningKey{Kid: "test-mismatch", Algorithm: "RS256", PrivateKey: key.PrivateKey}); err == nil {
+		t.Error("an EC key should not be parsed as an RS256 key")
+	}
+}
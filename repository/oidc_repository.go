@@ -1,109 +1,218 @@
 package repository
 
 import (
-	"fmt"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"github.com/aidarkhanov/nanoid"
 	"github.com/go-jose/go-jose/v3"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/patrickmn/go-cache"
+	"github.com/thoas/go-funk"
 	"headscale-panel/common"
 	"headscale-panel/config"
 	"headscale-panel/log"
 	"headscale-panel/model"
-	"headscale-panel/util"
+	"headscale-panel/vo"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 var oidcCache *cache.Cache
-var privateKey any
-var publicKey any
-var key []jose.JSONWebKey
-var token = jwt.New(jwt.SigningMethodRS256)
+var codeMutex sync.Mutex
 var oidcConfig *model.OIDC
 
+// OIDCSupportedClaims are the claims the ID token and the userinfo response can have
+var OIDCSupportedClaims = []string{
+	"iss", "sub", "aud", "exp", "iat", "nonce", "at_hash",
+	"name", "preferred_username", "nickname", "picture", "email", "email_verified", "groups",
+}
+
+// AuthorizationCode is the authorization request a code is issued for
+type AuthorizationCode struct {
+	User                *model.User
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	Nonce               string // Returned in the ID token (OpenID Connect Core section 3.1.2.1)
+	CodeChallenge       string // PKCE (RFC 7636), only S256 is supported
+	CodeChallengeMethod string
+}
+
+// PendingAuthorization is an authorization request waiting for the consent of the user
+type PendingAuthorization struct {
+	Request   vo.Authorize
+	User      *model.User
+	SessionID string // The consent is only accepted from the same login session
+}
+
 type OIDC interface {
-	GenerateCode(user *model.User, redirectURL string) string
-	VerifyCode(code, redirectURL string) (interface{}, bool)
-	GetAccessTokenAndRefreshToken(user interface{}) (string, string, error)
-	GetIDToken(user interface{}, accessToken string) (string, error)
-	GetJsonWebKeys() []jose.JSONWebKey
+	GenerateCode(authCode *AuthorizationCode) string
+	VerifyCode(code, clientID, redirectURI, codeVerifier string) (*AuthorizationCode, error)
+	GetIDToken(tokens *OAuthTokens, nonce string) (string, error)
+	UserClaims(user *model.User, scope string) jwt.MapClaims
+	GetJsonWebKeys() ([]jose.JSONWebKey, error)
 	GetClientId() string
+	Endpoint(path string) (string, error)
+	GetDiscovery() (map[string]interface{}, error)
+	SelfCheck() []*OIDCCheck
+	SavePendingAuthorization(pending *PendingAuthorization) string
+	GetPendingAuthorization(id string) (*PendingAuthorization, bool)
+	TakePendingAuthorization(id string) (*PendingAuthorization, bool)
 }
 
-type oidc struct{}
+type oidc struct {
+	keys     ISigningKeyRepository
+	basePath string // Path of the OIDC routes in the router
+}
 
-func NewOIDC() OIDC {
-	o := &oidc{}
-	key = append(key, jose.JSONWebKey{Key: config.Conf.System.PublicKey})
+// NewOIDC creates the provider of the OIDC routes registered at the base path
+func NewOIDC(basePath string) OIDC {
+	o := &oidc{keys: NewSigningKeyRepository(), basePath: basePath}
 	oidcConfig = &common.GetHeadscaleConfig().OIDC
 	oidcCache = cache.New(5*time.Minute, 10*time.Minute)
 	return o
 }
 
-func (o *oidc) GenerateCode(user *model.User, redirectURL string) string {
+func (o *oidc) GenerateCode(authCode *AuthorizationCode) string {
 	code := nanoid.New()
-	oidcCache.Set(code+redirectURL, user, cache.DefaultExpiration)
+	oidcCache.Set(code, authCode, cache.DefaultExpiration)
 	return code
 }
 
-func (o *oidc) VerifyCode(code, redirectURL string) (interface{}, bool) {
-	user, ok := oidcCache.Get(code + redirectURL)
+// VerifyCode checks the code is issued to the client for the redirect URI and the PKCE verifier matches its challenge.
+// A code can only be used once, it is deleted even if the verification fails.
+func (o *oidc) VerifyCode(code, clientID, redirectURI, codeVerifier string) (*AuthorizationCode, error) {
+	codeMutex.Lock()
+	value, ok := oidcCache.Get(code)
 	oidcCache.Delete(code)
-	return user, ok
-}
-
-func (o *oidc) GetUserInfo(code string) (interface{}, error) {
-	user, ok := oidcCache.Get(code)
+	codeMutex.Unlock()
 	if !ok {
-		return nil, fmt.Errorf("user not found")
+		return nil, ErrInvalidGrant
 	}
-	return user, nil
-}
 
-func (o *oidc) GetAccessTokenAndRefreshToken(user interface{}) (string, string, error) {
-	// TODO
-	accessToken := nanoid.New()
-	refreshToken := nanoid.New()
-	return accessToken, refreshToken, nil
+	authCode, ok := value.(*AuthorizationCode)
+	if !ok || authCode.ClientID != clientID || authCode.RedirectURI != redirectURI {
+		return nil, ErrInvalidGrant
+	}
+	if authCode.CodeChallenge == "" {
+		// A verifier without a challenge is an error as well (RFC 7636 section 4.5)
+		if codeVerifier != "" {
+			return nil, ErrInvalidGrant
+		}
+		return authCode, nil
+	}
+	sum := sha256.Sum256([]byte(codeVerifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	if codeVerifier == "" || subtle.ConstantTimeCompare([]byte(challenge), []byte(authCode.CodeChallenge)) != 1 {
+		return nil, ErrInvalidGrant
+	}
+	return authCode, nil
 }
 
-func (o *oidc) GetIDToken(user interface{}, accessToken string) (string, error) {
-	// encode access_token
-	hash, err := util.RSAEncrypt([]byte(accessToken), config.Conf.System.PublicKey)
-	if err != nil {
-		return "", fmt.Errorf("encrypt access_token: %w", err)
+func (o *oidc) GetIDToken(tokens *OAuthTokens, nonce string) (string, error) {
+	now := time.Now()
+	claims := o.UserClaims(tokens.User, tokens.Scope)
+	claims["iss"] = o.getIssuer()
+	claims["aud"] = tokens.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(config.Conf.Headscale.OIDC.IDTokenTTL()).Unix()
+	claims["at_hash"] = accessTokenHash(tokens.AccessToken)
+	if nonce != "" {
+		claims["nonce"] = nonce
 	}
-	u := user.(*model.User)
-	token.Claims = o.setClaims(u, util.EncodeStr2Base64(string(hash[:16])))
-	id_token, err := token.SignedString(config.Conf.System.PrivateKey)
+	idToken, err := o.keys.Sign(claims)
 	if err != nil {
 		log.Log.Error(err)
 	}
-	return id_token, err
+	return idToken, err
 }
 
-func (o *oidc) setClaims(user *model.User, accessToken string) jwt.Claims {
+// UserClaims returns the claims of the user allowed by the scope,
+// they are in the ID token and returned by the userinfo endpoint (OpenID Connect Core section 5.4)
+func (o *oidc) UserClaims(user *model.User, scope string) jwt.MapClaims {
+	// The sub is the user ID, it never changes even if the user is renamed
+	claims := jwt.MapClaims{"sub": strconv.FormatUint(uint64(user.ID), 10)}
+	scopes := strings.Fields(scope)
+	if funk.ContainsString(scopes, "profile") {
+		claims["name"] = user.Name
+		claims["preferred_username"] = user.Name
+		if user.Nickname != "" {
+			claims["nickname"] = user.Nickname
+		}
+		if user.Avatar != "" {
+			claims["picture"] = user.Avatar
+		}
+	}
+	if funk.ContainsString(scopes, "email") && user.Email != "" {
+		claims["email"] = user.Email
+		claims["email_verified"] = config.Conf.Headscale.OIDC.EmailVerified
+	}
+	if funk.ContainsString(scopes, "groups") {
+		claims["groups"] = userGroups(user, config.Conf.Headscale.OIDC.GroupMappings)
+	}
+	return claims
+}
+
+// userGroups returns the groups of the user, a role is its mapped groups or its keyword if it is not mapped
+func userGroups(user *model.User, mappings []config.GroupMapping) []string {
 	groups := []string{}
 	for _, role := range user.Roles {
-		groups = append(groups, role.Keyword)
+		mapped := false
+		for _, m := range mappings {
+			if m.Role == role.Keyword {
+				groups = append(groups, m.Group)
+				mapped = true
+			}
+		}
+		if !mapped {
+			groups = append(groups, role.Keyword)
+		}
 	}
-	return jwt.MapClaims{
-		"sub":                nanoid.New(),
-		"iss":                o.getIssuer(),
-		"aud":                o.GetClientId(),
-		"iat":                time.Now().Unix(),
-		"exp":                time.Now().Add(time.Minute).Unix(),
-		"name":               user.Name,
-		"preferred_username": user.Name,
-		"groups":             groups,
-		// As headscale uses mailboxes to identify users rather than usernames, usernames are used here
-		"email":   user.Name + "@example.com",
-		"at_hash": accessToken,
+	return funk.UniqString(groups)
+}
+
+// accessTokenHash is the at_hash claim, the left half of the sha256 of the access token (OpenID Connect Core section 3.1.3.6),
+// both RS256 and ES256 use sha256
+func accessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+// SavePendingAuthorization keeps the request until the user answers the consent page, the ID is returned
+func (o *oidc) SavePendingAuthorization(pending *PendingAuthorization) string {
+	id := nanoid.New()
+	oidcCache.Set("consent:"+id, pending, cache.DefaultExpiration)
+	return id
+}
+
+func (o *oidc) GetPendingAuthorization(id string) (*PendingAuthorization, bool) {
+	value, ok := oidcCache.Get("consent:" + id)
+	if !ok {
+		return nil, false
 	}
+	pending, ok := value.(*PendingAuthorization)
+	return pending, ok
 }
 
-func (o *oidc) GetJsonWebKeys() []jose.JSONWebKey {
-	return key
+// TakePendingAuthorization returns the pending request and deletes it, the consent can only be answered once
+func (o *oidc) TakePendingAuthorization(id string) (*PendingAuthorization, bool) {
+	codeMutex.Lock()
+	defer codeMutex.Unlock()
+	value, ok := oidcCache.Get("consent:" + id)
+	if !ok {
+		return nil, false
+	}
+	oidcCache.Delete("consent:" + id)
+	pending, ok := value.(*PendingAuthorization)
+	return pending, ok
+}
+
+func (o *oidc) GetJsonWebKeys() ([]jose.JSONWebKey, error) {
+	return o.keys.GetPublicKeys()
 }
 
 func (o *oidc) GetClientId() string {
@@ -117,3 +226,60 @@ func (o *oidc) GetClientSecret() string {
 func (o *oidc) getIssuer() string {
 	return oidcConfig.Issuer
 }
+
+// Endpoint returns the URL of an OIDC route as headscale sees it
+func (o *oidc) Endpoint(path string) (string, error) {
+	return url.JoinPath(o.getIssuer(), o.basePath, path)
+}
+
+// GetDiscovery returns the provider metadata (OpenID Connect Discovery section 3)
+func (o *oidc) GetDiscovery() (map[string]interface{}, error) {
+	endpoints := map[string]string{
+		"authorization_endpoint": "/authorize",
+		"token_endpoint":         "/token",
+		"userinfo_endpoint":      "/user_info",
+		"jwks_uri":               "/jwk",
+		"introspection_endpoint": "/introspect",
+		"revocation_endpoint":    "/revoke",
+	}
+	if config.Conf.Headscale.OIDC.Registration {
+		endpoints["registration_endpoint"] = "/register"
+	}
+
+	// Only the algorithms of the published keys are used to sign the tokens
+	keys, err := o.keys.GetPublicKeys()
+	if err != nil {
+		return nil, err
+	}
+	algorithms := []string{}
+	for _, key := range keys {
+		if !funk.ContainsString(algorithms, key.Algorithm) {
+			algorithms = append(algorithms, key.Algorithm)
+		}
+	}
+
+	authMethods := []string{"client_secret_basic", "client_secret_post"}
+	discovery := map[string]interface{}{
+		"issuer":                                        o.getIssuer(),
+		"scopes_supported":                              OAuthSupportedScopes,
+		"response_types_supported":                      []string{"code"},
+		"response_modes_supported":                      []string{"query"},
+		"grant_types_supported":                         OAuthSupportedGrantTypes,
+		"subject_types_supported":                       []string{"public"},
+		"id_token_signing_alg_values_supported":         algorithms,
+		"token_endpoint_auth_methods_supported":         authMethods,
+		"introspection_endpoint_auth_methods_supported": authMethods,
+		"revocation_endpoint_auth_methods_supported":    authMethods,
+		"claims_supported":                              OIDCSupportedClaims,
+		"code_challenge_methods_supported":              []string{"S256"},
+		"prompt_values_supported":                       []string{"none", "login", "consent", "select_account"},
+		"request_parameter_supported":                   false,
+		"claims_parameter_supported":                    false,
+	}
+	for name, path := range endpoints {
+		if discovery[name], err = o.Endpoint(path); err != nil {
+			return nil, err
+		}
+	}
+	return discovery, nil
+}
@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/patrickmn/go-cache"
+	"headscale-panel/config"
+	"headscale-panel/model"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVerifyCode(t *testing.T) {
+	oidcCache = cache.New(5*time.Minute, 10*time.Minute)
+	o := &oidc{}
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r-wW1gFWFOEjXk"
+	sum := sha256.Sum256([]byte(verifier))
+	authCode := &AuthorizationCode{
+		User:                &model.User{Name: "alice"},
+		ClientID:            "headscale",
+		RedirectURI:         "https://hs.example.org/oidc/callback",
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(sum[:]),
+		CodeChallengeMethod: "S256",
+	}
+
+	code := o.GenerateCode(authCode)
+	if _, err := o.VerifyCode(code, "headscale", authCode.RedirectURI, verifier); err != nil {
+		t.Fatalf("verify code error: %v", err)
+	}
+	if _, err := o.VerifyCode(code, "headscale", authCode.RedirectURI, verifier); err == nil {
+		t.Error("the code should only be used once")
+	}
+
+	tests := []struct {
+		name        string
+		clientID    string
+		redirectURI string
+		verifier    string
+	}{
+		{"other client", "other", authCode.RedirectURI, verifier},
+		{"other redirect uri", "headscale", "https://evil.example.org/callback", verifier},
+		{"wrong verifier", "headscale", authCode.RedirectURI, verifier + "x"},
+		{"missing verifier", "headscale", authCode.RedirectURI, ""},
+	}
+	for _, tt := range tests {
+		code = o.GenerateCode(authCode)
+		if _, err := o.VerifyCode(code, tt.clientID, tt.redirectURI, tt.verifier); err == nil {
+			t.Errorf("%s: the code should be rejected", tt.name)
+		}
+		// A failed verification consumes the code as well
+		if _, err := o.VerifyCode(code, "headscale", authCode.RedirectURI, verifier); err == nil {
+			t.Errorf("%s: the code should be deleted after the failed verification", tt.name)
+		}
+	}
+
+	// Without a challenge, a verifier must not be sent
+	code = o.GenerateCode(&AuthorizationCode{ClientID: "headscale", RedirectURI: authCode.RedirectURI})
+	if _, err := o.VerifyCode(code, "headscale", authCode.RedirectURI, verifier); err == nil {
+		t.Error("a verifier without a challenge should be rejected")
+	}
+}
+
+func TestUserGroups(t *testing.T) {
+	user := &model.User{Roles: []*model.Role{{Keyword: "admin"}, {Keyword: "user"}, {Keyword: "tailnet"}}}
+	mappings := []config.GroupMapping{
+		{Role: "admin", Group: "network-admins"},
+		{Role: "admin", Group: "staff"},
+		{Role: "tailnet", Group: "staff"},
+	}
+	want := []string{"network-admins", "staff", "user"}
+	if got := userGroups(user, mappings); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCheckIssuer(t *testing.T) {
+	o := &oidc{}
+	tests := map[string]string{
+		"":                              OIDCCheckError,
+		"panel.example.org":             OIDCCheckError,
+		"https://panel.example.org/":    OIDCCheckError,
+		"https://panel.example.org?a=b": OIDCCheckError,
+		"http://panel.example.org":      OIDCCheckWarning,
+		"http://localhost:8088":         OIDCCheckOK,
+		"https://panel.example.org/sub": OIDCCheckOK,
+	}
+	for issuer, want := range tests {
+		if got := o.checkIssuer(issuer).Status; got != want {
+			t.Errorf("issuer %q: got %s, want %s", issuer, got, want)
+		}
+	}
+}
+
+func TestAuthorizeScope(t *testing.T) {
+	client := &model.OAuthClient{Scopes: []string{"openid", "email"}}
+	consent := &model.OAuthConsent{Scopes: []string{"openid"}}
+	tests := []struct {
+		scope   string
+		want    string
+		consent bool
+	}{
+		{"", "", false},
+		{"offline_access", "", false},
+		{"email groups", "", false},
+		{"openid groups", "openid", true},
+		{"openid email", "openid email", false},
+	}
+	for _, tt := range tests {
+		got, err := AuthorizeScope(client, tt.scope)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("scope %q: got %q, want invalid_scope", tt.scope, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("scope %q: got %q, %v, want %q", tt.scope, got, err, tt.want)
+		}
+		if covered := consent.Covers(got); covered != tt.consent {
+			t.Errorf("scope %q: consent covers %v, want %v", tt.scope, covered, tt.consent)
+		}
+	}
+	if consent.Covers("") {
+		t.Error("an empty scope should not be approved")
+	}
+}
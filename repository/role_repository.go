@@ -78,6 +78,10 @@ func (r RoleRepository) CreateRole(role *model.Role) error {
 // Update role
 func (r RoleRepository) UpdateRoleById(roleId uint, role *model.Role) error {
 	err := common.DB.Model(&model.Role{}).Where("id = ?", roleId).Updates(role).Error
+	if err == nil {
+		// The keyword and the status change the group of the role
+		TriggerACLRoleSync()
+	}
 	return err
 }
 
@@ -184,6 +188,7 @@ func (r RoleRepository) BatchDeleteRoleByIds(roleIds []uint) error {
 				}
 			}
 		}
+		TriggerACLRoleSync()
 
 	}
 	return err
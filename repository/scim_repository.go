@@ -0,0 +1,806 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"headscale-panel/common"
+	"headscale-panel/config"
+	"headscale-panel/log"
+	"headscale-panel/model"
+	"headscale-panel/util"
+	"headscale-panel/vo"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScimError is returned to the SCIM client as the error response of RFC 7644 section 3.12
+type ScimError struct {
+	Status   int
+	ScimType string
+	Detail   string
+}
+
+func (e *ScimError) Error() string {
+	return e.Detail
+}
+
+func scimNotFound(resource, id string) error {
+	return &ScimError{Status: http.StatusNotFound, Detail: fmt.Sprintf("%s %s not found", resource, id)}
+}
+
+func scimInvalid(scimType, detail string) error {
+	return &ScimError{Status: http.StatusBadRequest, ScimType: scimType, Detail: detail}
+}
+
+func scimConflict(detail string) error {
+	return &ScimError{Status: http.StatusConflict, ScimType: "uniqueness", Detail: detail}
+}
+
+type IScimRepository interface {
+	CreateToken(req *vo.CreateScimTokenRequest, creator string) (string, *model.ScimToken, error) // Create a bearer token, the token is only returned once
+	ListTokens() ([]*model.ScimToken, error)                                                      // List bearer tokens
+	DeleteTokens(ids []uint) error                                                                // Delete bearer tokens
+	VerifyToken(token string) error                                                               // Verify a bearer token and update its last used time
+
+	ListUsers(req *vo.ScimListRequest) ([]*model.User, int64, error)
+	GetUser(id string) (*model.User, error)
+	CreateUser(req *vo.ScimUserRequest) (*model.User, error)
+	ReplaceUser(id string, req *vo.ScimUserRequest) (*model.User, error)
+	PatchUser(id string, req *vo.ScimPatchRequest) (*model.User, error)
+	DeleteUser(id string) error
+
+	ListGroups(req *vo.ScimListRequest) ([]*model.Role, int64, error)
+	GetGroup(id string) (*model.Role, error)
+	CreateGroup(req *vo.ScimGroupRequest) (*model.Role, error)
+	ReplaceGroup(id string, req *vo.ScimGroupRequest) (*model.Role, error)
+	PatchGroup(id string, req *vo.ScimPatchRequest) (*model.Role, error)
+	DeleteGroup(id string) error
+}
+
+type ScimRepository struct{}
+
+func NewScimRepository() IScimRepository {
+	return ScimRepository{}
+}
+
+// Bearer tokens
+
+func (s ScimRepository) CreateToken(req *vo.CreateScimTokenRequest, creator string) (string, *model.ScimToken, error) {
+	token, err := util.GenToken("scim_")
+	if err != nil {
+		return "", nil, err
+	}
+	t := &model.ScimToken{
+		Name:    req.Name,
+		Hash:    util.HashToken(token),
+		Prefix:  token[:12],
+		Creator: creator,
+	}
+	if req.ExpireDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, int(req.ExpireDays))
+		t.ExpiresAt = &expiresAt
+	}
+	if err = common.DB.Create(t).Error; err != nil {
+		return "", nil, err
+	}
+	return token, t, nil
+}
+
+func (s ScimRepository) ListTokens() ([]*model.ScimToken, error) {
+	var tokens []*model.ScimToken
+	err := common.DB.Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+func (s ScimRepository) DeleteTokens(ids []uint) error {
+	return common.DB.Unscoped().Where("id IN (?)", ids).Delete(&model.ScimToken{}).Error
+}
+
+func (s ScimRepository) VerifyToken(token string) error {
+	var t model.ScimToken
+	if err := common.DB.Where("hash = ?", util.HashToken(token)).First(&t).Error; err != nil {
+		return errors.New("invalid token")
+	}
+	now := time.Now()
+	if t.ExpiresAt != nil && t.ExpiresAt.Before(now) {
+		return errors.New("token expired")
+	}
+	return common.DB.Model(&t).UpdateColumn("last_used_at", now).Error
+}
+
+// Users
+
+var scimUserFilters = map[string]string{
+	"username":     "name",
+	"externalid":   "external_id",
+	"emails.value": "email",
+	"emails":       "email",
+}
+
+// ListUsers lists the users provisioned by SCIM, the other users are not managed by the identity provider
+func (s ScimRepository) ListUsers(req *vo.ScimListRequest) ([]*model.User, int64, error) {
+	db := common.DB.Model(&model.User{}).Where("source = ?", SourceScim)
+	db, err := scimFilter(db, req.Filter, scimUserFilters)
+	if err != nil {
+		return nil, 0, err
+	}
+	var total int64
+	if err = db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var users []*model.User
+	err = scimPage(db, req).Order("id").Preload("Roles").Find(&users).Error
+	return users, total, err
+}
+
+func (s ScimRepository) GetUser(id string) (*model.User, error) {
+	return scimUserById(id)
+}
+
+// scimUserById loads a user provisioned by SCIM, the local, LDAP and OIDC users are not found, so a SCIM token can
+// never change or delete them
+func scimUserById(id string) (*model.User, error) {
+	var user model.User
+	if err := common.DB.Where("id = ? AND source = ?", id, SourceScim).Preload("Roles").First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, scimNotFound("User", id)
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s ScimRepository) CreateUser(req *vo.ScimUserRequest) (*model.User, error) {
+	name := strings.ToLower(req.UserName)
+	var count int64
+	if err := common.DB.Model(&model.User{}).Where("name = ?", name).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, scimConflict(fmt.Sprintf("user %s already exists", name))
+	}
+
+	user := &model.User{
+		Name:    name,
+		Avatar:  defaultAvatar,
+		Status:  1,
+		Creator: SourceScim,
+		Source:  SourceScim,
+	}
+	applyScimUserRequest(user, req)
+
+	if conf := config.Conf.Scim; conf != nil && conf.DefaultRole != "" {
+		var role model.Role
+		if err := common.DB.Where("keyword = ?", conf.DefaultRole).First(&role).Error; err == nil {
+			user.Roles = []*model.Role{&role}
+		}
+	}
+
+	if err := common.DB.Create(user).Error; err != nil {
+		return nil, err
+	}
+	log.Log.Infof("scim provisioned user %s", user.Name)
+	TriggerACLRoleSync()
+	if err := ensureHeadscaleUser(user.Name); err != nil {
+		log.Log.Errorf("create headscale user %s error: %v", user.Name, err)
+	}
+	return user, nil
+}
+
+func (s ScimRepository) ReplaceUser(id string, req *vo.ScimUserRequest) (*model.User, error) {
+	user, err := scimUserById(id)
+	if err != nil {
+		return nil, err
+	}
+	old := *user
+	user.Name = strings.ToLower(req.UserName)
+	applyScimUserRequest(user, req)
+	if err = saveScimUser(user, &old); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s ScimRepository) PatchUser(id string, req *vo.ScimPatchRequest) (*model.User, error) {
+	user, err := scimUserById(id)
+	if err != nil {
+		return nil, err
+	}
+	old := *user
+	for _, op := range req.Operations {
+		attrs, err := scimPatchAttributes(op)
+		if err != nil {
+			return nil, err
+		}
+		if err = applyScimUserAttributes(user, attrs); err != nil {
+			return nil, err
+		}
+	}
+	if err = saveScimUser(user, &old); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// DeleteUser expires the nodes of the user, then deletes the panel user,
+// the headscale user is kept with its expired nodes
+func (s ScimRepository) DeleteUser(id string) error {
+	user, err := scimUserById(id)
+	if err != nil {
+		return err
+	}
+	if err = expireUserNodes(user.Name); err != nil {
+		return err
+	}
+	if err = common.DB.Select("Roles").Unscoped().Delete(user).Error; err != nil {
+		return err
+	}
+	userInfoCache.Delete(user.Name)
+	if err = NewSessionRepository().RevokeUserSessions(user.ID, nil, ""); err != nil {
+		return err
+	}
+	if err = NewHeadscaleMappingRepository().DeleteUserMappings([]uint{user.ID}); err != nil {
+		return err
+	}
+	if err = NewOAuthTokenRepository().RevokeUserTokens(user.ID); err != nil {
+		return err
+	}
+	log.Log.Infof("scim deleted user %s", user.Name)
+	TriggerACLRoleSync()
+	return nil
+}
+
+// applyScimUserRequest sets the user fields from a full SCIM user resource
+func applyScimUserRequest(user *model.User, req *vo.ScimUserRequest) {
+	user.ExternalID = req.ExternalID
+	user.Nickname = scimNickname(req.DisplayName, req.Name.Formatted, req.Name.GivenName, req.Name.FamilyName)
+	var email string
+	for _, e := range req.Emails {
+		if e.Primary || email == "" {
+			email = e.Value
+		}
+	}
+	if email != "" {
+		user.Email = email
+	}
+	if req.Active != nil {
+		user.Status = scimStatus(*req.Active)
+	}
+	if req.Password != "" {
+		user.Password = util.GenPasswd(req.Password)
+	}
+}
+
+// applyScimUserAttributes sets the user fields from the attributes of a PATCH operation,
+// the attributes which are not stored by the panel are ignored
+func applyScimUserAttributes(user *model.User, attrs map[string]interface{}) error {
+	var given, family string
+	for key, value := range attrs {
+		str, _ := value.(string)
+		switch key {
+		case "username":
+			if len(str) < 2 || len(str) > 63 {
+				return scimInvalid("invalidValue", "userName must be 2 to 63 characters")
+			}
+			user.Name = strings.ToLower(str)
+		case "externalid":
+			user.ExternalID = str
+		case "displayname", "name.formatted":
+			user.Nickname = scimNickname(str, "", "", "")
+		case "name.givenname":
+			given = str
+		case "name.familyname":
+			family = str
+		case "emails.value":
+			user.Email = str
+		case "emails":
+			if emails, ok := value.([]interface{}); ok {
+				for _, item := range emails {
+					if email, ok := item.(map[string]interface{}); ok {
+						if v, _ := email["value"].(string); v != "" && (email["primary"] == true || user.Email == "") {
+							user.Email = v
+						}
+					}
+				}
+			}
+		case "active":
+			active, err := scimBool(value)
+			if err != nil {
+				return err
+			}
+			user.Status = scimStatus(active)
+		case "password":
+			if str != "" {
+				user.Password = util.GenPasswd(str)
+			}
+		}
+	}
+	if _, ok := attrs["displayname"]; !ok && (given != "" || family != "") {
+		user.Nickname = scimNickname("", "", given, family)
+	}
+	return nil
+}
+
+// saveScimUser saves the changed user, renames the headscale user and deprovisions the deactivated user. The panel
+// user is saved first, and saved back if headscale can not rename the user, so they keep the same name.
+func saveScimUser(user, old *model.User) error {
+	if user.Name != old.Name {
+		var count int64
+		if err := common.DB.Model(&model.User{}).Where("name = ? AND id <> ?", user.Name, user.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return scimConflict(fmt.Sprintf("user %s already exists", user.Name))
+		}
+	}
+
+	columns := []string{"name", "email", "nickname", "status", "external_id", "password"}
+	if err := common.DB.Model(user).Select(columns).Updates(user).Error; err != nil {
+		return err
+	}
+	userInfoCache.Delete(old.Name)
+
+	if user.Name != old.Name {
+		if _, err := NewUserRepo().RenameUserWithString(old.Name, user.Name); err != nil {
+			if rollbackErr := common.DB.Model(old).Select(columns).Updates(old).Error; rollbackErr != nil {
+				log.Log.Errorf("save back scim user %s error: %v", old.Name, rollbackErr)
+			}
+			userInfoCache.Delete(user.Name)
+			return fmt.Errorf("rename headscale user %s error: %w", old.Name, err)
+		}
+		if err := NewHeadscaleMappingRepository().RenameHeadscaleUser(old.Name, user.Name); err != nil {
+			return err
+		}
+	}
+	TriggerACLRoleSync()
+
+	if user.Status != 1 && old.Status == 1 {
+		return deprovisionUser(user)
+	}
+	return nil
+}
+
+// deprovisionUser revokes the sessions of the disabled user and expires its nodes
+func deprovisionUser(user *model.User) error {
+	SetUserRefreshFlag(user)
+	if err := NewSessionRepository().RevokeUserSessions(user.ID, nil, ""); err != nil {
+		return err
+	}
+	if err := NewOAuthTokenRepository().RevokeUserTokens(user.ID); err != nil {
+		return err
+	}
+	log.Log.Infof("scim deactivated user %s", user.Name)
+	return expireUserNodes(user.Name)
+}
+
+// expireUserNodes expires all nodes of the headscale user, the nodes must log in again
+func expireUserNodes(name string) error {
+	NodeCache.Delete(name)
+	repo := NewNodesRepo()
+	nodes, err := repo.ListNodesWithUser(name)
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "not found") {
+			return nil
+		}
+		return fmt.Errorf("list nodes of %s error: %w", name, err)
+	}
+	for _, node := range nodes {
+		if _, err = repo.ExpireNodeWithId(node.Id); err != nil {
+			return fmt.Errorf("expire node %s of %s error: %w", node.GivenName, name, err)
+		}
+	}
+	return nil
+}
+
+// Groups, mapped to panel roles
+
+var scimGroupFilters = map[string]string{
+	"displayname": "name",
+}
+
+func (s ScimRepository) ListGroups(req *vo.ScimListRequest) ([]*model.Role, int64, error) {
+	db := common.DB.Model(&model.Role{})
+	db, err := scimFilter(db, req.Filter, scimGroupFilters)
+	if err != nil {
+		return nil, 0, err
+	}
+	var total int64
+	if err = db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var roles []*model.Role
+	err = scimPage(db, req).Order("id").Preload("Users").Find(&roles).Error
+	return roles, total, err
+}
+
+func (s ScimRepository) GetGroup(id string) (*model.Role, error) {
+	var role model.Role
+	if err := common.DB.Where("id = ?", id).Preload("Users").First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, scimNotFound("Group", id)
+		}
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (s ScimRepository) CreateGroup(req *vo.ScimGroupRequest) (*model.Role, error) {
+	keyword := scimKeyword(req.DisplayName)
+	var count int64
+	if err := common.DB.Model(&model.Role{}).Where("name = ? OR keyword = ?", req.DisplayName, keyword).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, scimConflict(fmt.Sprintf("group %s already exists", req.DisplayName))
+	}
+	users, err := scimUsersByIds(scimMemberIds(req))
+	if err != nil {
+		return nil, err
+	}
+
+	desc := "Provisioned by SCIM"
+	role := &model.Role{
+		Name:    req.DisplayName,
+		Keyword: keyword,
+		Desc:    &desc,
+		Home:    "/console",
+		Sort:    999,
+		Status:  1,
+		Creator: SourceScim,
+	}
+	if err = common.DB.Create(role).Error; err != nil {
+		return nil, err
+	}
+	if err = setGroupMembers(role, users); err != nil {
+		return nil, err
+	}
+	log.Log.Infof("scim provisioned group %s", role.Name)
+	return role, nil
+}
+
+func (s ScimRepository) ReplaceGroup(id string, req *vo.ScimGroupRequest) (*model.Role, error) {
+	role, err := scimGroupById(id)
+	if err != nil {
+		return nil, err
+	}
+	users, err := scimUsersByIds(scimMemberIds(req))
+	if err != nil {
+		return nil, err
+	}
+	if err = renameGroup(role, req.DisplayName); err != nil {
+		return nil, err
+	}
+	if err = setGroupMembers(role, users); err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func (s ScimRepository) PatchGroup(id string, req *vo.ScimPatchRequest) (*model.Role, error) {
+	role, err := scimGroupById(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range req.Operations {
+		if err = patchGroup(role, op); err != nil {
+			return nil, err
+		}
+	}
+	return s.GetGroup(id)
+}
+
+// DeleteGroup deletes the role provisioned by SCIM, the built-in and manually created roles can not be deleted
+func (s ScimRepository) DeleteGroup(id string) error {
+	role, err := scimGroupById(id)
+	if err != nil {
+		return err
+	}
+	if err = NewRoleRepository().BatchDeleteRoleByIds([]uint{role.ID}); err != nil {
+		return err
+	}
+	for _, user := range role.Users {
+		userInfoCache.Delete(user.Name)
+	}
+	return nil
+}
+
+// scimGroupById loads a role provisioned by SCIM to change it, the built-in and manually created roles are only read by
+// SCIM, so a SCIM token can not grant them or rename them
+func scimGroupById(id string) (*model.Role, error) {
+	role, err := NewScimRepository().GetGroup(id)
+	if err != nil {
+		return nil, err
+	}
+	if role.Creator != SourceScim {
+		return nil, scimInvalid("mutability", fmt.Sprintf("group %s is not provisioned by SCIM", role.Name))
+	}
+	return role, nil
+}
+
+func patchGroup(role *model.Role, op vo.ScimPatchOperation) error {
+	opName := strings.ToLower(op.Op)
+	path := strings.ToLower(op.Path)
+
+	// Remove one member by the value filter, such as members[value eq "2"]
+	if m := scimMemberPath.FindStringSubmatch(op.Path); m != nil {
+		if opName != "remove" {
+			return scimInvalid("invalidPath", "only remove is supported for "+op.Path)
+		}
+		return removeGroupMembers(role, []string{m[1]})
+	}
+
+	attrs, err := scimPatchAttributes(op)
+	if err != nil {
+		return err
+	}
+	if name, ok := attrs["displayname"].(string); ok {
+		if err = renameGroup(role, name); err != nil {
+			return err
+		}
+	}
+	value, ok := attrs["members"]
+	if !ok {
+		if path == "members" && opName == "remove" {
+			// Remove all members
+			return replaceGroupMembers(role, nil)
+		}
+		return nil
+	}
+	ids := make([]string, 0)
+	if items, ok := value.([]interface{}); ok {
+		for _, item := range items {
+			if member, ok := item.(map[string]interface{}); ok {
+				if v, ok := member["value"].(string); ok {
+					ids = append(ids, v)
+				}
+			}
+		}
+	}
+	switch opName {
+	case "add":
+		return addGroupMembers(role, ids)
+	case "remove":
+		return removeGroupMembers(role, ids)
+	case "replace":
+		return replaceGroupMembers(role, ids)
+	}
+	return scimInvalid("invalidSyntax", "unsupported op "+op.Op)
+}
+
+func renameGroup(role *model.Role, name string) error {
+	if name == "" || name == role.Name {
+		return nil
+	}
+	if len(name) > 20 {
+		return scimInvalid("invalidValue", "displayName must be at most 20 characters")
+	}
+	var count int64
+	if err := common.DB.Model(&model.Role{}).Where("name = ? AND id <> ?", name, role.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return scimConflict(fmt.Sprintf("group %s already exists", name))
+	}
+	role.Name = name
+	return common.DB.Model(role).Update("name", name).Error
+}
+
+func addGroupMembers(role *model.Role, ids []string) error {
+	users, err := scimUsersByIds(ids)
+	if err != nil || len(users) == 0 {
+		return err
+	}
+	if err = common.DB.Model(role).Association("Users").Append(users); err != nil {
+		return err
+	}
+	refreshMembers(users)
+	return nil
+}
+
+func removeGroupMembers(role *model.Role, ids []string) error {
+	users, err := scimUsersByIds(ids)
+	if err != nil || len(users) == 0 {
+		return err
+	}
+	if err = common.DB.Model(role).Association("Users").Delete(users); err != nil {
+		return err
+	}
+	refreshMembers(users)
+	return nil
+}
+
+func replaceGroupMembers(role *model.Role, ids []string) error {
+	users, err := scimUsersByIds(ids)
+	if err != nil {
+		return err
+	}
+	return setGroupMembers(role, users)
+}
+
+func setGroupMembers(role *model.Role, users []*model.User) error {
+	old := role.Users
+	if err := common.DB.Model(role).Association("Users").Replace(users); err != nil {
+		return err
+	}
+	role.Users = users
+	refreshMembers(old)
+	refreshMembers(users)
+	return nil
+}
+
+// refreshMembers deletes the cached users, so their roles are reloaded at the next request, and syncs the role groups
+func refreshMembers(users []*model.User) {
+	for _, user := range users {
+		userInfoCache.Delete(user.Name)
+	}
+	TriggerACLRoleSync()
+}
+
+// scimUsersByIds loads the members of a group, every member must be a user provisioned by SCIM
+func scimUsersByIds(ids []string) ([]*model.User, error) {
+	users := make([]*model.User, 0)
+	if len(ids) == 0 {
+		return users, nil
+	}
+	if err := common.DB.Where("id IN (?) AND source = ?", ids, SourceScim).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool, len(users))
+	for _, user := range users {
+		found[strconv.FormatUint(uint64(user.ID), 10)] = true
+	}
+	for _, id := range ids {
+		if !found[id] {
+			return nil, scimInvalid("invalidValue", fmt.Sprintf("member %s is not a user provisioned by SCIM", id))
+		}
+	}
+	return users, nil
+}
+
+func scimMemberIds(req *vo.ScimGroupRequest) []string {
+	ids := make([]string, 0, len(req.Members))
+	for _, member := range req.Members {
+		ids = append(ids, member.Value)
+	}
+	return ids
+}
+
+// Helpers
+
+var scimFilterExpr = regexp.MustCompile(`(?i)^\s*([\w.]+)(?:\[[^\]]*\])?(\.value)?\s+eq\s+"((?:[^"\\]|\\.)*)"\s*$`)
+var scimMemberPath = regexp.MustCompile(`(?i)^members\[\s*value\s+eq\s+"([^"]*)"\s*\]$`)
+var scimPathFilter = regexp.MustCompile(`\[[^\]]*\]`)
+
+// scimFilter supports the eq filter used by the identity providers to find the existing resources
+func scimFilter(db *gorm.DB, filter string, columns map[string]string) (*gorm.DB, error) {
+	if strings.TrimSpace(filter) == "" {
+		return db, nil
+	}
+	m := scimFilterExpr.FindStringSubmatch(filter)
+	if m == nil {
+		return nil, scimInvalid("invalidFilter", "only the eq filter is supported")
+	}
+	column, ok := columns[strings.ToLower(m[1]+m[2])]
+	if !ok {
+		return nil, scimInvalid("invalidFilter", "unsupported filter attribute "+m[1])
+	}
+	value := strings.ReplaceAll(m[3], `\"`, `"`)
+	return db.Where(fmt.Sprintf("LOWER(%s) = LOWER(?)", column), value), nil
+}
+
+func scimPage(db *gorm.DB, req *vo.ScimListRequest) *gorm.DB {
+	if req.StartIndex < 1 {
+		req.StartIndex = 1
+	}
+	if req.Count <= 0 || req.Count > 200 {
+		req.Count = 100
+	}
+	return db.Offset(req.StartIndex - 1).Limit(req.Count)
+}
+
+// scimPatchAttributes returns the lowercase attribute paths and values of a PATCH operation,
+// the value filters in the path are removed, such as emails[type eq "work"].value
+func scimPatchAttributes(op vo.ScimPatchOperation) (map[string]interface{}, error) {
+	attrs := make(map[string]interface{})
+	var value interface{}
+	if len(op.Value) > 0 {
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, scimInvalid("invalidValue", "invalid patch value")
+		}
+	}
+	switch strings.ToLower(op.Op) {
+	case "add", "replace":
+	case "remove":
+		if op.Path == "" {
+			return nil, scimInvalid("noTarget", "path is required for remove")
+		}
+	default:
+		return nil, scimInvalid("invalidSyntax", "unsupported op "+op.Op)
+	}
+
+	if op.Path != "" {
+		attrs[strings.ToLower(scimPathFilter.ReplaceAllString(op.Path, ""))] = value
+		return attrs, nil
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, scimInvalid("invalidValue", "value must be an object when path is empty")
+	}
+	for key, v := range object {
+		key = strings.ToLower(key)
+		if sub, ok := v.(map[string]interface{}); ok {
+			for subKey, subValue := range sub {
+				attrs[key+"."+strings.ToLower(subKey)] = subValue
+			}
+			continue
+		}
+		attrs[key] = v
+	}
+	return attrs, nil
+}
+
+// scimBool accepts the boolean and the string value sent by some identity providers
+func scimBool(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(v)
+		if err == nil {
+			return b, nil
+		}
+	}
+	return false, scimInvalid("invalidValue", "active must be a boolean")
+}
+
+func scimStatus(active bool) uint {
+	if active {
+		return 1
+	}
+	return 2
+}
+
+// scimNickname returns the display name, the formatted name or the joined given and family name, limited to 20 characters
+func scimNickname(displayName, formatted, givenName, familyName string) string {
+	nickname := displayName
+	if nickname == "" {
+		nickname = formatted
+	}
+	if nickname == "" {
+		nickname = strings.TrimSpace(givenName + " " + familyName)
+	}
+	if r := []rune(nickname); len(r) > 20 {
+		nickname = string(r[:20])
+	}
+	return nickname
+}
+
+// scimKeyword converts the group name to a role keyword. A name without ASCII letters or digits gets a keyword from
+// its hash, the same name always has the same keyword.
+func scimKeyword(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	keyword := strings.Trim(b.String(), "-")
+	if len(keyword) > 20 {
+		keyword = strings.TrimRight(keyword[:20], "-")
+	}
+	if keyword == "" {
+		sum := sha256.Sum256([]byte(name))
+		keyword = "scim-" + hex.EncodeToString(sum[:])[:12]
+	}
+	return keyword
+}
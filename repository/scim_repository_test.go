@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"encoding/json"
+	"headscale-panel/model"
+	"headscale-panel/vo"
+	"strings"
+	"testing"
+)
+
+func TestScimPatchUser(t *testing.T) {
+	user := &model.User{Name: "alice", Email: "alice@example.org", Status: 1}
+	ops := []vo.ScimPatchOperation{
+		// Azure AD style
+		{Op: "Replace", Path: "active", Value: json.RawMessage(`"False"`)},
+		{Op: "replace", Path: `emails[type eq "work"].value`, Value: json.RawMessage(`"alice@example.com"`)},
+		// Okta style without path
+		{Op: "replace", Value: json.RawMessage(`{"userName":"Alice2","name":{"givenName":"Alice","familyName":"Smith"}}`)},
+	}
+	for _, op := range ops {
+		attrs, err := scimPatchAttributes(op)
+		if err != nil {
+			t.Fatalf("patch attributes of %+v error: %v", op, err)
+		}
+		if err = applyScimUserAttributes(user, attrs); err != nil {
+			t.Fatalf("apply %v error: %v", attrs, err)
+		}
+	}
+	if user.Status != 2 || user.Email != "alice@example.com" || user.Name != "alice2" || user.Nickname != "Alice Smith" {
+		t.Errorf("got %+v", user)
+	}
+
+	if _, err := scimPatchAttributes(vo.ScimPatchOperation{Op: "remove"}); err == nil {
+		t.Error("remove without path should fail")
+	}
+	if _, err := scimPatchAttributes(vo.ScimPatchOperation{Op: "move", Path: "active"}); err == nil {
+		t.Error("unsupported op should fail")
+	}
+}
+
+func TestScimKeyword(t *testing.T) {
+	cases := map[string]string{
+		"Network Admins":                   "network-admins",
+		"  VPN/Users ":                     "vpn-users",
+		"a very long group name for roles": "a-very-long-group-na",
+		"administrators of vpn network":    "administrators-of-vp",
+		"engineering team 01 admins":       "engineering-team-01",
+	}
+	for name, want := range cases {
+		if got := scimKeyword(name); got != want {
+			t.Errorf("keyword of %q got %q, want %q", name, got, want)
+		}
+	}
+	// The names without ASCII letters or digits get different keywords from their hash
+	if k1, k2 := scimKeyword("管理员"), scimKeyword("运维"); !strings.HasPrefix(k1, "scim-") || len(k1) > 20 || k1 == k2 || k1 != scimKeyword("管理员") {
+		t.Errorf("keywords got %q and %q", k1, k2)
+	}
+	if m := scimMemberPath.FindStringSubmatch(`members[value eq "12"]`); m == nil || m[1] != "12" {
+		t.Errorf("member path got %v", m)
+	}
+	if m := scimFilterExpr.FindStringSubmatch(`emails[type eq "work"].value eq "a@b.c"`); m == nil || m[1]+m[2] != "emails.value" || m[3] != "a@b.c" {
+		t.Errorf("filter got %v", m)
+	}
+}
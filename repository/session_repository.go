@@ -0,0 +1,206 @@
+package repository
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/patrickmn/go-cache"
+	"headscale-panel/common"
+	"headscale-panel/config"
+	"headscale-panel/log"
+	"headscale-panel/model"
+	"strings"
+	"time"
+)
+
+var ErrSessionRevoked = errors.New("session has been revoked")
+var ErrSessionExpired = errors.New("session has expired")
+
+// ErrSessionMissing is returned for the tokens issued before the sessions were recorded, they have no jti
+var ErrSessionMissing = errors.New("the token has no session, please log in again")
+
+// sessionCache caches the sessions checked by every request, the revoked sessions are deleted from it
+var sessionCache = cache.New(5*time.Minute, 10*time.Minute)
+
+// lastSeenInterval limits the writes of the last seen time
+const lastSeenInterval = time.Minute
+
+type ISessionRepository interface {
+	CreateSession(user *model.User, ip, userAgent string) (*model.Session, error) // Create a session at login
+	CheckSession(tokenID string) error                                            // Check the session is not revoked and update its last seen time
+	RefreshSession(tokenID string) error                                          // Extend the session when the token is refreshed
+	GetUserSessions(userID uint) ([]*model.Session, error)                        // Get the active sessions of the user
+	RevokeSession(tokenID string) error                                           // Revoke a session by the token ID
+	RevokeUserSessions(userID uint, ids []uint, exceptTokenID string) error       // Revoke the sessions of the user, all sessions if ids is empty
+	CleanExpiredSessions()                                                        // Delete the expired sessions
+	SSOToken(tokenID string) string                                               // Get the value of the SSO cookie of the OIDC provider for the session
+	CheckSSOToken(token string) (*model.User, string, error)                      // Get the user and the token ID of the session of the SSO cookie
+}
+
+type SessionRepository struct{}
+
+func NewSessionRepository() ISessionRepository {
+	return SessionRepository{}
+}
+
+// sessionLifetime is the time a token can be used and refreshed
+func sessionLifetime() time.Duration {
+	return time.Hour * time.Duration(config.Conf.Jwt.Timeout+config.Conf.Jwt.MaxRefresh)
+}
+
+func (s SessionRepository) CreateSession(user *model.User, ip, userAgent string) (*model.Session, error) {
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	now := time.Now()
+	session := &model.Session{
+		TokenID:    uuid.NewString(),
+		UserID:     user.ID,
+		Username:   user.Name,
+		Ip:         ip,
+		UserAgent:  userAgent,
+		IssuedAt:   now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(sessionLifetime()),
+	}
+	if err := common.DB.Create(session).Error; err != nil {
+		return nil, err
+	}
+	sessionCache.Set(session.TokenID, *session, cache.DefaultExpiration)
+	return session, nil
+}
+
+func (s SessionRepository) CheckSession(tokenID string) error {
+	if tokenID == "" {
+		return ErrSessionMissing
+	}
+	session, err := getSession(tokenID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if session.RevokedAt != nil {
+		return ErrSessionRevoked
+	}
+	if session.ExpiresAt.Before(now) {
+		return ErrSessionExpired
+	}
+	if now.Sub(session.LastSeenAt) > lastSeenInterval {
+		// The session is read again on the next check, setting the cached copy could undo a revoke made meanwhile
+		err = common.DB.Model(&model.Session{}).
+			Where("token_id = ? AND revoked_at IS NULL", tokenID).
+			UpdateColumn("last_seen_at", now).Error
+		if err != nil {
+			log.Log.Errorf("update session last seen time error: %v", err)
+		}
+		sessionCache.Delete(tokenID)
+	}
+	return nil
+}
+
+func (s SessionRepository) RefreshSession(tokenID string) error {
+	if err := s.CheckSession(tokenID); err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(sessionLifetime())
+	if err := common.DB.Model(&model.Session{}).Where("token_id = ?", tokenID).UpdateColumn("expires_at", expiresAt).Error; err != nil {
+		return err
+	}
+	sessionCache.Delete(tokenID)
+	return nil
+}
+
+func (s SessionRepository) GetUserSessions(userID uint) ([]*model.Session, error) {
+	var sessions []*model.Session
+	err := common.DB.
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+func (s SessionRepository) RevokeSession(tokenID string) error {
+	err := common.DB.Model(&model.Session{}).
+		Where("token_id = ? AND revoked_at IS NULL", tokenID).
+		UpdateColumn("revoked_at", time.Now()).Error
+	sessionCache.Delete(tokenID)
+	return err
+}
+
+func (s SessionRepository) RevokeUserSessions(userID uint, ids []uint, exceptTokenID string) error {
+	db := common.DB.Model(&model.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+	if len(ids) > 0 {
+		db = db.Where("id IN (?)", ids)
+	}
+	if exceptTokenID != "" {
+		db = db.Where("token_id <> ?", exceptTokenID)
+	}
+	var tokenIDs []string
+	if err := db.Pluck("token_id", &tokenIDs).Error; err != nil {
+		return err
+	}
+	if len(tokenIDs) == 0 {
+		return nil
+	}
+	err := common.DB.Model(&model.Session{}).
+		Where("token_id IN (?)", tokenIDs).
+		UpdateColumn("revoked_at", time.Now()).Error
+	for _, tokenID := range tokenIDs {
+		sessionCache.Delete(tokenID)
+	}
+	return err
+}
+
+func (s SessionRepository) CleanExpiredSessions() {
+	err := common.DB.Unscoped().Where("expires_at < ?", time.Now()).Delete(&model.Session{}).Error
+	if err != nil {
+		log.Log.Errorf("clean expired sessions error: %v", err)
+	}
+}
+
+// SSOToken signs the token ID, the SSO cookie can not be forged from another value
+func (s SessionRepository) SSOToken(tokenID string) string {
+	return tokenID + "." + ssoSignature(tokenID)
+}
+
+func (s SessionRepository) CheckSSOToken(token string) (*model.User, string, error) {
+	tokenID, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(ssoSignature(tokenID))) {
+		return nil, "", errors.New("invalid sso token")
+	}
+	if err := s.CheckSession(tokenID); err != nil {
+		return nil, "", err
+	}
+	session, err := getSession(tokenID)
+	if err != nil {
+		return nil, "", err
+	}
+	user, err := NewUserRepository().GetUserById(session.UserID)
+	if err != nil {
+		return nil, "", errors.New("user does not exist")
+	}
+	if err = checkUserStatus(&user); err != nil {
+		return nil, "", err
+	}
+	return &user, tokenID, nil
+}
+
+func ssoSignature(tokenID string) string {
+	mac := hmac.New(sha256.New, []byte(config.Conf.Jwt.Key))
+	mac.Write([]byte("oidc-sso:" + tokenID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func getSession(tokenID string) (model.Session, error) {
+	if v, ok := sessionCache.Get(tokenID); ok {
+		return v.(model.Session), nil
+	}
+	var session model.Session
+	if err := common.DB.Where("token_id = ?", tokenID).First(&session).Error; err != nil {
+		return session, errors.New("session not found")
+	}
+	sessionCache.Set(tokenID, session, cache.DefaultExpiration)
+	return session, nil
+}
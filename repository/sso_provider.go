@@ -0,0 +1,257 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aidarkhanov/nanoid"
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/oauth2"
+	"headscale-panel/config"
+	"headscale-panel/model"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ssoCache stores the login states waiting for the callback and the tickets waiting to be redeemed
+var ssoCache = cache.New(10*time.Minute, 20*time.Minute)
+
+var ssoProvider *SsoProvider
+var ssoLock sync.Mutex
+
+// SsoProvider signs in the panel users with an external OpenID Connect identity provider,
+// such as Keycloak or Authentik, using the authorization code flow with PKCE
+type SsoProvider struct {
+	conf     *config.SsoConfig
+	provider *gooidc.Provider
+	oauth2   *oauth2.Config
+	verifier *gooidc.IDTokenVerifier
+}
+
+// ssoState is saved between the redirection to the identity provider and the callback
+type ssoState struct {
+	Verifier string // PKCE code verifier
+	Nonce    string
+}
+
+// NewSsoProvider returns the provider configured in the config file,
+// the discovery document of the issuer is fetched at the first call
+func NewSsoProvider() (*SsoProvider, error) {
+	conf := config.Conf.Sso
+	if conf == nil || !conf.Enable {
+		return nil, errors.New("sso is not enabled")
+	}
+
+	ssoLock.Lock()
+	defer ssoLock.Unlock()
+	if ssoProvider != nil {
+		return ssoProvider, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	provider, err := gooidc.NewProvider(ctx, conf.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discover sso issuer error: %w", err)
+	}
+
+	c := *conf
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{gooidc.ScopeOpenID, "profile", "email"}
+	}
+	if c.UsernameClaim == "" {
+		c.UsernameClaim = "preferred_username"
+	}
+	if c.EmailClaim == "" {
+		c.EmailClaim = "email"
+	}
+	if c.NicknameClaim == "" {
+		c.NicknameClaim = "name"
+	}
+	if c.GroupsClaim == "" {
+		c.GroupsClaim = "groups"
+	}
+
+	ssoProvider = &SsoProvider{
+		conf:     &c,
+		provider: provider,
+		oauth2: &oauth2.Config{
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  c.RedirectURL,
+			Scopes:       c.Scopes,
+		},
+		verifier: provider.Verifier(&gooidc.Config{ClientID: c.ClientID}),
+	}
+	return ssoProvider, nil
+}
+
+// Name returns the display name of the identity provider
+func (s *SsoProvider) Name() string {
+	return s.conf.Name
+}
+
+// FrontendURL returns the panel page which redeems the login ticket
+func (s *SsoProvider) FrontendURL() string {
+	return s.conf.FrontendURL
+}
+
+// RedirectURL returns the callback url of the panel registered at the identity provider
+func (s *SsoProvider) RedirectURL() string {
+	return s.conf.RedirectURL
+}
+
+// AuthCodeURL returns the authorization url of the identity provider with a new state, nonce and PKCE challenge,
+// and the state, which the browser keeps to prove the callback is for the login it started
+func (s *SsoProvider) AuthCodeURL() (string, string) {
+	state := nanoid.New()
+	st := &ssoState{Verifier: oauth2.GenerateVerifier(), Nonce: nanoid.New()}
+	ssoCache.Set("state:"+state, st, cache.DefaultExpiration)
+	return s.oauth2.AuthCodeURL(state, oauth2.S256ChallengeOption(st.Verifier), gooidc.Nonce(st.Nonce)), state
+}
+
+// Exchange exchanges the authorization code, verifies the id token,
+// then creates or updates the panel user with the roles mapped from the claims
+func (s *SsoProvider) Exchange(ctx context.Context, state, code string) (*model.User, error) {
+	v, ok := ssoCache.Get("state:" + state)
+	if !ok {
+		return nil, errors.New("invalid or expired sso state")
+	}
+	ssoCache.Delete("state:" + state)
+	st := v.(*ssoState)
+
+	token, err := s.oauth2.Exchange(ctx, code, oauth2.VerifierOption(st.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchange sso code error: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("no id_token in the sso token response")
+	}
+	idToken, err := s.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("verify sso id_token error: %w", err)
+	}
+	if idToken.Nonce != st.Nonce {
+		return nil, errors.New("invalid sso nonce")
+	}
+
+	claims := make(map[string]interface{})
+	if err = idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	// Some providers only return the groups and profile from the userinfo endpoint
+	if userInfo, err := s.provider.UserInfo(ctx, oauth2.StaticTokenSource(token)); err == nil {
+		extra := make(map[string]interface{})
+		if userInfo.Claims(&extra) == nil {
+			for k, v := range extra {
+				if _, ok := claims[k]; !ok {
+					claims[k] = v
+				}
+			}
+		}
+	}
+
+	profile, err := s.profile(claims)
+	if err != nil {
+		return nil, err
+	}
+	user, err := ProvisionUser(SourceOidc, profile)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkUserStatus(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// IssueTicket returns a one-time ticket which is redeemed by the frontend for the panel token
+func (s *SsoProvider) IssueTicket(user *model.User) string {
+	ticket := nanoid.New()
+	ssoCache.Set("ticket:"+ticket, user, time.Minute)
+	return ticket
+}
+
+// RedeemTicket returns the user of the ticket, the ticket can only be redeemed once
+func (s *SsoProvider) RedeemTicket(ticket string) (*model.User, error) {
+	v, ok := ssoCache.Get("ticket:" + ticket)
+	if !ok {
+		return nil, errors.New("invalid or expired sso ticket")
+	}
+	ssoCache.Delete("ticket:" + ticket)
+	return v.(*model.User), nil
+}
+
+// profile reads the user information from the claims and checks the email domain
+func (s *SsoProvider) profile(claims map[string]interface{}) (*ExternalProfile, error) {
+	email, _ := claimValue(claims, s.conf.EmailClaim).(string)
+	// A missing email_verified claim is not a verified email
+	verified, _ := claims["email_verified"].(bool)
+	if len(s.conf.AllowedDomains) > 0 {
+		if !verified {
+			return nil, errors.New("the email is not verified")
+		}
+		at := strings.LastIndex(email, "@")
+		if at < 0 || !containsFold(s.conf.AllowedDomains, email[at+1:]) {
+			return nil, fmt.Errorf("the email domain of %q is not allowed", email)
+		}
+	}
+
+	username, _ := claimValue(claims, s.conf.UsernameClaim).(string)
+	if username == "" {
+		username, _, _ = strings.Cut(email, "@")
+	}
+	if username == "" {
+		return nil, fmt.Errorf("no %s claim in the id_token", s.conf.UsernameClaim)
+	}
+	nickname, _ := claimValue(claims, s.conf.NicknameClaim).(string)
+
+	var groups []string
+	switch g := claimValue(claims, s.conf.GroupsClaim).(type) {
+	case string:
+		groups = append(groups, g)
+	case []interface{}:
+		for _, item := range g {
+			if name, ok := item.(string); ok {
+				// Keycloak returns the full path of the group
+				groups = append(groups, name, strings.TrimPrefix(name, "/"))
+			}
+		}
+	}
+
+	subject, _ := claims["sub"].(string)
+	return &ExternalProfile{
+		ExternalID:    subject,
+		Username:      strings.ToLower(username),
+		Email:         email,
+		Nickname:      nickname,
+		Roles:         mapGroupsToRoles(s.conf.GroupMappings, groups, s.conf.DefaultRole),
+		EmailVerified: verified,
+	}, nil
+}
+
+// claimValue returns the claim of the dot separated path, such as realm_access.roles of Keycloak
+func claimValue(claims map[string]interface{}, path string) interface{} {
+	var value interface{} = claims
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"headscale-panel/config"
+	"reflect"
+	"testing"
+)
+
+func TestSsoProfile(t *testing.T) {
+	s := &SsoProvider{conf: &config.SsoConfig{
+		UsernameClaim:  "preferred_username",
+		EmailClaim:     "email",
+		NicknameClaim:  "name",
+		GroupsClaim:    "realm_access.roles",
+		GroupMappings:  []config.GroupMapping{{Group: "network-admins", Role: "admin"}},
+		DefaultRole:    "user",
+		AllowedDomains: []string{"example.org"},
+	}}
+
+	profile, err := s.profile(map[string]interface{}{
+		"preferred_username": "Alice",
+		"email":              "alice@Example.org",
+		"email_verified":     true,
+		"name":               "Alice",
+		"realm_access":       map[string]interface{}{"roles": []interface{}{"/network-admins", "offline_access"}},
+	})
+	if err != nil {
+		t.Fatalf("profile error: %v", err)
+	}
+	want := &ExternalProfile{Username: "alice", Email: "alice@Example.org", Nickname: "Alice", Roles: []string{"admin"}, EmailVerified: true}
+	if !reflect.DeepEqual(profile, want) {
+		t.Errorf("got %+v, want %+v", profile, want)
+	}
+
+	if _, err = s.profile(map[string]interface{}{"preferred_username": "bob", "email": "bob@other.org"}); err == nil {
+		t.Error("the email domain other.org should not be allowed")
+	}
+	if _, err = s.profile(map[string]interface{}{"email": "carol@example.org", "email_verified": false}); err == nil {
+		t.Error("the unverified email should not be allowed")
+	}
+	if _, err = s.profile(map[string]interface{}{"email": "erin@example.org"}); err == nil {
+		t.Error("the email without email_verified should not be allowed")
+	}
+
+	s.conf.AllowedDomains = nil
+	if profile, err = s.profile(map[string]interface{}{"email": "dave@other.org"}); err != nil || profile.Username != "dave" || profile.EmailVerified || !reflect.DeepEqual(profile.Roles, []string{"user"}) {
+		t.Errorf("got %+v, %v, want user dave with the default role", profile, err)
+	}
+}
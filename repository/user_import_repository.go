@@ -0,0 +1,445 @@
+package repository
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	pb "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+	"headscale-panel/common"
+	"headscale-panel/config"
+	"headscale-panel/dto"
+	"headscale-panel/log"
+	"headscale-panel/model"
+	"headscale-panel/util"
+	"headscale-panel/vo"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InviteTokenPrefix is the prefix of the invite tokens of the imported users
+const InviteTokenPrefix = "hpinv_"
+
+// importRoleSeparator separates the role keywords in a CSV cell
+const importRoleSeparator = "|"
+
+var ErrInviteInvalid = errors.New("the invite is invalid or has expired")
+
+type IUserImportRepository interface {
+	ParseImportUsers(format, content string) ([]vo.ImportUser, []int, error)                                                            // Parse the imported file, returns the users and their line numbers
+	ImportUsers(req *vo.ImportUsersRequest, ctxUser *model.User, roleSortMin uint, preview bool) ([]*dto.ImportUserResult, bool, error) // Check and create the users, nothing is created if any user is invalid
+	ExportUsers() ([]dto.ExportUserDto, error)                                                                                          // Export the users with their roles and node counts
+	GetInvite(token string) (model.User, error)                                                                                         // Get the user of the invite
+	AcceptInvite(token, password string) (model.User, error)                                                                            // Set the password of the user and delete the invite
+	CleanExpiredInvites()                                                                                                               // Delete the expired invites
+}
+
+type UserImportRepository struct{}
+
+func NewUserImportRepository() IUserImportRepository {
+	return UserImportRepository{}
+}
+
+func (u UserImportRepository) ParseImportUsers(format, content string) ([]vo.ImportUser, []int, error) {
+	content = strings.TrimPrefix(content, "\ufeff")
+	if format == "json" {
+		var users []vo.ImportUser
+		if err := json.Unmarshal([]byte(content), &users); err != nil {
+			return nil, nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+		lines := make([]int, len(users))
+		for i := range users {
+			lines[i] = i + 1
+		}
+		return users, lines, nil
+	}
+
+	reader := csv.NewReader(strings.NewReader(content))
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CSV header: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"username", "email", "roles"} {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, fmt.Errorf("the CSV column %s is required", name)
+		}
+	}
+	cell := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var users []vo.ImportUser
+	var lines []int
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid CSV: %v", err)
+		}
+		line, _ := reader.FieldPos(0)
+		user := vo.ImportUser{
+			Username: cell(record, "username"),
+			Email:    cell(record, "email"),
+			Nickname: cell(record, "nickname"),
+			Password: cell(record, "password"),
+			Roles:    make([]string, 0),
+		}
+		for _, role := range strings.Split(cell(record, "roles"), importRoleSeparator) {
+			if role = strings.TrimSpace(role); role != "" {
+				user.Roles = append(user.Roles, role)
+			}
+		}
+		users = append(users, user)
+		lines = append(lines, line)
+	}
+	return users, lines, nil
+}
+
+func (u UserImportRepository) ImportUsers(req *vo.ImportUsersRequest, ctxUser *model.User, roleSortMin uint, preview bool) ([]*dto.ImportUserResult, bool, error) {
+	users, lines, err := u.ParseImportUsers(req.Format, req.Content)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(users) == 0 {
+		return nil, false, errors.New("no user in the file")
+	}
+
+	results, roles, err := checkImportUsers(users, lines, req.Invite, roleSortMin)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, result := range results {
+		if len(result.Errors) > 0 {
+			return results, false, nil
+		}
+	}
+	if preview {
+		return results, true, nil
+	}
+
+	inviteExpire := time.Hour * 24 * 7
+	if req.InviteExpireDays > 0 {
+		inviteExpire = time.Hour * 24 * time.Duration(req.InviteExpireDays)
+	}
+	created := make([]*model.User, len(users))
+	err = common.DB.Transaction(func(tx *gorm.DB) error {
+		for i, item := range users {
+			password := item.Password
+			if password == "" {
+				// The user can not login until the invite is accepted
+				if password, err = util.GenToken(""); err != nil {
+					return err
+				}
+			}
+			user := &model.User{
+				Name:     item.Username,
+				Password: util.GenPasswd(password),
+				Email:    item.Email,
+				Avatar:   defaultAvatar,
+				Nickname: item.Nickname,
+				Status:   1,
+				Creator:  ctxUser.Name,
+				Source:   SourceLocal,
+				Roles:    roles[i],
+			}
+			if err := tx.Create(user).Error; err != nil {
+				return fmt.Errorf("create user %s error: %v", item.Username, err)
+			}
+			created[i] = user
+
+			if item.Password == "" {
+				token, err := util.GenToken(InviteTokenPrefix)
+				if err != nil {
+					return err
+				}
+				invite := &model.UserInvite{
+					UserID:    user.ID,
+					Hash:      util.HashToken(token),
+					ExpiresAt: time.Now().Add(inviteExpire),
+					Creator:   ctxUser.Name,
+				}
+				if err := tx.Create(invite).Error; err != nil {
+					return fmt.Errorf("create invite of %s error: %v", item.Username, err)
+				}
+				results[i].Invite = token
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The headscale users and pre-auth keys can not be rolled back, their failures are warnings of the created users
+	if req.CreateHeadscaleUser {
+		for i, user := range created {
+			if err = ensureHeadscaleUser(user.Name); err != nil {
+				results[i].Warnings = append(results[i].Warnings, "failed to create headscale user: "+err.Error())
+				log.Log.Errorf("import user %s, create headscale user error: %v", user.Name, err)
+				continue
+			}
+			if req.CreatePreAuthKey {
+				key, err := createImportPreAuthKey(user.Name, req.PreAuthKeyReusable, req.PreAuthKeyExpireDays)
+				if err != nil {
+					results[i].Warnings = append(results[i].Warnings, "failed to create pre-auth key: "+err.Error())
+					log.Log.Errorf("import user %s, create pre-auth key error: %v", user.Name, err)
+					continue
+				}
+				results[i].PreAuthKey = key
+			}
+		}
+	}
+	TriggerACLRoleSync()
+	return results, true, nil
+}
+
+// checkImportUsers validates the users like CreateUser, and returns the roles of each user. The passwords of the users
+// are decrypted.
+func checkImportUsers(users []vo.ImportUser, lines []int, invite bool, roleSortMin uint) ([]*dto.ImportUserResult, [][]*model.Role, error) {
+	var names, emails, keywords []string
+	for _, user := range users {
+		names = append(names, user.Username)
+		emails = append(emails, user.Email)
+		keywords = append(keywords, user.Roles...)
+	}
+
+	var existing []model.User
+	if err := common.DB.Unscoped().Where("name IN (?) OR email IN (?)", names, emails).Find(&existing).Error; err != nil {
+		return nil, nil, err
+	}
+	existingNames := make(map[string]bool)
+	existingEmails := make(map[string]bool)
+	for _, user := range existing {
+		existingNames[user.Name] = true
+		existingEmails[strings.ToLower(user.Email)] = true
+	}
+
+	var roleList []*model.Role
+	if len(keywords) > 0 {
+		if err := common.DB.Where("keyword IN (?)", keywords).Find(&roleList).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+	roleMap := make(map[string]*model.Role)
+	for _, role := range roleList {
+		roleMap[role.Keyword] = role
+	}
+
+	results := make([]*dto.ImportUserResult, len(users))
+	roles := make([][]*model.Role, len(users))
+	seenNames := make(map[string]int)
+	seenEmails := make(map[string]int)
+	for i, user := range users {
+		result := &dto.ImportUserResult{
+			Line:     lines[i],
+			Username: user.Username,
+			Email:    user.Email,
+			Nickname: user.Nickname,
+			Roles:    user.Roles,
+			Errors:   make([]string, 0),
+		}
+		results[i] = result
+
+		// The passwords are encrypted by RSA like the password of a created user, they are validated as plaintext
+		if user.Password != "" {
+			password, err := util.RSADecrypt([]byte(user.Password), config.Conf.System.PrivateKey)
+			if err != nil {
+				result.Errors = append(result.Errors, "password is not encrypted with the public key of the panel")
+			} else {
+				user.Password = string(password)
+				users[i].Password = user.Password
+			}
+		}
+
+		if err := common.Validate.Struct(&user); err != nil {
+			var errs validator.ValidationErrors
+			if errors.As(err, &errs) {
+				for _, e := range errs {
+					result.Errors = append(result.Errors, e.Translate(common.Trans))
+				}
+			} else {
+				result.Errors = append(result.Errors, err.Error())
+			}
+		}
+		if user.Password == "" && !invite {
+			result.Errors = append(result.Errors, "password is required when the invite is not enabled")
+		}
+
+		if existingNames[user.Username] {
+			result.Errors = append(result.Errors, "username already exists")
+		} else if line, ok := seenNames[user.Username]; ok {
+			result.Errors = append(result.Errors, "username is duplicated with line "+strconv.Itoa(line))
+		}
+		email := strings.ToLower(user.Email)
+		if existingEmails[email] {
+			result.Errors = append(result.Errors, "email already exists")
+		} else if line, ok := seenEmails[email]; ok && email != "" {
+			result.Errors = append(result.Errors, "email is duplicated with line "+strconv.Itoa(line))
+		}
+		seenNames[user.Username] = lines[i]
+		seenEmails[email] = lines[i]
+
+		// Users cannot create users of a higher rank than their own or of the same rank
+		for _, keyword := range user.Roles {
+			role, ok := roleMap[keyword]
+			if !ok {
+				result.Errors = append(result.Errors, "role does not exist: "+keyword)
+				continue
+			}
+			if roleSortMin >= role.Sort {
+				result.Errors = append(result.Errors, "Users cannot create users with higher or equal levels than themselves: "+keyword)
+				continue
+			}
+			roles[i] = append(roles[i], role)
+		}
+	}
+	return results, roles, nil
+}
+
+func createImportPreAuthKey(user string, reusable bool, expireDays uint) (string, error) {
+	if expireDays == 0 {
+		expireDays = 1
+	}
+	req := &vo.CreatePreAuthKey{CreatePreAuthKeyRequest: pb.CreatePreAuthKeyRequest{
+		User:       user,
+		Reusable:   reusable,
+		Expiration: timestamppb.New(time.Now().AddDate(0, 0, int(expireDays))),
+	}}
+	key, err := NewPreAuthkeyRepo().CreatePreAuthKey(req)
+	if err != nil {
+		return "", err
+	}
+	return key.Key, nil
+}
+
+func (u UserImportRepository) ExportUsers() ([]dto.ExportUserDto, error) {
+	var users []*model.User
+	if err := common.DB.Order("id").Preload("Roles").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	// The users are exported without their nodes when headscale does not answer
+	nodeCounts := make(map[string]int)
+	nodes, err := NewNodesRepo().ListNodes(&vo.ListNodesRequest{})
+	if err != nil {
+		log.Log.Errorf("export users, list headscale nodes error: %v", err)
+	}
+	for _, node := range nodes {
+		nodeCounts[node.GetUser().GetName()]++
+	}
+	var mappings []*model.HeadscaleUserMapping
+	if err = common.DB.Find(&mappings).Error; err != nil {
+		return nil, err
+	}
+	headscaleUsers := make(map[uint][]string)
+	for _, mapping := range mappings {
+		headscaleUsers[mapping.UserID] = append(headscaleUsers[mapping.UserID], mapping.HeadscaleUser)
+	}
+
+	list := make([]dto.ExportUserDto, 0, len(users))
+	for _, user := range users {
+		roles := make([]string, 0, len(user.Roles))
+		for _, role := range user.Roles {
+			roles = append(roles, role.Keyword)
+		}
+		// Like GetUserMappings, a user without mappings has the headscale user with the same name
+		names, ok := headscaleUsers[user.ID]
+		if !ok {
+			names = []string{user.Name}
+		}
+		nodeCount := 0
+		for _, name := range names {
+			nodeCount += nodeCounts[name]
+		}
+		list = append(list, dto.ExportUserDto{
+			Username:  user.Name,
+			Email:     user.Email,
+			Nickname:  user.Nickname,
+			Roles:     roles,
+			Status:    user.Status,
+			Source:    user.Source,
+			NodeCount: nodeCount,
+			CreatedAt: user.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	return list, nil
+}
+
+// WriteUsersCSV writes the exported users in the same columns as the import file
+func WriteUsersCSV(w io.Writer, users []dto.ExportUserDto) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"username", "email", "nickname", "roles", "status", "source", "nodeCount", "createdAt"}); err != nil {
+		return err
+	}
+	for _, user := range users {
+		err := writer.Write([]string{
+			user.Username,
+			user.Email,
+			user.Nickname,
+			strings.Join(user.Roles, importRoleSeparator),
+			strconv.Itoa(int(user.Status)),
+			user.Source,
+			strconv.Itoa(user.NodeCount),
+			user.CreatedAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func (u UserImportRepository) GetInvite(token string) (model.User, error) {
+	_, user, err := getInvite(token)
+	return user, err
+}
+
+func (u UserImportRepository) AcceptInvite(token, password string) (model.User, error) {
+	invite, user, err := getInvite(token)
+	if err != nil {
+		return user, err
+	}
+	if err = NewUserRepository().ChangePwd(user.Name, util.GenPasswd(password)); err != nil {
+		return user, err
+	}
+	return user, common.DB.Unscoped().Delete(&invite).Error
+}
+
+func (u UserImportRepository) CleanExpiredInvites() {
+	err := common.DB.Unscoped().Where("expires_at < ?", time.Now()).Delete(&model.UserInvite{}).Error
+	if err != nil {
+		log.Log.Errorf("clean expired invites error: %v", err)
+	}
+}
+
+func getInvite(token string) (model.UserInvite, model.User, error) {
+	var invite model.UserInvite
+	var user model.User
+	if err := common.DB.Where("hash = ?", util.HashToken(token)).First(&invite).Error; err != nil {
+		return invite, user, ErrInviteInvalid
+	}
+	if invite.ExpiresAt.Before(time.Now()) {
+		return invite, user, ErrInviteInvalid
+	}
+	user, err := NewUserRepository().GetUserById(invite.UserID)
+	if err != nil {
+		return invite, user, ErrInviteInvalid
+	}
+	return invite, user, nil
+}
@@ -90,25 +90,9 @@ func (ur UserRepository) Login(user *model.User) (*model.User, error) {
 		return nil, errors.New("user does not exist")
 	}
 
-	// Determine the user's status
-	userStatus := firstUser.Status
-	if userStatus != 1 {
-		return nil, errors.New("user is disabled")
-	}
-
-	// Determine the status of all roles owned by the user, and if all roles are disabled, the user cannot log in
-	roles := firstUser.Roles
-	isValidate := false
-	for _, role := range roles {
-		// If there is a role with a normal status, the user can log in
-		if role.Status == 1 {
-			isValidate = true
-			break
-		}
-	}
-
-	if !isValidate {
-		return nil, errors.New("user role is disabled")
+	// Determine the user's status and the status of the roles
+	if err = checkUserStatus(&firstUser); err != nil {
+		return nil, err
 	}
 
 	// Verify password
@@ -120,6 +104,21 @@ func (ur UserRepository) Login(user *model.User) (*model.User, error) {
 	return &firstUser, nil
 }
 
+// checkUserStatus determines the user's status and the status of all roles owned by the user,
+// if all roles are disabled, the user cannot log in
+func checkUserStatus(user *model.User) error {
+	if user.Status != 1 {
+		return errors.New("user is disabled")
+	}
+	for _, role := range user.Roles {
+		// If there is a role with a normal status, the user can log in
+		if role.Status == 1 {
+			return nil
+		}
+	}
+	return errors.New("user role is disabled")
+}
+
 // Get current logged-in user information
 // Need to cache to reduce database access
 func (ur UserRepository) GetCurrentUser(c *gin.Context) (model.User, error) {
@@ -244,6 +243,9 @@ func (ur UserRepository) ChangePwd(name string, hashNewPasswd string) error {
 // Create user
 func (ur UserRepository) CreateUser(user *model.User) error {
 	err := common.DB.Create(user).Error
+	if err == nil {
+		TriggerACLRoleSync()
+	}
 	return err
 }
 
@@ -257,9 +259,14 @@ func (ur UserRepository) UpdateUser(user *model.User) error {
 
 	//err := common.DB.Session(&gorm.Session{FullSaveAssociations: true}).Updates(&user).Error
 
-	// Update the user information cache if the update is successful
+	// Update the user information cache and the role groups of the ACL if the update is successful
 	if err == nil {
 		SetUserRefreshFlag(user)
+		TriggerACLRoleSync()
+	}
+	// The tokens issued to the OIDC clients are revoked when the user is disabled
+	if err == nil && user.Status == 2 {
+		err = NewOAuthTokenRepository().RevokeUserTokens(user.ID)
 	}
 	return err
 }
@@ -288,14 +295,26 @@ func (ur UserRepository) BatchDeleteUserByIds(ids []uint) error {
 	if err == nil {
 		for _, user := range users {
 			userInfoCache.Delete(user.Name)
+			if err = NewSessionRepository().RevokeUserSessions(user.ID, nil, ""); err != nil {
+				return err
+			}
+			if err = NewOAuthTokenRepository().RevokeUserTokens(user.ID); err != nil {
+				return err
+			}
 		}
+		err = NewHeadscaleMappingRepository().DeleteUserMappings(ids)
+		TriggerACLRoleSync()
 	}
 	return err
 }
 
 func (ur UserRepository) BatchDeleteUserByNames(name []string) error {
 	user := &model.User{}
-	return common.DB.Model(user).Where("user_name in (?)", name).Delete(user).Error
+	err := common.DB.Model(user).Where("user_name in (?)", name).Delete(user).Error
+	if err == nil {
+		TriggerACLRoleSync()
+	}
+	return err
 }
 
 // Get the minimum role sorting value by user ID
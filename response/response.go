@@ -3,6 +3,7 @@ package response
 import (
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"strconv"
 )
 
 // Response to frontend
@@ -19,3 +20,22 @@ func Success(c *gin.Context, data interface{}, message string) {
 func Fail(c *gin.Context, data interface{}, message string) {
 	Response(c, http.StatusBadRequest, 400, data, message)
 }
+
+// Response to SCIM client, the body is a SCIM resource or message
+func Scim(c *gin.Context, httpStatus int, data interface{}) {
+	c.Header("Content-Type", "application/scim+json; charset=utf-8")
+	c.JSON(httpStatus, data)
+}
+
+// Response to SCIM client - Error (RFC 7644 section 3.12)
+func ScimFail(c *gin.Context, httpStatus int, scimType, detail string) {
+	body := gin.H{
+		"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:Error"},
+		"status":  strconv.Itoa(httpStatus),
+		"detail":  detail,
+	}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+	Scim(c, httpStatus, body)
+}
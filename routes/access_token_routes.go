@@ -0,0 +1,25 @@
+package routes
+
+import (
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"headscale-panel/controller"
+	"headscale-panel/middleware"
+)
+
+// Register personal access token routes
+func InitAccessTokenRoutes(r *gin.RouterGroup, authMiddleware *jwt.GinJWTMiddleware) gin.IRoutes {
+	tokenController := controller.NewAccessTokenController()
+	router := r.Group("/token")
+	// Enable JWT authentication middleware
+	router.Use(middleware.TokenAuth(authMiddleware))
+	// Enable Casbin authentication middleware
+	router.Use(middleware.CasbinMiddleware())
+	{
+		router.GET("/scopes", tokenController.GetScopes)
+		router.GET("/list", tokenController.GetTokens)
+		router.POST("/create", tokenController.CreateToken)
+		router.DELETE("/delete/batch", tokenController.BatchRevokeTokens)
+	}
+	return r
+}
@@ -10,5 +10,41 @@ func InitAccessControlRoutes(r *gin.RouterGroup) gin.IRoutes {
 	aclc := controller.NewAccessControlController()
 	r.GET("/acl", aclc.GetAccessControl)
 	r.POST("/acl", aclc.SetAccessControl)
+	r.POST("/acl/validate", aclc.ValidateAccessControl)
+
+	// Edit the policy section by section
+	policy := r.Group("/acl")
+	policy.GET("/policy", aclc.GetPolicy)
+	policy.PUT("/groups/:name", aclc.SetGroup)
+	policy.DELETE("/groups/:name", aclc.DeleteGroup)
+	policy.POST("/groups/:name/members", aclc.AddGroupMember)
+	policy.DELETE("/groups/:name/members/:member", aclc.RemoveGroupMember)
+	policy.PUT("/hosts/:name", aclc.SetHost)
+	policy.DELETE("/hosts/:name", aclc.DeleteHost)
+	policy.PUT("/tagOwners/:tag", aclc.SetTagOwners)
+	policy.DELETE("/tagOwners/:tag", aclc.DeleteTagOwners)
+	policy.POST("/acls", aclc.AddACLRule)
+	policy.PUT("/acls/:index", aclc.UpdateACLRule)
+	policy.DELETE("/acls/:index", aclc.DeleteACLRule)
+	policy.POST("/ssh", aclc.AddSSHRule)
+	policy.PUT("/ssh/:index", aclc.UpdateSSHRule)
+	policy.DELETE("/ssh/:index", aclc.DeleteSSHRule)
+	policy.PUT("/autoApprovers", aclc.SetAutoApprovers)
+
+	// The saved versions
+	policy.GET("/versions", aclc.ListVersions)
+	policy.GET("/versions/:id", aclc.GetVersion)
+	policy.POST("/versions/:id/rollback", aclc.RollbackVersion)
+	policy.GET("/diff", aclc.DiffVersions)
+
+	// Evaluate the policy with the live nodes
+	policy.GET("/simulate", aclc.Simulate)
+	policy.GET("/matrix", aclc.Reachability)
+
+	// The groups generated from the roles
+	policy.GET("/roles", aclc.ListRoleTemplates)
+	policy.PUT("/roles/:id", aclc.SetRoleTemplate)
+	policy.DELETE("/roles/:id", aclc.DeleteRoleTemplate)
+	policy.POST("/roles/sync", aclc.SyncRoleGroups)
 	return r
 }
@@ -11,7 +11,7 @@ func InitApiRoutes(r *gin.RouterGroup, authMiddleware *jwt.GinJWTMiddleware) gin
 	apiController := controller.NewApiController()
 	router := r.Group("/api")
 	// Enable JWT authentication middleware
-	router.Use(authMiddleware.MiddlewareFunc())
+	router.Use(middleware.TokenAuth(authMiddleware))
 	// Enable Casbin authentication middleware
 	router.Use(middleware.CasbinMiddleware())
 	{
@@ -3,16 +3,30 @@ package routes
 import (
 	jwt "github.com/appleboy/gin-jwt/v2"
 	"github.com/gin-gonic/gin"
+	"headscale-panel/controller"
+	"headscale-panel/middleware"
 )
 
 // Register basic routes
 func InitBaseRoutes(r *gin.RouterGroup, authMiddleware *jwt.GinJWTMiddleware) gin.IRoutes {
+	sso := controller.NewSsoController()
+	userController := controller.NewUserController()
 	router := r.Group("/base")
 	{
 		// No authentication required for login/logout token refresh
 		router.POST("/login", authMiddleware.LoginHandler)
-		router.POST("/logout", authMiddleware.LogoutHandler)
-		router.POST("/refreshToken", authMiddleware.RefreshHandler)
+		router.POST("/logout", middleware.LogoutHandler(authMiddleware))
+		router.POST("/refreshToken", middleware.RefreshHandler(authMiddleware))
+
+		// Login with the external OpenID Connect identity provider
+		router.GET("/sso", sso.Info)
+		router.GET("/sso/login", sso.Login)
+		router.GET("/sso/callback", sso.Callback)
+		router.POST("/sso/login", middleware.SsoLoginHandler(authMiddleware))
+
+		// Set the password of an imported user with the invite
+		router.GET("/invite", userController.GetInvite)
+		router.POST("/invite", userController.AcceptInvite)
 	}
 	return r
 }
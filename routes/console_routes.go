@@ -3,6 +3,7 @@ package routes
 import (
 	jwt "github.com/appleboy/gin-jwt/v2"
 	"github.com/gin-gonic/gin"
+	"headscale-panel/controller"
 	"headscale-panel/middleware"
 )
 
@@ -10,7 +11,7 @@ import (
 func InitConsoleRoutes(r *gin.RouterGroup, authMiddleware *jwt.GinJWTMiddleware) {
 	consoleGroup := r.Group("/console")
 	// Enable JWT authentication middleware
-	consoleGroup.Use(authMiddleware.MiddlewareFunc())
+	consoleGroup.Use(middleware.TokenAuth(authMiddleware))
 	// Enable Casbin authentication middleware
 	consoleGroup.Use(middleware.CasbinMiddleware())
 
@@ -18,4 +19,7 @@ func InitConsoleRoutes(r *gin.RouterGroup, authMiddleware *jwt.GinJWTMiddleware)
 	InitRouteRoutes(consoleGroup)         // Register Route API
 	InitNodesRoutes(consoleGroup)         // Register Machine API
 	InitAccessControlRoutes(consoleGroup) // Register ACL API
+
+	// The headscale users the current user can access
+	consoleGroup.GET("/mapping", controller.NewHeadscaleMappingController().GetCurrentMappings)
 }
@@ -11,7 +11,7 @@ func InitMenuRoutes(r *gin.RouterGroup, authMiddleware *jwt.GinJWTMiddleware) gi
 	menuController := controller.NewMenuController()
 	router := r.Group("/menu")
 	// Enable JWT authentication middleware
-	router.Use(authMiddleware.MiddlewareFunc())
+	router.Use(middleware.TokenAuth(authMiddleware))
 	// Enable Casbin authentication middleware
 	router.Use(middleware.CasbinMiddleware())
 	{
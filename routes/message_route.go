@@ -11,7 +11,7 @@ import (
 func InitMessageRoutes(r *gin.RouterGroup, authMiddleware *jwt.GinJWTMiddleware) gin.IRoutes {
 	message := controller.NewMessageController()
 	// Enable JWT authentication middleware
-	r.Use(authMiddleware.MiddlewareFunc())
+	r.Use(middleware.TokenAuth(authMiddleware))
 	// Enable Casbin authentication middleware
 	r.Use(middleware.CasbinMiddleware())
 	r.GET("/message", message.ListMessages)
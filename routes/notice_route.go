@@ -11,7 +11,7 @@ import (
 func InitNoticeRoutes(r *gin.RouterGroup, authMiddleware *jwt.GinJWTMiddleware) gin.IRoutes {
 	notice := controller.NewNoticeController()
 	// Enable JWT authentication middleware
-	r.Use(authMiddleware.MiddlewareFunc())
+	r.Use(middleware.TokenAuth(authMiddleware))
 	// Enable Casbin authentication middleware
 	r.Use(middleware.CasbinMiddleware())
 
@@ -0,0 +1,26 @@
+package routes
+
+import (
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"headscale-panel/controller"
+	"headscale-panel/middleware"
+)
+
+// Register OIDC client management routes
+func InitOAuthClientRoutes(r *gin.RouterGroup, authMiddleware *jwt.GinJWTMiddleware) gin.IRoutes {
+	clientController := controller.NewOAuthClientController()
+	router := r.Group("/oidc/client")
+	// Enable JWT authentication middleware
+	router.Use(middleware.TokenAuth(authMiddleware))
+	// Enable Casbin authentication middleware
+	router.Use(middleware.CasbinMiddleware())
+	{
+		router.GET("/list", clientController.GetClients)
+		router.POST("/create", clientController.CreateClient)
+		router.PATCH("/update/:id", clientController.UpdateClientById)
+		router.POST("/secret/:id", clientController.RotateSecretById)
+		router.DELETE("/delete/batch", clientController.BatchDeleteClients)
+	}
+	return r
+}
@@ -7,13 +7,23 @@ import (
 	"headscale-panel/middleware"
 )
 
-func InitOIDCRoutes(r *gin.RouterGroup, authorization *jwt.GinJWTMiddleware) gin.IRoutes {
-	oidc := controller.NewOIDCController()
+// InitOIDCRoutes registers the OIDC provider routes, the endpoints in the discovery document follow their base path
+func InitOIDCRoutes(root *gin.Engine, r *gin.RouterGroup, authorization *jwt.GinJWTMiddleware) gin.IRoutes {
+	router := r.Group("/oidc")
+	oidc := controller.NewOIDCController(router.BasePath())
 
-	r.POST("/oidc/token", oidc.Token)
-	r.GET("/oidc/user_info", oidc.GetUserInfo)
-	r.GET("/oidc/jwk", oidc.JWKs)
-	r.POST("/oidc/authorize", authorization.MiddlewareFunc(), middleware.CasbinMiddleware(), oidc.Authorize)
+	root.GET("/.well-known/openid-configuration", oidc.Discovery)
+	router.POST("/token", oidc.Token)
+	router.GET("/user_info", oidc.GetUserInfo)
+	router.GET("/jwk", oidc.JWKs)
+	router.POST("/register", oidc.Register)
+	router.POST("/introspect", oidc.Introspect)
+	router.POST("/revoke", oidc.Revoke)
+	router.POST("/authorize", middleware.TokenAuth(authorization), middleware.CasbinMiddleware(), oidc.Authorize)
+	router.GET("/authorize", oidc.BrowserAuthorize)
+	router.GET("/consent", oidc.ConsentPage)
+	router.POST("/consent", oidc.Consent)
+	router.GET("/check", middleware.TokenAuth(authorization), middleware.CasbinMiddleware(), oidc.SelfCheck)
 
 	return r
 }
@@ -11,7 +11,7 @@ func InitOperationLogRoutes(r *gin.RouterGroup, authMiddleware *jwt.GinJWTMiddle
 	operationLogController := controller.NewOperationLogController()
 	router := r.Group("/log")
 	// Enable JWT authentication middleware
-	router.Use(authMiddleware.MiddlewareFunc())
+	router.Use(middleware.TokenAuth(authMiddleware))
 	// Enable Casbin authentication middleware
 	router.Use(middleware.CasbinMiddleware())
 	{
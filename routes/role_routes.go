@@ -11,7 +11,7 @@ func InitRoleRoutes(r *gin.RouterGroup, authMiddleware *jwt.GinJWTMiddleware) gi
 	roleController := controller.NewRoleController()
 	router := r.Group("/role")
 	// Enable JWT authentication middleware
-	router.Use(authMiddleware.MiddlewareFunc())
+	router.Use(middleware.TokenAuth(authMiddleware))
 	// Enable Casbin authentication middleware
 	router.Use(middleware.CasbinMiddleware())
 	{
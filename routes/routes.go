@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"headscale-panel/config"
-	"headscale-panel/controller"
 	"headscale-panel/log"
 	"headscale-panel/middleware"
 	"time"
@@ -41,11 +40,11 @@ func InitRoutes() *gin.Engine {
 		panic(fmt.Sprintf("Initialization of JWT middleware failed：%v", err))
 	}
 
-	r.GET("/.well-known/openid-configuration", controller.GetOpenIDConfiguration)
+	InitScimRoutes(r) // Register SCIM provisioning routes, authenticated by the SCIM bearer tokens
 
 	// Route grouping
 	apiGroup := r.Group("/" + config.Conf.System.UrlPathPrefix)
-	InitOIDCRoutes(apiGroup, authMiddleware) // Register OIDC interface routes without authentication middleware
+	InitOIDCRoutes(r, apiGroup, authMiddleware) // Register OIDC interface routes and the discovery document without authentication middleware
 
 	// Register routes
 	InitBaseRoutes(apiGroup, authMiddleware)         // Register basic routes, no need for JWT authentication middleware, no need for Casbin middleware
@@ -54,6 +53,9 @@ func InitRoutes() *gin.Engine {
 	InitMenuRoutes(apiGroup, authMiddleware)         // Register menu routes, require JWT authentication middleware, require Casbin authentication middleware
 	InitApiRoutes(apiGroup, authMiddleware)          // Register API routes, require JWT authentication middleware, require Casbin authentication middleware
 	InitOperationLogRoutes(apiGroup, authMiddleware) // Register operation log routes, require JWT authentication middleware, require Casbin authentication middleware
+	InitSessionRoutes(apiGroup, authMiddleware)      // Register session routes, require JWT authentication middleware, require Casbin authentication middleware
+	InitAccessTokenRoutes(apiGroup, authMiddleware)  // Register personal access token routes, require JWT authentication middleware, require Casbin authentication middleware
+	InitOAuthClientRoutes(apiGroup, authMiddleware)  // Register OIDC client routes, require JWT authentication middleware, require Casbin authentication middleware
 
 	InitSystemRoutes(apiGroup, authMiddleware)  // Register system routes, require JWT authentication middleware, require Casbin authentication middleware
 	InitConsoleRoutes(apiGroup, authMiddleware) // Register console routes, require JWT authentication middleware, require Casbin authentication middleware
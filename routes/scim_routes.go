@@ -0,0 +1,32 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"headscale-panel/controller"
+	"headscale-panel/middleware"
+)
+
+// InitScimRoutes register the SCIM 2.0 provisioning routes, they are authenticated by the SCIM bearer tokens
+func InitScimRoutes(r *gin.Engine) gin.IRoutes {
+	scim := controller.NewScimController()
+	router := r.Group("/scim/v2")
+	router.Use(middleware.ScimMiddleware())
+	{
+		router.GET("/ServiceProviderConfig", scim.ServiceProviderConfig)
+
+		router.GET("/Users", scim.ListUsers)
+		router.POST("/Users", scim.CreateUser)
+		router.GET("/Users/:id", scim.GetUser)
+		router.PUT("/Users/:id", scim.ReplaceUser)
+		router.PATCH("/Users/:id", scim.PatchUser)
+		router.DELETE("/Users/:id", scim.DeleteUser)
+
+		router.GET("/Groups", scim.ListGroups)
+		router.POST("/Groups", scim.CreateGroup)
+		router.GET("/Groups/:id", scim.GetGroup)
+		router.PUT("/Groups/:id", scim.ReplaceGroup)
+		router.PATCH("/Groups/:id", scim.PatchGroup)
+		router.DELETE("/Groups/:id", scim.DeleteGroup)
+	}
+	return r
+}
@@ -0,0 +1,25 @@
+package routes
+
+import (
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"headscale-panel/controller"
+	"headscale-panel/middleware"
+)
+
+// Register login session routes
+func InitSessionRoutes(r *gin.RouterGroup, authMiddleware *jwt.GinJWTMiddleware) gin.IRoutes {
+	sessionController := controller.NewSessionController()
+	router := r.Group("/session")
+	// Enable JWT authentication middleware
+	router.Use(middleware.TokenAuth(authMiddleware))
+	// Enable Casbin authentication middleware
+	router.Use(middleware.CasbinMiddleware())
+	{
+		router.GET("/list", sessionController.GetSessions)
+		router.DELETE("/delete/batch", sessionController.BatchRevokeSessions)
+		router.GET("/user/:userId", sessionController.GetUserSessions)
+		router.DELETE("/user/:userId", sessionController.RevokeUserSessions)
+	}
+	return r
+}
@@ -10,13 +10,22 @@ import (
 func InitSystemRoutes(r *gin.RouterGroup, authMiddleware *jwt.GinJWTMiddleware) gin.IRoutes {
 	s := r.Group("/system")
 	// Enable JWT authentication middleware
-	s.Use(authMiddleware.MiddlewareFunc())
+	s.Use(middleware.TokenAuth(authMiddleware))
 	// Enable Casbin authentication middleware
 	s.Use(middleware.CasbinMiddleware())
 
 	headscale := controller.NewHeadscaleConfigController()
 	s.GET("/headscale", headscale.GetHeadscaleConfig)
 	s.POST("/headscale", headscale.SetHeadscaleConfig)
+	s.GET("/headscale/config", headscale.GetHeadscaleConfigSections)
+	s.GET("/headscale/config/:section", headscale.GetHeadscaleConfigSection)
+	s.PATCH("/headscale/config/:section", headscale.PatchHeadscaleConfigSection)
+	s.GET("/headscale/jobs", headscale.ListHeadscaleConfigJobs)
+	s.GET("/headscale/jobs/:id", headscale.GetHeadscaleConfigJob)
+	s.GET("/headscale/versions", headscale.ListConfigVersions)
+	s.GET("/headscale/versions/:id", headscale.GetConfigVersion)
+	s.POST("/headscale/versions/:id/restore", headscale.RestoreConfigVersion)
+	s.GET("/headscale/diff", headscale.DiffConfigVersions)
 	// 功能预留
 	//s.POST("/headscale/upload/:target", headscale.Upload)
 
@@ -24,5 +33,10 @@ func InitSystemRoutes(r *gin.RouterGroup, authMiddleware *jwt.GinJWTMiddleware)
 	s.GET("/info", system.GetInfo)
 	s.GET("/status", system.GetStatus)
 	s.POST("/install", system.Install)
+
+	scim := controller.NewScimController()
+	s.GET("/scim/token", scim.ListTokens)
+	s.POST("/scim/token", scim.CreateToken)
+	s.DELETE("/scim/token", scim.DeleteTokens)
 	return r
 }
@@ -10,9 +10,10 @@ import (
 // Register user routes
 func InitUserRoutes(r *gin.RouterGroup, authMiddleware *jwt.GinJWTMiddleware) gin.IRoutes {
 	userController := controller.NewUserController()
+	mappingController := controller.NewHeadscaleMappingController()
 	router := r.Group("/user")
 	// Enable JWT authentication middleware
-	router.Use(authMiddleware.MiddlewareFunc())
+	router.Use(middleware.TokenAuth(authMiddleware))
 	// Enable Casbin authentication middleware
 	router.Use(middleware.CasbinMiddleware())
 	{
@@ -22,6 +23,11 @@ func InitUserRoutes(r *gin.RouterGroup, authMiddleware *jwt.GinJWTMiddleware) gi
 		router.POST("/create", userController.CreateUser)
 		router.PATCH("/update/:userId", userController.UpdateUserById)
 		router.DELETE("/delete/batch", userController.BatchDeleteUserByIds)
+		router.POST("/import/preview", userController.PreviewImportUsers)
+		router.POST("/import", userController.ImportUsers)
+		router.GET("/export", userController.ExportUsers)
+		router.GET("/mapping/:userId", mappingController.GetUserMappings)
+		router.PUT("/mapping/:userId", mappingController.SetUserMappings)
 	}
 	return r
 }
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	pb "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"google.golang.org/grpc"
 	"headscale-panel/config"
 	"headscale-panel/log"
 	"headscale-panel/util"
@@ -11,6 +13,7 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"time"
 )
 
 const baseURL = "https://api.github.com/repos/juanfont/headscale/releases"
@@ -41,6 +44,7 @@ func (h *HeadscaleService) Start() error {
 					config.Conf.Headscale.Config,
 					config.Conf.Headscale.Controller.Command.Start,
 					config.Conf.Headscale.Controller.Command.Stop,
+					config.Conf.Headscale.Controller.Command.Reload,
 				),
 			)
 		}
@@ -103,6 +107,64 @@ func (h *HeadscaleService) Stop(ctx context.Context) error {
 	return nil
 }
 
+// Restart stops and starts the headscale process
+func (h *HeadscaleService) Restart(ctx context.Context) error {
+	if err := h.Stop(ctx); err != nil {
+		return err
+	}
+	return h.Start()
+}
+
+// Reload makes headscale reload its ACL policy without a restart. Headscale is restarted instead when its config
+// changed since it started, or when the process can not be reloaded.
+func (h *HeadscaleService) Reload(ctx context.Context) error {
+	if p == nil || !p.IsRunning() {
+		return h.Restart(ctx)
+	}
+	if p.ConfigChanged() {
+		log.Log.Info("headscale config changed, restart headscale instead of a reload")
+		return h.Restart(ctx)
+	}
+	log.Log.Info("reload headscale")
+	err := p.Reload(ctx)
+	if errors.Is(err, errReloadNotSupported) {
+		log.Log.Info("headscale.controller.command.reload is not set, restart headscale instead of a reload")
+		return h.Restart(ctx)
+	}
+	return err
+}
+
+// CheckHealth waits until the headscale process is running and its gRPC server answers, at most the timeout
+func (h *HeadscaleService) CheckHealth(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for {
+		err := h.ping(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("headscale is not healthy: %w", err)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (h *HeadscaleService) ping(ctx context.Context) error {
+	// In multi mode headscale runs elsewhere, only gRPC is checked
+	if config.GetMode() < config.MULTI && !h.IsRunning() {
+		return errors.New("headscale is not running")
+	}
+	if HeadscaleControl == nil || HeadscaleControl.HeadscaleServiceClient == nil {
+		return errors.New("gRPC client is not initialized")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err := HeadscaleControl.ListApiKeys(ctx, &pb.ListApiKeysRequest{}, grpc.WaitForReady(true))
+	return err
+}
+
 // GetErr get the error
 func (h *HeadscaleService) GetErr() error {
 	return p.GetErr()
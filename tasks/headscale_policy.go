@@ -0,0 +1,85 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The policy API of headscale 0.23 (policy.mode: database) is newer than the generated client, the messages are
+// encoded by hand: GetPolicyRequest {}, SetPolicyRequest {string policy = 1}, Get/SetPolicyResponse {string policy = 1}
+const (
+	getPolicyMethod = "/headscale.v1.HeadscaleService/GetPolicy"
+	setPolicyMethod = "/headscale.v1.HeadscaleService/SetPolicy"
+)
+
+var ErrPolicyAPIUnsupported = errors.New("headscale has no policy API, it needs headscale 0.23 or later with policy.mode: database")
+
+// rawCodec sends and receives the encoded messages as they are
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	return *(v.(*[]byte)), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	*(v.(*[]byte)) = append([]byte(nil), data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// GetPolicy gets the policy stored in the database of headscale
+func (h *headscaleRPC) GetPolicy(ctx context.Context) (string, error) {
+	return h.invokePolicy(ctx, getPolicyMethod, []byte{})
+}
+
+// SetPolicy stores the policy in the database of headscale, headscale loads it at once
+func (h *headscaleRPC) SetPolicy(ctx context.Context, policy string) error {
+	req := protowire.AppendTag(nil, 1, protowire.BytesType)
+	req = protowire.AppendString(req, policy)
+	_, err := h.invokePolicy(ctx, setPolicyMethod, req)
+	return err
+}
+
+func (h *headscaleRPC) invokePolicy(ctx context.Context, method string, req []byte) (string, error) {
+	if h.conn == nil {
+		return "", errors.New("gRPC client is not connected")
+	}
+	var reply []byte
+	if err := h.conn.Invoke(ctx, method, &req, &reply, grpc.ForceCodec(rawCodec{})); err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			return "", ErrPolicyAPIUnsupported
+		}
+		return "", err
+	}
+	return decodePolicy(reply)
+}
+
+// decodePolicy reads the policy field of a response
+func decodePolicy(b []byte) (string, error) {
+	var policy string
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", fmt.Errorf("decode policy response: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		if num == 1 && typ == protowire.BytesType {
+			policy, n = protowire.ConsumeString(b)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return "", fmt.Errorf("decode policy response: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+	return policy, nil
+}
@@ -7,6 +7,7 @@ import (
 	"golang.org/x/sync/errgroup"
 	"headscale-panel/log"
 	"os/exec"
+	"syscall"
 	"time"
 )
 
@@ -102,6 +103,14 @@ func (p *insideProcess) Stop(ctx context.Context) error {
 	}
 }
 
+// Reload sends SIGHUP to the inside process, headscale reloads its ACL policy on it.
+func (p *insideProcess) Reload(ctx context.Context) error {
+	if !p.IsRunning() {
+		return errors.New("headscale is not running")
+	}
+	return p.cmd.Process.Signal(syscall.SIGHUP)
+}
+
 // GetConfigPath returns the configuration file path of the inside process.
 func (p *insideProcess) GetConfigPath() string {
 	return p.config
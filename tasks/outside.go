@@ -2,19 +2,21 @@ package task
 
 import (
 	"context"
+	"fmt"
 	"os/exec"
 	"strings"
 )
 
-// OutsideControl returns a ProcessMode for outside control mode with the given app, config, start, and stop commands.
-func OutsideControl(app, config, start, stop string) ProcessMode {
+// OutsideControl returns a ProcessMode for outside control mode with the given app, config, start, stop, and reload
+// commands.
+func OutsideControl(app, config, start, stop, reload string) ProcessMode {
 	return func(process *Process) {
 		process.app = app
 		strs := strings.Split(process.app, "/")
 		if strs != nil {
 			app = strs[len(strs)-1]
 		}
-		process.p = newOutsideProcess(app, config, start, stop)
+		process.p = newOutsideProcess(app, config, start, stop, reload)
 	}
 }
 
@@ -24,17 +26,19 @@ type outsideProcess struct {
 	config string
 	start  string
 	stop   string
+	reload string
 	status string
 	cmd    *exec.Cmd
 }
 
-// newOutsideProcess creates a new outside control process with the given app, config, start, and stop commands.
-func newOutsideProcess(app, config, start, stop string) operate {
+// newOutsideProcess creates a new outside control process with the given app, config, start, stop, and reload commands.
+func newOutsideProcess(app, config, start, stop, reload string) operate {
 	return &outsideProcess{
 		app:    app,
 		config: config,
 		start:  start,
 		stop:   stop,
+		reload: reload,
 	}
 }
 
@@ -63,6 +67,19 @@ func (o *outsideProcess) Stop(ctx context.Context) error {
 	return o.cmd.Run()
 }
 
+// Reload runs the reload command of the outside process with the shell, so it may quote its arguments. It is not
+// supported without one.
+func (o *outsideProcess) Reload(ctx context.Context) error {
+	if o.reload == "" {
+		return errReloadNotSupported
+	}
+	o.cmd = exec.CommandContext(ctx, "sh", "-c", o.reload)
+	if out, err := o.cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("reload command error: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
 // GetConfigPath returns the configuration file path of the outside process.
 func (o *outsideProcess) GetConfigPath() string {
 	return o.config
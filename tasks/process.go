@@ -2,8 +2,12 @@ package task
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"headscale-panel/log"
+	"os"
 	"os/exec"
 	"runtime"
 	"strings"
@@ -14,9 +18,13 @@ type operate interface {
 	IsRunning() bool
 	Start(ctx context.Context) error
 	Stop(ctx context.Context) error
+	Reload(ctx context.Context) error
 	GetConfigPath() string
 }
 
+// errReloadNotSupported is returned by Reload when the process can only be restarted
+var errReloadNotSupported = errors.New("reload is not supported")
+
 // ProcessOption is a function type for processing options.
 type ProcessOption func(process *Process)
 
@@ -30,6 +38,7 @@ type Process struct {
 	apikey  string
 	app     string
 	err     error
+	confSum string // Hash of the configuration file the process started with
 }
 
 // stop the process used by runtime.SetFinalizer to GC
@@ -125,6 +134,7 @@ func (p *Process) GetApiKey() string {
 // Start the process
 func (p *Process) Start() error {
 	p.refreshVersion() // get headscale version
+	p.confSum = p.configHash()
 	p.err = p.p.Start(context.Background())
 
 	log.Log.Debugf("start headscale error: %v", p.err)
@@ -137,3 +147,24 @@ func (p *Process) Stop(ctx context.Context) error {
 	log.Log.Debugf("stop headscale error: %v", p.err)
 	return p.err
 }
+
+// Reload makes the running process reload its ACL policy without dropping the connections of the clients
+func (p *Process) Reload(ctx context.Context) error {
+	err := p.p.Reload(ctx)
+	log.Log.Debugf("reload headscale error: %v", err)
+	return err
+}
+
+// ConfigChanged tells whether the configuration file changed since the process started, a reload does not load it
+func (p *Process) ConfigChanged() bool {
+	return p.configHash() != p.confSum
+}
+
+func (p *Process) configHash() string {
+	data, err := os.ReadFile(p.GetConfigPath())
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -13,6 +13,7 @@ type Task interface {
 	Restart(force bool)
 	Start() error
 	Stop(ctx context.Context)
+	AddJob(spec string, job func()) error
 }
 
 var (
@@ -92,6 +93,12 @@ func (t *task) Start() error {
 	return nil
 }
 
+// AddJob registers a periodic job on the task cron, spec uses the cron format with seconds
+func (t *task) AddJob(spec string, job func()) error {
+	_, err := t.cron.AddFunc(spec, job)
+	return err
+}
+
 // Stop the tasks with context
 func (t *task) Stop(ctx context.Context) {
 	t.cron.Stop()
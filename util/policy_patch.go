@@ -0,0 +1,181 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tailscale/hujson"
+	"gopkg.in/yaml.v3"
+	"strconv"
+	"strings"
+)
+
+// PatchOperation is an operation of a JSON patch (RFC 6902), only add, replace and remove are used
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// JSONPointer joins the tokens to a JSON pointer (RFC 6901)
+func JSONPointer(tokens ...string) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteByte('/')
+		b.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(token))
+	}
+	return b.String()
+}
+
+func splitPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+// PatchHuJSON applies the operations to a HuJSON document, the comments and the order of the members are kept
+func PatchHuJSON(content []byte, ops []PatchOperation) ([]byte, error) {
+	if len(bytes.TrimSpace(content)) == 0 {
+		content = []byte("{}")
+	}
+	value, err := hujson.Parse(content)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := json.Marshal(ops)
+	if err != nil {
+		return nil, err
+	}
+	if err = value.Patch(patch); err != nil {
+		return nil, err
+	}
+	value.Format()
+	return value.Pack(), nil
+}
+
+// PatchYAML applies the operations to a YAML document, the comments and the order of the keys are kept
+func PatchYAML(content []byte, ops []PatchOperation) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	for i, op := range ops {
+		if err := patchYAMLNode(doc.Content[0], op); err != nil {
+			return nil, fmt.Errorf("yaml: patch operation %d: %w", i, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func patchYAMLNode(root *yaml.Node, op PatchOperation) error {
+	tokens, err := splitPointer(op.Path)
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		return fmt.Errorf("cannot %s the root value", op.Op)
+	}
+	parent := root
+	for _, token := range tokens[:len(tokens)-1] {
+		if parent, err = yamlChild(parent, token); err != nil {
+			return err
+		}
+	}
+	last := tokens[len(tokens)-1]
+
+	var value *yaml.Node
+	if op.Op == "add" || op.Op == "replace" {
+		value = &yaml.Node{}
+		if err = value.Encode(op.Value); err != nil {
+			return err
+		}
+	}
+
+	switch parent.Kind {
+	case yaml.MappingNode:
+		idx := -1
+		for i := 0; i < len(parent.Content); i += 2 {
+			if parent.Content[i].Value == last {
+				idx = i
+				break
+			}
+		}
+		switch {
+		case op.Op == "add" && idx < 0:
+			key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: last}
+			parent.Content = append(parent.Content, key, value)
+		case idx < 0:
+			return fmt.Errorf("%q not found", op.Path)
+		case op.Op == "remove":
+			parent.Content = append(parent.Content[:idx], parent.Content[idx+2:]...)
+		default:
+			replaceYAMLNode(parent.Content[idx+1], value)
+		}
+	case yaml.SequenceNode:
+		if op.Op == "add" && last == "-" {
+			parent.Content = append(parent.Content, value)
+			return nil
+		}
+		idx, err := strconv.Atoi(last)
+		if err != nil || idx < 0 || idx > len(parent.Content) || (op.Op != "add" && idx == len(parent.Content)) {
+			return fmt.Errorf("invalid index %q of %q", last, op.Path)
+		}
+		switch op.Op {
+		case "add":
+			parent.Content = append(parent.Content[:idx], append([]*yaml.Node{value}, parent.Content[idx:]...)...)
+		case "remove":
+			parent.Content = append(parent.Content[:idx], parent.Content[idx+1:]...)
+		default:
+			replaceYAMLNode(parent.Content[idx], value)
+		}
+	default:
+		return fmt.Errorf("the parent of %q is not a mapping or a sequence", op.Path)
+	}
+	return nil
+}
+
+func yamlChild(node *yaml.Node, token string) (*yaml.Node, error) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			if node.Content[i].Value == token {
+				return node.Content[i+1], nil
+			}
+		}
+	case yaml.SequenceNode:
+		if idx, err := strconv.Atoi(token); err == nil && idx >= 0 && idx < len(node.Content) {
+			return node.Content[idx], nil
+		}
+	case yaml.AliasNode:
+		return yamlChild(node.Alias, token)
+	}
+	return nil, errors.New(token + " not found")
+}
+
+// replaceYAMLNode replaces the value of the node but keeps its comments
+func replaceYAMLNode(node, value *yaml.Node) {
+	value.HeadComment, value.LineComment, value.FootComment = node.HeadComment, node.LineComment, node.FootComment
+	*node = *value
+}
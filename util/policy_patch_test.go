@@ -0,0 +1,61 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPatchYAML(t *testing.T) {
+	content := `# the groups
+groups:
+  group:admin: # the administrators
+    - alice
+acls:
+  - action: accept
+    src: ["*"]
+    dst: ["*:*"]
+`
+	out, err := PatchYAML([]byte(content), []PatchOperation{
+		{Op: "add", Path: JSONPointer("groups", "group:admin", "-"), Value: "bob"},
+		{Op: "add", Path: JSONPointer("groups", "group:dev"), Value: []string{"carol"}},
+		{Op: "remove", Path: JSONPointer("acls", "0")},
+		{Op: "add", Path: JSONPointer("hosts"), Value: map[string]string{"a/b": "10.0.0.1/32"}},
+		{Op: "replace", Path: JSONPointer("hosts", "a/b"), Value: "10.0.0.2/32"},
+	})
+	if err != nil {
+		t.Fatalf("patch error: %v", err)
+	}
+	for _, want := range []string{"# the groups", "# the administrators", "- bob", "group:dev:", "a/b: 10.0.0.2/32", "acls: []"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("%q not found in\n%s", want, out)
+		}
+	}
+	if strings.Index(string(out), "group:admin") > strings.Index(string(out), "group:dev") {
+		t.Errorf("the order of the groups is not kept\n%s", out)
+	}
+
+	if _, err = PatchYAML([]byte(content), []PatchOperation{{Op: "remove", Path: JSONPointer("acls", "1")}}); err == nil {
+		t.Error("removing a missing rule should fail")
+	}
+}
+
+func TestPatchHuJSON(t *testing.T) {
+	content := `{
+	// the groups
+	"groups": {
+		"group:admin": ["alice"], // the administrators
+	},
+}`
+	out, err := PatchHuJSON([]byte(content), []PatchOperation{
+		{Op: "add", Path: JSONPointer("groups", "group:admin", "-"), Value: "bob"},
+		{Op: "add", Path: JSONPointer("tagOwners"), Value: map[string][]string{"tag:server": {"group:admin"}}},
+	})
+	if err != nil {
+		t.Fatalf("patch error: %v", err)
+	}
+	for _, want := range []string{"// the groups", "// the administrators", `"bob"`, `"tag:server"`} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("%q not found in\n%s", want, out)
+		}
+	}
+}
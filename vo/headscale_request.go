@@ -76,6 +76,7 @@ type EditNodeRequest struct {
 	Name    string `json:"name" validate:"required_if=State rename,omitempty,min=0,max=63,lowercase"`
 	State   string `json:"state" validate:"required,oneof=register rename expire"`
 	Nodekey string `json:"nodekey" validate:"required_if=State register"`
+	User    string `json:"user" validate:"omitempty,max=63"` // Headscale user to register, the default one of the current user if empty
 }
 
 // GetNodeRequest struct represents a request to get details of a specific node.
@@ -101,4 +102,90 @@ type SetTagsRequest struct {
 // SetAccessControlRequest struct represents a request to set access control for a node.
 type SetAccessControlRequest struct {
 	Content string `json:"content" validate:"required"`
+	Comment string `json:"comment" validate:"max=255"`
+}
+
+// SetACLGroupRequest sets the members of a group of the ACL policy, the name is in the path
+type SetACLGroupRequest struct {
+	Name    string   `json:"-" validate:"required,startswith=group:,max=100"`
+	Members []string `json:"members" validate:"dive,required,max=100"`
+}
+
+// ACLGroupMemberRequest adds a member to a group of the ACL policy, the group is created if it is missing
+type ACLGroupMemberRequest struct {
+	Name   string `json:"-" validate:"required,startswith=group:,max=100"`
+	Member string `json:"member" validate:"required,max=100"`
+}
+
+// SetACLHostRequest sets the address of a host alias of the ACL policy
+type SetACLHostRequest struct {
+	Name    string `json:"-" validate:"required,max=100,excludes=:"`
+	Address string `json:"address" validate:"required,ip|cidr"`
+}
+
+// SetACLTagOwnersRequest sets the owners of a tag of the ACL policy
+type SetACLTagOwnersRequest struct {
+	Tag    string   `json:"-" validate:"required,startswith=tag:,max=100"`
+	Owners []string `json:"owners" validate:"dive,required,max=100"`
+}
+
+// ACLRuleRequest is a rule of the acls section of the ACL policy
+type ACLRuleRequest struct {
+	Action       string   `json:"action" validate:"required,eq=accept"`
+	Protocol     string   `json:"proto" validate:"omitempty,max=16"`
+	Sources      []string `json:"src" validate:"required,min=1,dive,required"`
+	Destinations []string `json:"dst" validate:"required,min=1,dive,required"`
+}
+
+// SetACLRoleTemplateRequest sets the rules generated with the group of a role, $group is the group of the role
+type SetACLRoleTemplateRequest struct {
+	RoleID uint             `json:"-" validate:"required"`
+	Rules  []ACLRuleRequest `json:"rules" validate:"dive"`
+}
+
+// SSHRuleRequest is a rule of the ssh section of the ACL policy
+type SSHRuleRequest struct {
+	Action       string   `json:"action" validate:"required,oneof=accept check"`
+	Sources      []string `json:"src" validate:"required,min=1,dive,required"`
+	Destinations []string `json:"dst" validate:"required,min=1,dive,required"`
+	Users        []string `json:"users" validate:"required,min=1,dive,required"`
+}
+
+// SetACLAutoApproversRequest replaces the autoApprovers section of the ACL policy
+type SetACLAutoApproversRequest struct {
+	Routes   map[string][]string `json:"routes" validate:"dive,keys,cidr,endkeys,dive,required"`
+	ExitNode []string            `json:"exitNode" validate:"dive,required"`
+}
+
+// ACLVersionListRequest lists the saved versions of the ACL policy
+type ACLVersionListRequest struct {
+	Creator  string `json:"creator" form:"creator"`
+	PageNum  int    `json:"pageNum" form:"pageNum"`
+	PageSize int    `json:"pageSize" form:"pageSize"`
+}
+
+// ACLVersionDiffRequest compares two versions of the ACL policy, 0 is the current policy
+type ACLVersionDiffRequest struct {
+	From uint `json:"from" form:"from"`
+	To   uint `json:"to" form:"to"`
+}
+
+// RollbackACLRequest saves a previous version of the ACL policy again, the id is in the path
+type RollbackACLRequest struct {
+	Comment string `json:"comment" validate:"max=200"`
+}
+
+// ACLSimulateRequest asks whether the source can reach the destination with the ACL policy
+type ACLSimulateRequest struct {
+	SrcType string `json:"srcType" form:"srcType" validate:"required,oneof=node user tag ip"`
+	Src     string `json:"src" form:"src" validate:"required,max=100"` // Node ID or name, user, tag or IP
+	DstType string `json:"dstType" form:"dstType" validate:"required,oneof=node tag ip"`
+	Dst     string `json:"dst" form:"dst" validate:"required,max=100"`
+	Port    int    `json:"port" form:"port" validate:"min=0,max=65535"`
+	Proto   string `json:"proto" form:"proto" validate:"omitempty,oneof=tcp udp icmp sctp"` // tcp if empty
+}
+
+// ACLReachabilityRequest lists what a node can reach
+type ACLReachabilityRequest struct {
+	Node string `json:"node" form:"node" validate:"required,max=100"` // Node ID or name
 }
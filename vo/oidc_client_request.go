@@ -0,0 +1,37 @@
+package vo
+
+// Create an OIDC client in the panel
+type CreateOAuthClientRequest struct {
+	Name         string   `json:"name" form:"name" validate:"required,min=1,max=100"`
+	RedirectURIs []string `json:"redirectUris" form:"redirectUris" validate:"required,min=1,dive,redirectURI"`
+	Scopes       []string `json:"scopes" form:"scopes" validate:"dive,oneof=openid profile email groups"`              // All supported scopes if empty
+	GrantTypes   []string `json:"grantTypes" form:"grantTypes" validate:"dive,oneof=authorization_code refresh_token"` // All supported grant types if empty
+	FirstParty   bool     `json:"firstParty" form:"firstParty"`                                                        // The users are not asked for consent
+}
+
+// Update an OIDC client
+type UpdateOAuthClientRequest struct {
+	CreateOAuthClientRequest
+	Status uint `json:"status" form:"status" validate:"oneof=1 2"`
+}
+
+// Delete OIDC clients
+type DeleteOAuthClientRequest struct {
+	Ids []uint `json:"ids" form:"ids" validate:"required,min=1"`
+}
+
+// Answer of the user to the consent page
+type ConsentRequest struct {
+	ID     string `form:"id" validate:"required"`
+	Action string `form:"action" validate:"required,oneof=allow deny"`
+}
+
+// Dynamic client registration request (RFC 7591 section 2)
+type RegisterClientRequest struct {
+	RedirectURIs            []string `json:"redirect_uris"`
+	ClientName              string   `json:"client_name"`
+	GrantTypes              []string `json:"grant_types"`
+	ResponseTypes           []string `json:"response_types"`
+	Scope                   string   `json:"scope"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+}
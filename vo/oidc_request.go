@@ -8,18 +8,31 @@ type Authorize struct {
 	ResponseType string `json:"response_type" form:"response_type" validate:"required,eq=code"`
 	Scope        string `json:"scope" form:"scope"`
 	State        string `json:"state" form:"state"`
-	//Code         string `json:"code"`
+	Nonce        string `json:"nonce" form:"nonce" validate:"max=255"`
+	Prompt       string `json:"prompt" form:"prompt"` // Space separated none, login, consent or select_account
+	// PKCE (RFC 7636), only S256 is supported
+	CodeChallenge       string `json:"code_challenge" form:"code_challenge" validate:"omitempty,min=43,max=128"`
+	CodeChallengeMethod string `json:"code_challenge_method" form:"code_challenge_method" validate:"required_with=CodeChallenge,omitempty,eq=S256"`
+}
+
+// TokenOperation represents the introspection (RFC 7662) and the revocation (RFC 7009) request parameters.
+type TokenOperation struct {
+	Token         string `json:"token" form:"token" validate:"required"`
+	TokenTypeHint string `json:"token_type_hint" form:"token_type_hint"` // access_token or refresh_token, the token is searched in both anyway
+	ClientID      string `json:"client_id" form:"client_id"`             // or in the basic authorization
+	ClientSecret  string `json:"client_secret" form:"client_secret"`     // or in the basic authorization
 }
 
 // Token represents the token request parameters.
 type Token struct {
-	ClientID            string `json:"client_id" form:"client_id" validate:"required_if=GrantType authorization_code"`                                  // used to register a client and get token
-	ClientSecret        string `json:"client_secret" form:"client_secret" validate:"required_if=GrantType refresh_token|required_with_all=RedirectURI"` // used to refresh token and get token
-	Code                string `json:"code" form:"code" validate:"required_with_all=ClientID"`                                                          // used to register a client and get token
-	RedirectURI         string `json:"redirect_uri" form:"redirect_uri" validate:"required_with_all=ClientID ClientSecret,url"`                         // only used in get token
+	ClientID            string `json:"client_id" form:"client_id"`                                                                         // used to register a client and get token, or in the basic authorization
+	ClientSecret        string `json:"client_secret" form:"client_secret"`                                                                 // used to refresh token and get token, or in the basic authorization
+	Code                string `json:"code" form:"code" validate:"required_if=GrantType authorization_code"`                               // used to register a client and get token
+	RedirectURI         string `json:"redirect_uri" form:"redirect_uri" validate:"required_if=GrantType authorization_code,omitempty,url"` // only used in get token
 	GrantType           string `json:"grant_type" form:"grant_type" validate:"required"`
-	RefreshToken        string `json:"refresh_token" form:"refresh_token" validate:"required_if=GrantType refresh_token"`              // only used in refresh token
-	Scope               string `json:"scope" form:"scope" validate:"required_if=GrantType refresh_token"`                              // only used in refresh token
-	ClientAssertionType string `json:"client_assertion_type" form:"client_assertion_type" validate:"required_without_all=RedirectURI"` // only used in client authorization
-	ClientAssertion     string `json:"client_assertion" form:"client_assertion" validate:"required_without_all=RedirectURI"`           // only used in client authorization
+	RefreshToken        string `json:"refresh_token" form:"refresh_token" validate:"required_if=GrantType refresh_token"` // only used in refresh token
+	Scope               string `json:"scope" form:"scope"`                                                                // only used in refresh token, a subset of the granted scopes
+	ClientAssertionType string `json:"client_assertion_type" form:"client_assertion_type"`                                // only used in client authorization
+	ClientAssertion     string `json:"client_assertion" form:"client_assertion"`                                          // only used in client authorization
+	CodeVerifier        string `json:"code_verifier" form:"code_verifier" validate:"omitempty,min=43,max=128"`            // only used in get token, the PKCE verifier
 }
@@ -0,0 +1,59 @@
+package vo
+
+import "encoding/json"
+
+// SCIM list query, startIndex is 1-based
+type ScimListRequest struct {
+	Filter     string `form:"filter"`
+	StartIndex int    `form:"startIndex"`
+	Count      int    `form:"count"`
+}
+
+// SCIM user resource for create and replace
+type ScimUserRequest struct {
+	ExternalID string `json:"externalId"`
+	UserName   string `json:"userName" validate:"required,min=2,max=63"`
+	Name       struct {
+		Formatted  string `json:"formatted"`
+		GivenName  string `json:"givenName"`
+		FamilyName string `json:"familyName"`
+	} `json:"name"`
+	DisplayName string `json:"displayName"`
+	Emails      []struct {
+		Value   string `json:"value"`
+		Primary bool   `json:"primary"`
+	} `json:"emails"`
+	Active   *bool  `json:"active"`
+	Password string `json:"password"`
+}
+
+// SCIM group resource for create and replace
+type ScimGroupRequest struct {
+	DisplayName string `json:"displayName" validate:"required,min=1,max=20"`
+	Members     []struct {
+		Value string `json:"value"`
+	} `json:"members"`
+}
+
+// SCIM PATCH request (RFC 7644 section 3.5.2)
+type ScimPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []ScimPatchOperation `json:"Operations" validate:"required,min=1"`
+}
+
+type ScimPatchOperation struct {
+	Op    string          `json:"op" validate:"required"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Create a SCIM bearer token
+type CreateScimTokenRequest struct {
+	Name       string `json:"name" form:"name" validate:"required,min=1,max=50"`
+	ExpireDays uint   `json:"expireDays" form:"expireDays" validate:"lte=3650"` // 0 never expires
+}
+
+// Delete SCIM bearer tokens
+type DeleteScimTokenRequest struct {
+	Ids []uint `json:"ids" form:"ids" validate:"required,min=1"`
+}
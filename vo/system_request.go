@@ -11,4 +11,23 @@ type SystemSettingHeadscale struct {
 	Insecure   bool   `json:"grpc_allow_insecure" form:"grpc_allow_insecure"`
 	BaseDomain string `json:"base_domain" form:"base_domain"`
 	Yaml       string `json:"yaml" form:"yaml"`
+	Comment    string `json:"comment" form:"comment" validate:"max=200"`
+}
+
+// HeadscaleConfigVersionListRequest lists the saved versions of the headscale config
+type HeadscaleConfigVersionListRequest struct {
+	Creator  string `json:"creator" form:"creator"`
+	PageNum  int    `json:"pageNum" form:"pageNum"`
+	PageSize int    `json:"pageSize" form:"pageSize"`
+}
+
+// HeadscaleConfigVersionDiffRequest compares two versions of the headscale config, 0 is the current config
+type HeadscaleConfigVersionDiffRequest struct {
+	From uint `json:"from" form:"from"`
+	To   uint `json:"to" form:"to"`
+}
+
+// RestoreHeadscaleConfigRequest applies a previous version of the headscale config again, the id is in the path
+type RestoreHeadscaleConfigRequest struct {
+	Comment string `json:"comment" validate:"max=200"`
 }
@@ -6,6 +6,19 @@ type RegisterAndLoginRequest struct {
 	Password string `form:"password" json:"password" binding:"required"`
 }
 
+// Login with the ticket issued by the sso callback
+type SsoLoginRequest struct {
+	Ticket string `form:"ticket" json:"ticket" binding:"required"`
+}
+
+// Callback parameters of the external identity provider
+type SsoCallbackRequest struct {
+	Code             string `form:"code" json:"code"`
+	State            string `form:"state" json:"state"`
+	Error            string `form:"error" json:"error"`
+	ErrorDescription string `form:"error_description" json:"error_description"`
+}
+
 // Creating User Structures
 type CreateUserRequest struct {
 	Username string `form:"username" json:"username" validate:"required,lowercase,min=2,max=63"`
@@ -40,3 +53,67 @@ type ChangePwdRequest struct {
 	OldPassword string `json:"oldPassword" form:"oldPassword" validate:"required"`
 	NewPassword string `json:"newPassword" form:"newPassword" validate:"required"`
 }
+
+// Revoke sessions of the current user, all other sessions if it is empty
+type RevokeSessionRequest struct {
+	SessionIds []uint `json:"sessionIds" form:"sessionIds"`
+}
+
+// Create a personal access token
+type CreateAccessTokenRequest struct {
+	Name       string             `json:"name" form:"name" validate:"required,min=1,max=50"`
+	ExpireDays uint               `json:"expireDays" form:"expireDays" validate:"lte=3650"` // 0 never expires
+	Scopes     []AccessTokenScope `json:"scopes" form:"scopes" validate:"required,min=1,dive"`
+}
+
+type AccessTokenScope struct {
+	Path   string `json:"path" validate:"required"`
+	Method string `json:"method" validate:"required"`
+}
+
+// Revoke personal access tokens
+type DeleteAccessTokenRequest struct {
+	TokenIds []uint `json:"tokenIds" form:"tokenIds" validate:"required,min=1"`
+}
+
+// Import users from a CSV or JSON file
+type ImportUsersRequest struct {
+	Format               string `json:"format" form:"format" validate:"required,oneof=csv json"`
+	Content              string `json:"content" form:"content" validate:"required"`                          // Content of the file
+	Invite               bool   `json:"invite" form:"invite"`                                                // Users without a password get an invite to set it
+	InviteExpireDays     uint   `json:"inviteExpireDays" form:"inviteExpireDays" validate:"lte=30"`          // 0 is 7 days
+	CreateHeadscaleUser  bool   `json:"createHeadscaleUser" form:"createHeadscaleUser"`                      // Create the headscale user with the same name
+	CreatePreAuthKey     bool   `json:"createPreAuthKey" form:"createPreAuthKey"`                            // Create a pre-auth key of the headscale user
+	PreAuthKeyReusable   bool   `json:"preAuthKeyReusable" form:"preAuthKeyReusable"`                        // The pre-auth key is reusable
+	PreAuthKeyExpireDays uint   `json:"preAuthKeyExpireDays" form:"preAuthKeyExpireDays" validate:"lte=365"` // 0 is 1 day
+}
+
+// A user of the imported file, the roles are role keywords
+type ImportUser struct {
+	Username string   `json:"username" validate:"required,lowercase,min=2,max=63"`
+	Email    string   `json:"email" validate:"required,email"`
+	Nickname string   `json:"nickname" validate:"min=0,max=20"`
+	Roles    []string `json:"roles" validate:"required,min=1"`
+	Password string   `json:"password" validate:"omitempty,min=6"`
+}
+
+// Export users
+type ExportUsersRequest struct {
+	Format string `json:"format" form:"format" validate:"omitempty,oneof=csv json"` // csv by default
+}
+
+// Set the password with an invite
+type AcceptInviteRequest struct {
+	Token    string `json:"token" form:"token" validate:"required"`
+	Password string `json:"password" form:"password" validate:"required"`
+}
+
+// Replace the headscale users mapped to a panel user
+type SetHeadscaleUserMappingRequest struct {
+	Mappings []HeadscaleUserMappingItem `json:"mappings" form:"mappings" validate:"dive"` // Empty falls back to the headscale user with the same name
+}
+
+type HeadscaleUserMappingItem struct {
+	HeadscaleUser string `json:"headscaleUser" validate:"required,min=1,max=63"`
+	Permission    string `json:"permission" validate:"required,oneof=view manage"`
+}